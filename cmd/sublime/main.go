@@ -1,49 +1,70 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
-	"github.com/PietroCarrara/sublime/pkg/guessit"
 	"github.com/PietroCarrara/sublime/pkg/sublime"
-	"github.com/agnivade/levenshtein"
+	"github.com/PietroCarrara/sublime/pkg/subtitle"
 	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
 	"golang.org/x/text/language"
 
 	"github.com/jeandeaual/go-locale"
 
 	// Implemented services:
+	_ "github.com/PietroCarrara/sublime/pkg/sublime/services/addic7ed"
+	_ "github.com/PietroCarrara/sublime/pkg/sublime/services/generic"
+	_ "github.com/PietroCarrara/sublime/pkg/sublime/services/legendastv"
+	_ "github.com/PietroCarrara/sublime/pkg/sublime/services/local"
 	_ "github.com/PietroCarrara/sublime/pkg/sublime/services/opensubtitles"
+	_ "github.com/PietroCarrara/sublime/pkg/sublime/services/opensubtitlescom"
 )
 
-type releaseType int
-
-const (
-	bluray releaseType = iota
-	hdtv
-	cam
-	dvd
-	web
-
-	unknown
-)
+// scorer ranks the candidates. Its weights are configured with "scorer.component=weight"
+var scorer = sublime.NewDefaultScorer()
 
 var argLangList = flag.String("languages", "", "comma-separated language list for subtitles")
 var argServiceList = flag.String("services", "", "comma-separated service list for subtitles")
-var argConfigList = flag.String("config", "", `space-separated list of config values to set in the form service.option=my\ value`)
+var argConfigList = flag.String("config", "", `space-separated list of config values to set in the form service.option=my\ value (use "scorer.component=weight" to tune scoring)`)
 var argLangNames = flag.String("lnames", "", "comma-separated list of languages to rename in the output (example: pt-Br=pt)")
+var argConcurrency = flag.Int("concurrency", 4, "maximum number of subtitles downloaded at the same time")
+var argFormat = flag.String("format", "", "convert subtitles to this format (srt, vtt, ass or ssa). By default, they are saved as downloaded")
+var argEncoding = flag.String("encoding", "utf-8", `character encoding of the saved subtitles (example: windows-1252). "original" keeps the encoding they were downloaded in`)
+var argSync = flag.Bool("sync", false, "align the timings of the downloaded subtitles to the ones already next to the videos")
+var argForce = flag.Bool("force", false, "download subtitles even for languages the videos already have, next to them or embedded")
+var argTimeout = flag.Duration("timeout", 0, "maximum duration of the whole run (example: 5m). 0 means no limit")
+var argNoCache = flag.Bool("no-cache", false, "don't reuse the searches and downloads of previous runs, nor save the new ones")
+var argCacheOnly = flag.Bool("cache-only", false, "only use what is cached, never searching or downloading")
+var argCacheTTL = flag.Duration("cache-ttl", 24*time.Hour, "how long searches and downloads are cached. 0 keeps them forever")
 
 func main() {
 	log.SetFlags(log.Llongfile)
 
 	flag.Parse()
 
+	if flag.Arg(0) == "sync" {
+		if err := runSync(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "parse" {
+		if err := runParse(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	languages := getLanguages(*argLangList)
 	lnames, err := getLangNames(languages, *argLangNames)
 	if err != nil {
@@ -55,78 +76,132 @@ func main() {
 		log.Fatal(err)
 	}
 
-	services, err := getServicesOrAll(*argServiceList)
+	format, err := getFormat(*argFormat)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = configServices(*argConfigList)
+	enc, keepEncoding, err := getEncoding(*argEncoding)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for i, s := range services {
-		err := s.Initialize()
-		if err != nil {
-			log.Println(fmt.Errorf("%s: %s", s.GetName(), err))
-			services[i] = nil
-		}
+	quotas := quotaServices()
+	limitServices()
+	// The options of the services are part of the cache keys, so they are set once cached
+	err = cacheServices()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	chans := make([]<-chan sublime.SubtitleCandidate, len(services))
-	for i := range chans {
-		if services[i] != nil {
-			chans[i] = services[i].GetCandidatesForFiles(targets, languages)
-		}
+	services, err := getServicesOrAll(*argServiceList)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	channel := unifyChannels(chans)
+	err = configServices(*argConfigList)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	best := make(map[*sublime.FileTarget]map[language.Tag]sublime.SubtitleCandidate)
-	count := 0
-	for sub := range channel {
-		count++
+	ctx, cancel := context.WithCancel(context.Background())
+	if *argTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *argTimeout)
+	}
+	defer cancel()
+	cancelOnInterrupt(cancel)
+
+	interactive := false
+	if fileInfo, _ := os.Stdout.Stat(); (fileInfo.Mode() & os.ModeCharDevice) != 0 {
+		interactive = true
+	}
+
+	downloader := sublime.NewDownloader(sublime.DownloaderOptions{
+		Languages:    languages,
+		Services:     services,
+		Naming:       sublime.SidecarName(lnames),
+		Concurrency:  *argConcurrency,
+		Scorer:       scorer,
+		Format:       format,
+		Encoding:     enc,
+		KeepEncoding: keepEncoding,
+		Sync:         *argSync,
+		Force:        *argForce,
+		Progress: func(count int) {
+			if interactive {
+				fmt.Printf("\rEvaluating %d subtitles...", count)
+			}
+		},
+	})
 
-		// If we're in a interactive shell
-		if fileInfo, _ := os.Stdout.Stat(); (fileInfo.Mode() & os.ModeCharDevice) != 0 {
-			fmt.Printf("\rEvaluating %d subtitles...", count)
+	report, err := downloader.Download(ctx, targets)
+	if report != nil && *argServiceList == "" {
+		report.Errors = withoutUnconfigured(report.Errors)
+	}
+	if err != nil {
+		if report != nil {
+			printErrors(report.Errors)
 		}
+		log.Fatal(err)
+	}
+
+	if !interactive {
+		fmt.Printf("Evaluating %d subtitles...", report.Evaluated)
+	}
+	fmt.Println()
 
-		f := sub.GetFileTarget()
-		l := sub.GetLang()
-		if best[f] == nil {
-			best[f] = make(map[language.Tag]sublime.SubtitleCandidate)
+	failures := report.Errors
+	for _, res := range report.Results {
+		if res.Skipped != "" {
+			fmt.Printf("%s [%s]: skipped (%s subtitle found)\n", res.File, res.Lang, res.Skipped)
+		} else if res.Ok() && res.Alignment != nil {
+			fmt.Printf("%s [%s]: ✓ (synced: %s)\n", res.File, res.Lang, formatAlignment(*res.Alignment))
+		} else if res.Ok() {
+			fmt.Printf("%s [%s]: ✓\n", res.File, res.Lang)
+		} else {
+			fmt.Printf("%s [%s]: ✗\n", res.File, res.Lang)
 		}
-		if best[f][l] == nil || greater(f.GetInfo(), sub, best[f][l]) {
-			best[f][l] = sub
+		if res.Err != nil {
+			failures = append(failures, res.Err)
 		}
 	}
-	// If we're not in a interactive shell
-	if fileInfo, _ := os.Stdout.Stat(); (fileInfo.Mode() & os.ModeCharDevice) == 0 {
-		fmt.Printf("Evaluating %d subtitles...", count)
+
+	printQuotas(quotas)
+
+	if len(failures) > 0 {
+		printErrors(failures)
+		os.Exit(1)
 	}
-	fmt.Println()
+}
 
-	for _, f := range targets {
-		for _, l := range languages {
-			if sub, ok := best[f][l]; ok {
-				stream, err := sub.Open()
-				if err != nil {
-					log.Printf(`could not download subtitle for "%s": %s`, f, err)
-					if stream != nil {
-						stream.Close()
-					}
-					fmt.Printf("%s: ✗\n", f)
-					continue
-				}
-				f.SaveSubtitle(stream, lnames[sub.GetLang()], sub.GetFormatExtension())
-				stream.Close()
-				fmt.Printf("%s [%s]: ✓\n", f, l)
-			} else {
-				fmt.Printf("%s [%s]: ✗\n", f, l)
-			}
+func printErrors(errs []error) {
+	fmt.Fprintln(os.Stderr, "\nErrors:")
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// withoutUnconfigured drops the errors of services that were not set up. When
+// no services are chosen all of them are tried, even those the user doesn't have
+func withoutUnconfigured(errs []error) []error {
+	var res []error
+	for _, err := range errs {
+		if !errors.Is(err, sublime.ErrNotConfigured) {
+			res = append(res, err)
 		}
 	}
+	return res
+}
+
+// cancelOnInterrupt calls cancel when the user interrupts the program
+func cancelOnInterrupt(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		cancel()
+	}()
 }
 
 func getLanguages(langs string) []language.Tag {
@@ -154,6 +229,32 @@ func getLanguages(langs string) []language.Tag {
 	return languages
 }
 
+func getFormat(format string) (subtitle.Format, error) {
+	if format == "" {
+		return "", nil
+	}
+
+	f, err := subtitle.FormatFromExtension(format)
+	if err != nil {
+		return "", errors.Errorf(`invalid subtitle format "%s"`, format)
+	}
+	return f, nil
+}
+
+// getEncoding returns the encoding with the given name, or
+// wether the original encoding should be kept
+func getEncoding(name string) (encoding.Encoding, bool, error) {
+	if name == "original" {
+		return nil, true, nil
+	}
+
+	enc, err := subtitle.GetEncoding(name)
+	if err != nil {
+		return nil, false, errors.Errorf(`invalid encoding "%s"`, name)
+	}
+	return enc, false, nil
+}
+
 func getLangNames(langs []language.Tag, lnames string) (map[language.Tag]string, error) {
 	res := make(map[language.Tag]string)
 
@@ -242,6 +343,72 @@ func getServicesOrAll(services string) ([]sublime.Service, error) {
 	return res, nil
 }
 
+// quotaReporter is implemented by services that allow a limited number of downloads
+type quotaReporter interface {
+	// Returns how many downloads are left and when the quota will be reset. remaining is -1 if unknown
+	Quota() (remaining int, resetTime string)
+}
+
+// quotaServices returns the services that report their download quota, by name.
+// They have to be taken before being wrapped, which hides their methods
+func quotaServices() map[string]quotaReporter {
+	res := make(map[string]quotaReporter)
+	for name, s := range sublime.Services {
+		if q, ok := s.(quotaReporter); ok {
+			res[name] = q
+		}
+	}
+	return res
+}
+
+// printQuotas shows the downloads left in the services that reported their quota
+func printQuotas(quotas map[string]quotaReporter) {
+	for name, q := range quotas {
+		remaining, reset := q.Quota()
+		if remaining < 0 {
+			continue
+		}
+		if reset != "" {
+			fmt.Printf("%s: %d downloads left (reset at %s)\n", name, remaining, reset)
+		} else {
+			fmt.Printf("%s: %d downloads left\n", name, remaining)
+		}
+	}
+}
+
+// limitServices lets every service be rate limited and retried,
+// with options like "opensubtitles.rateLimit=40/10s"
+func limitServices() {
+	for name, s := range sublime.Services {
+		sublime.Services[name] = sublime.Limit(s)
+	}
+}
+
+// cacheServices makes the services use the cache, as configured by the flags
+func cacheServices() error {
+	if *argCacheOnly && *argNoCache {
+		return errors.New("-cache-only and -no-cache can't be used together")
+	}
+	if *argNoCache {
+		return nil
+	}
+
+	dir, err := sublime.DefaultCacheDir()
+	if err != nil {
+		return err
+	}
+	mode := sublime.CacheReadWrite
+	if *argCacheOnly {
+		mode = sublime.CacheOnly
+	}
+	cache := sublime.NewCache(dir, *argCacheTTL, mode)
+
+	for name, s := range sublime.Services {
+		sublime.Services[name] = cache.Wrap(s)
+	}
+	return nil
+}
+
 var separationRegex = regexp.MustCompile(`[^\\] `)
 
 func configServices(list string) error {
@@ -292,7 +459,11 @@ func setConfig(config string) error {
 	key := parts[0]
 	value := parts[1]
 
-	if s, ok := sublime.Services[service]; ok {
+	if service == "scorer" {
+		if err := scorer.SetConfig(key, value); err != nil {
+			return fmt.Errorf("%s: %s", service, err)
+		}
+	} else if s, ok := sublime.Services[service]; ok {
 		err := s.SetConfig(key, value)
 		if err != nil {
 			return fmt.Errorf("%s: %s", service, err)
@@ -303,168 +474,3 @@ func setConfig(config string) error {
 
 	return nil
 }
-
-// greater returns wether A is a better match than B is
-// when compared to target
-func greater(target guessit.Information, subA, subB sublime.SubtitleCandidate) bool {
-	a := subA.GetInfo()
-	b := subB.GetInfo()
-
-	distance := func(a, b string) int {
-		return levenshtein.ComputeDistance(strings.ToLower(a), strings.ToLower(b))
-	}
-
-	// Release type is the greatest factor, if the target is not a specific version
-	if !target.Extended && !target.DirectorsCut && !target.Theatrical {
-		if p(target.Release) == p(a.Release) && p(target.Release) != p(b.Release) {
-			return true
-		}
-		if p(target.Release) == p(b.Release) && p(target.Release) != p(a.Release) {
-			return false
-		}
-	}
-
-	if target.Extended == a.Extended && target.Extended != b.Extended {
-		return true
-	}
-	if target.Extended == b.Extended && target.Extended != a.Extended {
-		return false
-	}
-
-	if target.Theatrical == a.Theatrical && target.Theatrical != b.Theatrical {
-		return true
-	}
-	if target.Theatrical == b.Theatrical && target.Theatrical != a.Theatrical {
-		return false
-	}
-
-	if target.DirectorsCut == a.Theatrical && target.DirectorsCut != b.DirectorsCut {
-		return true
-	}
-	if target.DirectorsCut == b.Theatrical && target.DirectorsCut != a.DirectorsCut {
-		return false
-	}
-
-	if target.Remastered == a.Remastered && target.Remastered != b.Remastered {
-		return true
-	}
-	if target.Remastered == b.Remastered && target.Remastered != a.Remastered {
-		return false
-	}
-
-	if subA.GetService() == subB.GetService() {
-		if subA.GetRanking() > subB.GetRanking() {
-			return true
-		} else if subA.GetRanking() < subB.GetRanking() {
-			return false
-		}
-	}
-
-	if distance(target.Title, a.Title) < distance(target.Title, b.Title) {
-		return true
-	}
-
-	return false
-}
-
-// alias to parseRelease
-func p(s string) releaseType {
-	return parseRelease(s)
-}
-
-func parseRelease(t string) releaseType {
-	t = strings.ToLower(t)
-
-	switch t {
-	case "cam-rip",
-		"cam",
-		"hdcam":
-		return cam
-
-	case "dvdr",
-		"dvdrip",
-		"dvd-full",
-		"full-rip",
-		"iso rip",
-		"lossless rip",
-		"untouched rip",
-		"dvd-5",
-		"dvd-9":
-		return dvd
-
-	case "dsr",
-		"dsrip",
-		"satrip",
-		"dthrip",
-		"dvbrip",
-		"hdtv",
-		"pdtv",
-		"dtvrip",
-		"tvrip",
-		"hdtvrip":
-		return hdtv
-
-	case "webdl",
-		"web dl",
-		"web-dl",
-		"hdrip",
-		"web-dlrip",
-		"webrip",
-		"web rip",
-		"web-rip",
-		"web",
-		"web-cap",
-		"webcap",
-		"web cap",
-		"hc",
-		"hd-rip":
-		return web
-
-	case "blu-ray",
-		"bluray",
-		"blu ray",
-		"bdrip",
-		"brip",
-		"brrip",
-		"bdmv",
-		"bdr",
-		"bd25",
-		"bd50",
-		"bd5",
-		"bd9":
-		return bluray
-
-	default:
-		return unknown
-	}
-}
-
-func unifyChannels(channels []<-chan sublime.SubtitleCandidate) <-chan sublime.SubtitleCandidate {
-	res := make(chan sublime.SubtitleCandidate)
-	totalOpenChannels := len(channels)
-
-	for _, c := range channels {
-		if c == nil {
-			totalOpenChannels--
-			continue
-		}
-		c := c
-		go func() {
-			for {
-				sub, ok := <-c
-
-				if ok {
-					res <- sub
-				} else {
-					totalOpenChannels--
-					if totalOpenChannels <= 0 {
-						close(res)
-					}
-					return
-				}
-			}
-		}()
-	}
-
-	return res
-}
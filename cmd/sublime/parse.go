@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"github.com/pkg/errors"
+)
+
+// runParse implements "sublime parse name...", which shows what is recognised
+// in release names (or paths), for debugging the matching of subtitles
+func runParse(args []string) error {
+	flags := flag.NewFlagSet("parse", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: sublime parse name...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("expected a name to parse")
+	}
+
+	for i, p := range flags.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		printParse(p)
+	}
+	return nil
+}
+
+// printParse shows a name with what was recognised in it between brackets,
+// followed by each field, where it was found and how sure the parse is of it
+func printParse(p string) {
+	name := path.Base(strings.ReplaceAll(p, "\\", "/"))
+	details := guessit.ParseDetailed(name)
+	info := guessit.ParsePathDetailed(p)
+
+	fmt.Println(highlight(name, details.Spans))
+
+	v, own := reflect.ValueOf(info.Information), reflect.ValueOf(details.Information)
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i).Name, v.Field(i)
+		if field == "Rest" || value.IsZero() {
+			continue
+		}
+
+		// Fields the name doesn't have were taken from the directories
+		found := ""
+		if span, ok := details.Spans[field]; ok {
+			found = name[span.Start:span.End]
+		} else if !reflect.DeepEqual(value.Interface(), own.Field(i).Interface()) {
+			found = "(directory)"
+		}
+		confidence := fmt.Sprintf("%3.0f%%", info.GetConfidence(field)*100)
+		if field == "Title" {
+			confidence = ""
+		}
+		line := fmt.Sprintf("  %-16s %-28s %-16s %s", field, formatValue(value.Interface()), found, confidence)
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	if len(info.Rest) > 0 {
+		fmt.Printf("  %-16s %s\n", "Rest", strings.Join(info.Rest, " "))
+	}
+}
+
+// highlight puts the spans of a name between brackets, joining the ones that overlap
+func highlight(name string, spans map[string]guessit.Span) string {
+	sorted := make([]guessit.Span, 0, len(spans))
+	for _, s := range spans {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(sorted); i++ {
+		s := sorted[i]
+		for i+1 < len(sorted) && sorted[i+1].Start < s.End {
+			i++
+			if sorted[i].End > s.End {
+				s.End = sorted[i].End
+			}
+		}
+		if s.Start < last {
+			s.Start = last
+		}
+
+		b.WriteString(name[last:s.Start])
+		b.WriteString("[" + name[s.Start:s.End] + "]")
+		last = s.End
+	}
+	b.WriteString(name[last:])
+	return b.String()
+}
+
+func formatValue(value interface{}) string {
+	if t, ok := value.(time.Time); ok {
+		return t.Format("2006-01-02")
+	}
+	return fmt.Sprint(value)
+}
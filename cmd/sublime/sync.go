@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"github.com/PietroCarrara/sublime/pkg/subtitle"
+	"github.com/pkg/errors"
+	"golang.org/x/text/language"
+)
+
+// runSync implements "sublime sync [-o output] [-force] reference subtitle",
+// which aligns the timings of subtitle to the ones of reference
+func runSync(args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	output := flags.String("o", "", "where to save the synced subtitle. Defaults to overwriting it")
+	force := flags.Bool("force", false, "save the subtitle even if it doesn't match the reference well")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: sublime sync [-o output] [-force] reference subtitle\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errors.New("expected a reference and a subtitle")
+	}
+	refPath, subPath := flags.Arg(0), flags.Arg(1)
+	if *output == "" {
+		*output = subPath
+	}
+
+	reference, err := sublime.LoadSubtitle(refPath)
+	if err != nil {
+		return errors.Wrapf(err, `could not read "%s"`, refPath)
+	}
+
+	data, err := ioutil.ReadFile(subPath)
+	if err != nil {
+		return err
+	}
+	data, err = subtitle.ToUTF8(data, language.Und)
+	if err != nil {
+		return errors.Wrapf(err, `could not read "%s"`, subPath)
+	}
+
+	synced, alignment, err := sublime.SyncSubtitle(data, filepath.Ext(subPath), reference)
+	if err == sublime.ErrSyncConfidence && *force {
+		sub, err := subtitle.Parse(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		alignment.Apply(sub)
+
+		var buf bytes.Buffer
+		if err := subtitle.Write(&buf, sub, sub.Format); err != nil {
+			return err
+		}
+		synced = buf.Bytes()
+	} else if err == sublime.ErrSyncConfidence {
+		return errors.Errorf("only %.0f%% of the lines match the reference, use -force to sync anyway", alignment.Confidence()*100)
+	} else if err != nil {
+		return errors.Wrapf(err, `could not sync "%s"`, subPath)
+	}
+
+	fmt.Printf("%s: %s\n", subPath, formatAlignment(alignment))
+	return ioutil.WriteFile(*output, synced, 0644)
+}
+
+// formatAlignment describes a timing correction
+func formatAlignment(a subtitle.Alignment) string {
+	res := fmt.Sprintf("offset %+.3fs", a.Offset.Seconds())
+	if a.Scale != 1 {
+		res += fmt.Sprintf(", speed ×%.5f", a.Scale)
+	}
+	return res + fmt.Sprintf(" (%.0f%% of the lines match)", a.Confidence()*100)
+}
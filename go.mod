@@ -9,11 +9,11 @@ require (
 	github.com/andybalholm/brotli v1.0.4 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/jeandeaual/go-locale v0.0.0-20211014152413-b809787f45c8
-	github.com/klauspost/compress v1.13.6 // indirect
-	github.com/kolo/xmlrpc v0.0.0-20201022064351-38db28db192b // indirect
+	github.com/klauspost/compress v1.13.6
+	github.com/kolo/xmlrpc v0.0.0-20201022064351-38db28db192b
 	github.com/kr/text v0.2.0 // indirect
 	github.com/mholt/archiver/v3 v3.5.1
-	github.com/nwaples/rardecode v1.1.2 // indirect
+	github.com/nwaples/rardecode v1.1.2
 	github.com/oz/osdb v0.0.0-20190204162748-da06ada9cdc1
 	github.com/pierrec/lz4/v4 v4.1.11 // indirect
 	github.com/pkg/errors v0.9.1
@@ -21,4 +21,5 @@ require (
 	golang.org/x/net v0.0.0-20211123203042-d83791d6bcd9 // indirect
 	golang.org/x/sys v0.0.0-20211124211545-fe61309f8881 // indirect
 	golang.org/x/text v0.3.7
+	gopkg.in/yaml.v3 v3.0.1
 )
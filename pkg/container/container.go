@@ -0,0 +1,146 @@
+package container
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// ErrUnsupported is returned for files that are neither Matroska nor MP4
+var ErrUnsupported = errors.New("unsupported container format")
+
+// TrackType is the kind of data a track holds
+type TrackType int
+
+// Track types
+const (
+	UnknownTrack TrackType = iota
+	VideoTrack
+	AudioTrack
+	SubtitleTrack
+)
+
+func (t TrackType) String() string {
+	switch t {
+	case VideoTrack:
+		return "video"
+	case AudioTrack:
+		return "audio"
+	case SubtitleTrack:
+		return "subtitle"
+	default:
+		return "unknown"
+	}
+}
+
+// Track is a stream of a media file
+type Track struct {
+	ID       int          // Track number (Matroska) or ID (MP4)
+	Type     TrackType    // Kind of the track
+	Codec    string       // Codec identifier, as found in the file (eg. "S_TEXT/UTF8", "tx3g")
+	Language language.Tag // Language of the track. language.Und if unknown
+	Name     string       // Name of the track. "" if none
+	Default  bool         // Should this track be selected by default?
+	Forced   bool         // Does this track only hold forced subtitles?
+
+	// Frames per second of a video track. 0 if unknown or not a video track
+	FrameRate float64
+}
+
+// Info holds the metadata of a media file
+type Info struct {
+	Format   string        // Container format: "matroska", "webm" or "mp4"
+	Title    string        // Title stored in the container. "" if none
+	Duration time.Duration // Duration of the whole file. 0 if unknown
+	Tracks   []Track       // Tracks of the file, in the order they are declared
+}
+
+// FrameRate returns the frame rate of the first video track with a known one. 0 if there's none
+func (i *Info) FrameRate() float64 {
+	for _, track := range i.TracksOf(VideoTrack) {
+		if track.FrameRate > 0 {
+			return track.FrameRate
+		}
+	}
+	return 0
+}
+
+// Languages returns the distinct known languages of the tracks of a given type, in order
+func (i *Info) Languages(t TrackType) []language.Tag {
+	var res []language.Tag
+	seen := make(map[language.Tag]bool)
+	for _, track := range i.TracksOf(t) {
+		if track.Language != language.Und && !seen[track.Language] {
+			seen[track.Language] = true
+			res = append(res, track.Language)
+		}
+	}
+	return res
+}
+
+// TracksOf returns the tracks of a given type
+func (i *Info) TracksOf(t TrackType) []Track {
+	var res []Track
+	for _, track := range i.Tracks {
+		if track.Type == t {
+			res = append(res, track)
+		}
+	}
+	return res
+}
+
+// Probe reads the metadata of a Matroska or MP4 file of the given size.
+// Only the headers are read, so this is cheap even for large files
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	magic := make([]byte, 8)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		if err == io.EOF {
+			return nil, ErrUnsupported
+		}
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(magic[:4], []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		return probeMatroska(r, size)
+	case isMP4Box(magic[4:]):
+		return probeMP4(r, size)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// ProbeFile reads the metadata of a Matroska or MP4 file. See Probe
+func ProbeFile(path string) (*Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return Probe(file, stat.Size())
+}
+
+// parseLanguage parses ISO 639-2 codes and BCP 47 tags, returning language.Und if it fails
+func parseLanguage(lang string) language.Tag {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return language.Und
+	}
+	return tag
+}
+
+// roundFrameRate rounds a frame rate to 3 decimal places, so that 24000/1001 reads as 23.976
+func roundFrameRate(fps float64) float64 {
+	return math.Round(fps*1000) / 1000
+}
@@ -0,0 +1,222 @@
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// ebml encodes an EBML element. Children can be other elements, strings, bytes or floats
+func ebml(id uint32, children ...interface{}) []byte {
+	var data []byte
+	for _, child := range children {
+		switch v := child.(type) {
+		case []byte:
+			data = append(data, v...)
+		case string:
+			data = append(data, v...)
+		case int:
+			data = append(data, byte(v))
+		case float64:
+			buf := make([]byte, 8)
+			binary.BigEndian.PutUint64(buf, math.Float64bits(v))
+			data = append(data, buf...)
+		}
+	}
+
+	var header []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> uint(shift)); b != 0 || len(header) > 0 {
+			header = append(header, b)
+		}
+	}
+
+	// Sizes are always encoded in 8 bytes, which is valid EBML
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(data))|1<<56)
+	return append(append(header, size...), data...)
+}
+
+// box encodes an MP4 box
+func box(kind string, children ...[]byte) []byte {
+	data := bytes.Join(children, nil)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)+8))
+	copy(header[4:], kind)
+	return append(header, data...)
+}
+
+func TestProbeMatroska(t *testing.T) {
+	t.Parallel()
+
+	tracks := ebml(idTracks,
+		ebml(idTrackEntry, ebml(idTrackNumber, 1), ebml(idTrackType, mkvVideo), ebml(idCodecID, "V_MPEG4/ISO/AVC"), ebml(idDefaultDur, []byte{0x02, 0x7c, 0x6b, 0x2d})), // 41708333ns per frame
+		ebml(idTrackEntry, ebml(idTrackNumber, 2), ebml(idTrackType, mkvAudio), ebml(idCodecID, "A_AAC"), ebml(idLanguage, "jpn")),
+		ebml(idTrackEntry, ebml(idTrackNumber, 3), ebml(idTrackType, mkvSubtitle), ebml(idCodecID, "S_TEXT/ASS"), ebml(idLanguage, "por"), ebml(idLangIETF, "pt-BR"), ebml(idName, "Brasileiro"), ebml(idFlagDefault, 0)),
+		ebml(idTrackEntry, ebml(idTrackNumber, 4), ebml(idTrackType, mkvSubtitle), ebml(idCodecID, "S_TEXT/UTF8"), ebml(idFlagForced, 1)),
+	)
+	cluster := ebml(idCluster, make([]byte, 32))
+
+	// The tracks come after the first cluster, so they can only be found through the seek head
+	seekHead := ebml(idSeekHead, ebml(idSeek,
+		ebml(idSeekID, []byte{0x16, 0x54, 0xae, 0x6b}),
+		ebml(idSeekPos, 0, 0), // Placeholder, patched below
+	))
+	segmentInfo := ebml(idInfo, ebml(idDuration, 5400000.0), ebml(idTitle, "The Movie"))
+	position := len(seekHead) + len(segmentInfo) + len(cluster)
+	seekHead = ebml(idSeekHead, ebml(idSeek,
+		ebml(idSeekID, []byte{0x16, 0x54, 0xae, 0x6b}),
+		ebml(idSeekPos, position>>8, position&0xff),
+	))
+
+	file := append(
+		ebml(idEBML, ebml(idDocType, "matroska")),
+		ebml(idSegment, seekHead, segmentInfo, cluster, tracks)...,
+	)
+
+	info, err := Probe(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := &Info{
+		Format:   "matroska",
+		Title:    "The Movie",
+		Duration: 90 * time.Minute,
+		Tracks: []Track{
+			{ID: 1, Type: VideoTrack, Codec: "V_MPEG4/ISO/AVC", Language: language.English, Default: true, FrameRate: 23.976},
+			{ID: 2, Type: AudioTrack, Codec: "A_AAC", Language: language.Japanese, Default: true},
+			{ID: 3, Type: SubtitleTrack, Codec: "S_TEXT/ASS", Language: language.BrazilianPortuguese, Name: "Brasileiro"},
+			{ID: 4, Type: SubtitleTrack, Codec: "S_TEXT/UTF8", Language: language.English, Default: true, Forced: true},
+		},
+	}
+	if !reflect.DeepEqual(info, target) {
+		t.Errorf(`Expected info to be %+v, but got %+v`, target, info)
+	}
+}
+
+func TestProbeMP4(t *testing.T) {
+	t.Parallel()
+
+	trak := func(id byte, handler string, lang uint16, codec string) []byte {
+		tkhd := make([]byte, 16)
+		tkhd[3] = 1
+		tkhd[15] = id
+
+		mdhd := make([]byte, 22)
+		binary.BigEndian.PutUint32(mdhd[12:], 24000)
+		binary.BigEndian.PutUint16(mdhd[20:], lang)
+
+		// 100 frames of 1001 ticks
+		stts := make([]byte, 16)
+		stts[7] = 1
+		binary.BigEndian.PutUint32(stts[8:], 100)
+		binary.BigEndian.PutUint32(stts[12:], 1001)
+
+		hdlr := make([]byte, 24)
+		copy(hdlr[8:], handler)
+
+		return box("trak",
+			box("tkhd", tkhd),
+			box("mdia",
+				box("mdhd", mdhd),
+				box("hdlr", hdlr),
+				box("minf", box("stbl", box("stsd", make([]byte, 8), box(codec, make([]byte, 8))), box("stts", stts))),
+			),
+		)
+	}
+
+	// "eng" and "spa", packed
+	eng := uint16(5<<10 | 14<<5 | 7)
+	spa := uint16(19<<10 | 16<<5 | 1)
+
+	// 150 seconds in a timescale of 1000
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 150000)
+
+	file := bytes.Join([][]byte{
+		box("ftyp", []byte("isom")),
+		box("mdat", make([]byte, 64)),
+		box("moov",
+			box("mvhd", mvhd),
+			box("udta", box("meta", make([]byte, 4), box("ilst", box("\xa9nam", box("data", make([]byte, 8), []byte("The Show")))))),
+			trak(1, "vide", eng, "avc1"),
+			trak(2, "soun", spa, "mp4a"),
+			trak(3, "sbtl", spa, "tx3g"),
+		),
+	}, nil)
+
+	info, err := Probe(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := &Info{
+		Format:   "mp4",
+		Title:    "The Show",
+		Duration: 150 * time.Second,
+		Tracks: []Track{
+			{ID: 1, Type: VideoTrack, Codec: "avc1", Language: language.English, Default: true, FrameRate: 23.976},
+			{ID: 2, Type: AudioTrack, Codec: "mp4a", Language: language.Spanish, Default: true},
+			{ID: 3, Type: SubtitleTrack, Codec: "tx3g", Language: language.Spanish, Default: true},
+		},
+	}
+	if !reflect.DeepEqual(info, target) {
+		t.Errorf(`Expected info to be %+v, but got %+v`, target, info)
+	}
+
+	if _, err := Probe(bytes.NewReader([]byte("1\n00:00:01,000 --> 00:00:02,000\n")), 32); err != ErrUnsupported {
+		t.Errorf(`Expected ErrUnsupported, but got %v`, err)
+	}
+}
+
+func TestReadTextTrack(t *testing.T) {
+	t.Parallel()
+
+	// block encodes a Matroska block of a track, at a time relative to its cluster
+	block := func(track byte, relative int16, text string) []byte {
+		header := []byte{0x80 | track, 0, 0, 0}
+		binary.BigEndian.PutUint16(header[1:], uint16(relative))
+		return append(header, text...)
+	}
+
+	file := append(
+		ebml(idEBML, ebml(idDocType, "matroska")),
+		ebml(idSegment,
+			ebml(idInfo, ebml(idTimescale, []byte{0x0f, 0x42, 0x40})), // Milliseconds
+			ebml(idCluster,
+				ebml(idClusterTimestamp, 0),
+				ebml(idSimpleBlock, block(1, 0, "video")),
+				ebml(idBlockGroup, ebml(idBlock, block(3, 1000, "0,0,Default,,0,0,0,,Hello, there")), ebml(idBlockDuration, []byte{0x07, 0xd0})),
+				ebml(idSimpleBlock, block(4, 1500, "Forced")),
+			),
+			ebml(idCluster,
+				ebml(idClusterTimestamp, []byte{0x27, 0x10}),
+				ebml(idBlockGroup, ebml(idBlock, block(3, -500, "0,0,Default,,0,0,0,,{\\i1}Bye{\\i0}")), ebml(idBlockDuration, []byte{0x03, 0xe8})),
+			),
+		)...,
+	)
+
+	cues, err := ReadTextTrack(bytes.NewReader(file), int64(len(file)), Track{ID: 3, Type: SubtitleTrack, Codec: "S_TEXT/ASS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := []TextCue{
+		{Start: 1 * time.Second, End: 3 * time.Second, Text: "Hello, there"},
+		{Start: 9500 * time.Millisecond, End: 10500 * time.Millisecond, Text: "{\\i1}Bye{\\i0}"},
+	}
+	if !reflect.DeepEqual(cues, target) {
+		t.Errorf(`Expected cues to be %+v, but got %+v`, target, cues)
+	}
+
+	if _, err := ReadTextTrack(bytes.NewReader(file), int64(len(file)), Track{ID: 1, Type: VideoTrack, Codec: "V_MPEG4/ISO/AVC"}); err != ErrNotText {
+		t.Errorf(`Expected ErrNotText, but got %v`, err)
+	}
+}
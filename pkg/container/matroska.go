@@ -0,0 +1,390 @@
+package container
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// EBML element IDs, with their length markers.
+// See https://www.matroska.org/technical/elements.html
+const (
+	idEBML        = 0x1a45dfa3
+	idDocType     = 0x4282
+	idSegment     = 0x18538067
+	idSeekHead    = 0x114d9b74
+	idSeek        = 0x4dbb
+	idSeekID      = 0x53ab
+	idSeekPos     = 0x53ac
+	idInfo        = 0x1549a966
+	idTimescale   = 0x2ad7b1
+	idDuration    = 0x4489
+	idTitle       = 0x7ba9
+	idTracks      = 0x1654ae6b
+	idTrackEntry  = 0xae
+	idTrackNumber = 0xd7
+	idTrackType   = 0x83
+	idCodecID     = 0x86
+	idLanguage    = 0x22b59c
+	idLangIETF    = 0x22b59d
+	idName        = 0x536e
+	idFlagDefault = 0x88
+	idFlagForced  = 0x55aa
+	idDefaultDur  = 0x23e383
+	idCluster     = 0x1f43b675
+)
+
+// Matroska track types
+const (
+	mkvVideo    = 1
+	mkvAudio    = 2
+	mkvSubtitle = 0x11
+)
+
+// unknownSize marks elements whose size is not known in advance
+const unknownSize = -1
+
+var errInvalidEBML = errors.New("invalid EBML data")
+
+// ebmlElement is the header of an EBML element
+type ebmlElement struct {
+	id    uint32
+	start int64 // Offset of the element's data
+	size  int64 // Size of the element's data. unknownSize if unknown
+}
+
+func (e ebmlElement) end(limit int64) int64 {
+	if e.size == unknownSize || e.start+e.size > limit {
+		return limit
+	}
+	return e.start + e.size
+}
+
+func probeMatroska(r io.ReaderAt, size int64) (*Info, error) {
+	header, err := readElement(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Format: "matroska"}
+	err = eachElement(r, header.start, header.end(size), func(el ebmlElement) error {
+		if el.id == idDocType {
+			info.Format, err = readString(r, el)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	segment, err := readElement(r, header.end(size))
+	if err != nil {
+		return nil, err
+	}
+	if segment.id != idSegment {
+		return nil, errInvalidEBML
+	}
+	end := segment.end(size)
+
+	// Elements are read until the first cluster. If the info or the tracks
+	// weren't found by then, the seek head tells where they are
+	seeks := map[uint32]int64{}
+	found := map[uint32]bool{}
+	readers := map[uint32]func(io.ReaderAt, ebmlElement, *Info) error{
+		idInfo:   readSegmentInfo,
+		idTracks: readTracks,
+	}
+	err = eachElement(r, segment.start, end, func(el ebmlElement) error {
+		if read, ok := readers[el.id]; ok {
+			found[el.id] = true
+			return read(r, el, info)
+		}
+		switch el.id {
+		case idSeekHead:
+			return readSeekHead(r, el, segment.start, seeks)
+		case idCluster:
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	}
+
+	for id, read := range readers {
+		pos, ok := seeks[id]
+		if !ok || found[id] {
+			continue
+		}
+
+		el, err := readElement(r, pos)
+		if err != nil {
+			return nil, err
+		}
+		if el.id == id {
+			if err := read(r, el, info); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// errStop stops eachElement without failing
+var errStop = errors.New("stop")
+
+// eachElement calls f for every element between start and end.
+// Elements of unknown size can't be skipped, so they end the iteration
+func eachElement(r io.ReaderAt, start, end int64, f func(ebmlElement) error) error {
+	for pos := start; pos < end; {
+		el, err := readElement(r, pos)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// Truncated files still have useful headers
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := f(el); err != nil {
+			return err
+		}
+
+		if el.size == unknownSize {
+			return nil
+		}
+		pos = el.start + el.size
+	}
+	return nil
+}
+
+func readSeekHead(r io.ReaderAt, head ebmlElement, segmentStart int64, seeks map[uint32]int64) error {
+	return eachElement(r, head.start, head.end(head.start+head.size), func(seek ebmlElement) error {
+		if seek.id != idSeek {
+			return nil
+		}
+
+		var id uint32
+		var pos int64 = -1
+		err := eachElement(r, seek.start, seek.start+seek.size, func(el ebmlElement) error {
+			var err error
+			switch el.id {
+			case idSeekID:
+				var raw uint64
+				raw, err = readUint(r, el)
+				id = uint32(raw)
+			case idSeekPos:
+				var raw uint64
+				raw, err = readUint(r, el)
+				pos = int64(raw)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if id != 0 && pos >= 0 {
+			seeks[id] = segmentStart + pos
+		}
+		return nil
+	})
+}
+
+func readSegmentInfo(r io.ReaderAt, segmentInfo ebmlElement, info *Info) error {
+	// Durations are counted in ticks of scale nanoseconds
+	scale := uint64(1000000)
+	var duration float64
+	err := eachElement(r, segmentInfo.start, segmentInfo.start+segmentInfo.size, func(el ebmlElement) error {
+		var err error
+		switch el.id {
+		case idTimescale:
+			scale, err = readUint(r, el)
+		case idDuration:
+			duration, err = readFloat(r, el)
+		case idTitle:
+			info.Title, err = readString(r, el)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	info.Duration = time.Duration(duration * float64(scale))
+	return nil
+}
+
+func readTracks(r io.ReaderAt, tracks ebmlElement, info *Info) error {
+	return eachElement(r, tracks.start, tracks.start+tracks.size, func(entry ebmlElement) error {
+		if entry.id != idTrackEntry {
+			return nil
+		}
+
+		// Matroska's default language is english
+		track := Track{Default: true}
+		lang, ietf := "eng", ""
+		err := eachElement(r, entry.start, entry.start+entry.size, func(el ebmlElement) error {
+			var err error
+			var n uint64
+			switch el.id {
+			case idTrackNumber:
+				n, err = readUint(r, el)
+				track.ID = int(n)
+			case idTrackType:
+				n, err = readUint(r, el)
+				switch n {
+				case mkvVideo:
+					track.Type = VideoTrack
+				case mkvAudio:
+					track.Type = AudioTrack
+				case mkvSubtitle:
+					track.Type = SubtitleTrack
+				}
+			case idCodecID:
+				track.Codec, err = readString(r, el)
+			case idLanguage:
+				lang, err = readString(r, el)
+			case idLangIETF:
+				ietf, err = readString(r, el)
+			case idName:
+				track.Name, err = readString(r, el)
+			case idFlagDefault:
+				n, err = readUint(r, el)
+				track.Default = n != 0
+			case idFlagForced:
+				n, err = readUint(r, el)
+				track.Forced = n != 0
+			case idDefaultDur:
+				// Nanoseconds per frame
+				n, err = readUint(r, el)
+				if n > 0 {
+					track.FrameRate = roundFrameRate(float64(time.Second) / float64(n))
+				}
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if ietf != "" {
+			lang = ietf
+		}
+		if track.Type != VideoTrack {
+			track.FrameRate = 0
+		}
+		track.Language = parseLanguage(lang)
+		info.Tracks = append(info.Tracks, track)
+		return nil
+	})
+}
+
+// readElement reads the header of the element at pos
+func readElement(r io.ReaderAt, pos int64) (ebmlElement, error) {
+	id, idLen, err := readVint(r, pos, true)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+
+	size, sizeLen, err := readVint(r, pos+int64(idLen), false)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+
+	el := ebmlElement{
+		id:    uint32(id),
+		start: pos + int64(idLen+sizeLen),
+		size:  int64(size),
+	}
+	// A size with all bits set means it's unknown
+	if size == 1<<(7*uint(sizeLen))-1 {
+		el.size = unknownSize
+	}
+	return el, nil
+}
+
+// readVint reads a variable length integer. IDs keep their length marker
+func readVint(r io.ReaderAt, pos int64, keepMarker bool) (uint64, int, error) {
+	first := make([]byte, 1)
+	if _, err := r.ReadAt(first, pos); err != nil {
+		return 0, 0, err
+	}
+
+	length := 1
+	for mask := byte(0x80); length <= 8 && first[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, errInvalidEBML
+	}
+
+	buf := make([]byte, length)
+	if _, err := r.ReadAt(buf, pos); err != nil {
+		return 0, 0, err
+	}
+	if !keepMarker {
+		buf[0] &^= 0x80 >> uint(length-1)
+	}
+
+	var value uint64
+	for _, b := range buf {
+		value = value<<8 | uint64(b)
+	}
+	return value, length, nil
+}
+
+func readData(r io.ReaderAt, el ebmlElement, max int64) ([]byte, error) {
+	if el.size < 0 || el.size > max {
+		return nil, errInvalidEBML
+	}
+
+	buf := make([]byte, el.size)
+	if _, err := r.ReadAt(buf, el.start); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func readUint(r io.ReaderAt, el ebmlElement) (uint64, error) {
+	buf, err := readData(r, el, 8)
+	if err != nil {
+		return 0, err
+	}
+
+	var value uint64
+	for _, b := range buf {
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+func readFloat(r io.ReaderAt, el ebmlElement) (float64, error) {
+	buf, err := readData(r, el, 8)
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(buf) {
+	case 0:
+		return 0, nil
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(buf))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), nil
+	default:
+		return 0, errInvalidEBML
+	}
+}
+
+func readString(r io.ReaderAt, el ebmlElement) (string, error) {
+	buf, err := readData(r, el, 64*1024)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\x00"), nil
+}
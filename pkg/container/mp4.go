@@ -0,0 +1,371 @@
+package container
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var errInvalidMP4 = errors.New("invalid MP4 data")
+
+// mp4Box is the header of an ISO base media file box
+type mp4Box struct {
+	kind  string
+	start int64 // Offset of the box's data
+	end   int64 // Offset right after the box
+}
+
+// mp4TopLevel are box types that may start an MP4 or QuickTime file
+var mp4TopLevel = map[string]bool{
+	"ftyp": true,
+	"moov": true,
+	"mdat": true,
+	"free": true,
+	"skip": true,
+	"wide": true,
+	"pnot": true,
+}
+
+func isMP4Box(kind []byte) bool {
+	return mp4TopLevel[string(kind)]
+}
+
+// Handler types of subtitle tracks
+var mp4SubtitleHandlers = map[string]bool{
+	"sbtl": true,
+	"subt": true,
+	"text": true,
+	"clcp": true,
+}
+
+func probeMP4(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Format: "mp4"}
+
+	// moov may come after mdat, so every top level box is looked at
+	err := eachBox(r, 0, size, func(box mp4Box) error {
+		if box.kind != "moov" {
+			return nil
+		}
+		return eachBox(r, box.start, box.end, func(child mp4Box) error {
+			switch child.kind {
+			case "mvhd":
+				return readMovieHeader(r, child, info)
+			case "udta":
+				return readUserData(r, child, info)
+			case "trak":
+				return readTrak(r, child, info)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// eachBox calls f for every box between start and end
+func eachBox(r io.ReaderAt, start, end int64, f func(mp4Box) error) error {
+	for pos := start; pos+8 <= end; {
+		box, err := readBox(r, pos, end)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// Truncated files still have useful headers
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := f(box); err != nil {
+			return err
+		}
+		pos = box.end
+	}
+	return nil
+}
+
+// readBox reads the header of the box at pos. limit is the end of its parent
+func readBox(r io.ReaderAt, pos, limit int64) (mp4Box, error) {
+	header := make([]byte, 16)
+	if _, err := r.ReadAt(header[:8], pos); err != nil {
+		return mp4Box{}, err
+	}
+
+	box := mp4Box{
+		kind:  string(header[4:8]),
+		start: pos + 8,
+	}
+
+	switch size := int64(binary.BigEndian.Uint32(header)); size {
+	case 0:
+		// The box extends to the end of the file
+		box.end = limit
+	case 1:
+		// 64-bit size
+		if _, err := r.ReadAt(header[8:], pos+8); err != nil {
+			return mp4Box{}, err
+		}
+		box.start += 8
+		box.end = pos + int64(binary.BigEndian.Uint64(header[8:]))
+	default:
+		box.end = pos + size
+	}
+
+	if box.end < box.start {
+		return mp4Box{}, errInvalidMP4
+	}
+	if box.end > limit {
+		box.end = limit
+	}
+	return box, nil
+}
+
+// readBoxData reads the data of a small box
+func readBoxData(r io.ReaderAt, box mp4Box) ([]byte, error) {
+	if box.end-box.start > 64*1024 {
+		return nil, errInvalidMP4
+	}
+
+	buf := make([]byte, box.end-box.start)
+	if _, err := r.ReadAt(buf, box.start); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readMovieHeader reads the duration of the file from a mvhd box
+func readMovieHeader(r io.ReaderAt, mvhd mp4Box, info *Info) error {
+	data, err := readBoxData(r, mvhd)
+	if err != nil {
+		return err
+	}
+
+	// Version 1 has 64-bit times and duration
+	var timescale, duration uint64
+	switch {
+	case len(data) >= 32 && data[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(data[20:]))
+		duration = binary.BigEndian.Uint64(data[24:])
+	case len(data) >= 20:
+		timescale = uint64(binary.BigEndian.Uint32(data[12:]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:]))
+	default:
+		return errInvalidMP4
+	}
+
+	if timescale > 0 {
+		info.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+	}
+	return nil
+}
+
+// readUserData reads the title of the file, either from a QuickTime "©nam"
+// box or from the iTunes metadata list (meta/ilst/©nam/data)
+func readUserData(r io.ReaderAt, udta mp4Box, info *Info) error {
+	return eachBox(r, udta.start, udta.end, func(box mp4Box) error {
+		switch box.kind {
+		case "\xa9nam":
+			data, err := readBoxData(r, box)
+			if err != nil {
+				return err
+			}
+			// 16-bit length and language, followed by the text
+			if len(data) >= 4 && info.Title == "" {
+				length := int(binary.BigEndian.Uint16(data))
+				if length > len(data)-4 {
+					length = len(data) - 4
+				}
+				info.Title = string(data[4 : 4+length])
+			}
+
+		case "meta":
+			// meta is a full box: skip its version and flags
+			return eachBox(r, box.start+4, box.end, func(ilst mp4Box) error {
+				if ilst.kind != "ilst" {
+					return nil
+				}
+				return eachBox(r, ilst.start, ilst.end, func(item mp4Box) error {
+					if item.kind != "\xa9nam" {
+						return nil
+					}
+					return eachBox(r, item.start, item.end, func(value mp4Box) error {
+						if value.kind != "data" {
+							return nil
+						}
+						data, err := readBoxData(r, value)
+						if err != nil {
+							return err
+						}
+						// Type and locale, followed by the text
+						if len(data) > 8 {
+							info.Title = string(data[8:])
+						}
+						return nil
+					})
+				})
+			})
+		}
+		return nil
+	})
+}
+
+func readTrak(r io.ReaderAt, trak mp4Box, info *Info) error {
+	track := Track{}
+	lang, extended := "und", ""
+	var timescale uint32
+
+	err := eachBox(r, trak.start, trak.end, func(box mp4Box) error {
+		switch box.kind {
+		case "tkhd":
+			data, err := readBoxData(r, box)
+			if err != nil {
+				return err
+			}
+			// Version 1 has 64-bit creation and modification times
+			offset := 12
+			if len(data) > 0 && data[0] == 1 {
+				offset = 20
+			}
+			if len(data) < offset+4 {
+				return errInvalidMP4
+			}
+			track.ID = int(binary.BigEndian.Uint32(data[offset:]))
+			// The "enabled" flag is the closest MP4 has to a default track
+			track.Default = data[3]&1 != 0
+			return nil
+
+		case "mdia":
+			return eachBox(r, box.start, box.end, func(box mp4Box) error {
+				switch box.kind {
+				case "mdhd":
+					data, err := readBoxData(r, box)
+					if err != nil {
+						return err
+					}
+					// Version 1 has 64-bit times and duration
+					scaleOffset, offset := 12, 20
+					if len(data) > 0 && data[0] == 1 {
+						scaleOffset, offset = 20, 32
+					}
+					if len(data) < offset+2 {
+						return errInvalidMP4
+					}
+					timescale = binary.BigEndian.Uint32(data[scaleOffset:])
+					lang = unpackLanguage(binary.BigEndian.Uint16(data[offset:]))
+
+				case "elng":
+					data, err := readBoxData(r, box)
+					if err != nil {
+						return err
+					}
+					if len(data) > 4 {
+						extended = strings.TrimRight(string(data[4:]), "\x00")
+					}
+
+				case "hdlr":
+					data, err := readBoxData(r, box)
+					if err != nil {
+						return err
+					}
+					if len(data) < 12 {
+						return errInvalidMP4
+					}
+					switch handler := string(data[8:12]); {
+					case handler == "vide":
+						track.Type = VideoTrack
+					case handler == "soun":
+						track.Type = AudioTrack
+					case mp4SubtitleHandlers[handler]:
+						track.Type = SubtitleTrack
+					}
+
+				case "minf":
+					codec, frames, ticks, err := readSampleTable(r, box)
+					if err != nil {
+						return err
+					}
+					track.Codec = codec
+					if frames > 0 && ticks > 0 && timescale > 0 {
+						track.FrameRate = roundFrameRate(float64(frames) * float64(timescale) / float64(ticks))
+					}
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if extended != "" {
+		lang = extended
+	}
+	if track.Type != VideoTrack {
+		track.FrameRate = 0
+	}
+	track.Language = parseLanguage(lang)
+	info.Tracks = append(info.Tracks, track)
+	return nil
+}
+
+// readSampleTable reads minf/stbl, returning the type of the first sample entry
+// and, from the decoding times, the number of samples and their total duration
+func readSampleTable(r io.ReaderAt, minf mp4Box) (codec string, samples, ticks uint64, err error) {
+	err = eachBox(r, minf.start, minf.end, func(stbl mp4Box) error {
+		if stbl.kind != "stbl" {
+			return nil
+		}
+		return eachBox(r, stbl.start, stbl.end, func(box mp4Box) error {
+			switch box.kind {
+			case "stsd":
+				// Skip version, flags and entry count
+				entry, err := readBox(r, box.start+8, box.end)
+				if err != nil {
+					return err
+				}
+				codec = entry.kind
+
+			case "stts":
+				header := make([]byte, 8)
+				if _, err := r.ReadAt(header, box.start); err != nil {
+					return err
+				}
+				// Each entry is a sample count followed by their duration
+				count := int64(binary.BigEndian.Uint32(header[4:]))
+				if count*8 > box.end-box.start-8 {
+					return errInvalidMP4
+				}
+				entries := make([]byte, count*8)
+				if _, err := r.ReadAt(entries, box.start+8); err != nil {
+					return err
+				}
+				for i := 0; i+8 <= len(entries); i += 8 {
+					n := uint64(binary.BigEndian.Uint32(entries[i:]))
+					samples += n
+					ticks += n * uint64(binary.BigEndian.Uint32(entries[i+4:]))
+				}
+			}
+			return nil
+		})
+	})
+	return codec, samples, ticks, err
+}
+
+// unpackLanguage decodes the ISO 639-2/T code packed in 15 bits by mdhd boxes
+func unpackLanguage(packed uint16) string {
+	// Smaller values are QuickTime language codes, which are not supported
+	if packed < 0x400 {
+		return "und"
+	}
+
+	lang := []byte{
+		byte(packed>>10&0x1f) + 0x60,
+		byte(packed>>5&0x1f) + 0x60,
+		byte(packed&0x1f) + 0x60,
+	}
+	return string(lang)
+}
@@ -0,0 +1,209 @@
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// EBML element IDs of the clusters, where the frames are
+const (
+	idClusterTimestamp = 0xe7
+	idSimpleBlock      = 0xa3
+	idBlockGroup       = 0xa0
+	idBlock            = 0xa1
+	idBlockDuration    = 0x9b
+)
+
+// maxTextBlock is the largest block read from a text track. Subtitle frames are tiny
+const maxTextBlock = 64 * 1024
+
+// ErrNotText is returned when reading a track that doesn't hold text subtitles
+var ErrNotText = errors.New("not a text subtitle track")
+
+// TextCue is a cue of an embedded text subtitle track
+type TextCue struct {
+	Start time.Duration
+	End   time.Duration // Same as Start if the track doesn't tell
+	Text  string        // Text of the cue, with the markup of the track's codec
+}
+
+// IsText returns wether a track holds text subtitles that ReadTextTrack can read
+func (t Track) IsText() bool {
+	switch t.Codec {
+	case "S_TEXT/UTF8", "S_TEXT/ASCII", "S_TEXT/ASS", "S_TEXT/SSA", "S_TEXT/WEBVTT":
+		return t.Type == SubtitleTrack
+	}
+	return false
+}
+
+// ReadTextTrack reads the cues of a text subtitle track of a Matroska file of the given size.
+// Unlike Probe, every cluster of the file is looked at. MP4 text tracks (tx3g) are not
+// supported, and return ErrNotText like any other track that IsText rejects
+func ReadTextTrack(r io.ReaderAt, size int64, track Track) ([]TextCue, error) {
+	if !track.IsText() {
+		return nil, ErrNotText
+	}
+
+	header, err := readElement(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	segment, err := readElement(r, header.end(size))
+	if err != nil {
+		return nil, err
+	}
+	if header.id != idEBML || segment.id != idSegment {
+		return nil, ErrUnsupported
+	}
+	end := segment.end(size)
+
+	// Timestamps are counted in ticks of scale nanoseconds
+	scale := uint64(1000000)
+	var cues []TextCue
+	for pos := segment.start; pos < end; {
+		el, err := readElement(r, pos)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch el.id {
+		case idInfo:
+			err = eachElement(r, el.start, el.end(end), func(child ebmlElement) error {
+				var err error
+				if child.id == idTimescale {
+					scale, err = readUint(r, child)
+				}
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		case idCluster:
+			// Clusters of unknown size end where the next one starts
+			pos, err = readTextCluster(r, el, end, track, time.Duration(scale), &cues)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if el.size == unknownSize {
+			break
+		}
+		pos = el.start + el.size
+	}
+
+	return cues, nil
+}
+
+// ReadTextTrackFile reads the cues of a text subtitle track of a Matroska file. See ReadTextTrack
+func ReadTextTrackFile(path string, track Track) ([]TextCue, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadTextTrack(file, stat.Size(), track)
+}
+
+// readTextCluster appends the cues of a track found in a cluster, returning where the cluster ends
+func readTextCluster(r io.ReaderAt, cluster ebmlElement, limit int64, track Track, scale time.Duration, cues *[]TextCue) (int64, error) {
+	var timestamp int64
+	end := cluster.end(limit)
+	pos := cluster.start
+	for pos < end {
+		el, err := readElement(r, pos)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return end, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if el.id == idCluster || el.size == unknownSize {
+			return pos, nil
+		}
+		pos = el.start + el.size
+
+		var block ebmlElement
+		var duration int64 = -1
+		switch el.id {
+		case idClusterTimestamp:
+			n, err := readUint(r, el)
+			if err != nil {
+				return 0, err
+			}
+			timestamp = int64(n)
+			continue
+		case idSimpleBlock:
+			block = el
+		case idBlockGroup:
+			err := eachElement(r, el.start, el.end(end), func(child ebmlElement) error {
+				switch child.id {
+				case idBlock:
+					block = child
+				case idBlockDuration:
+					n, err := readUint(r, child)
+					duration = int64(n)
+					return err
+				}
+				return nil
+			})
+			if err != nil {
+				return 0, err
+			}
+		default:
+			continue
+		}
+
+		// The track number comes first, so blocks of other tracks are skipped without reading them
+		number, length, err := readVint(r, block.start, false)
+		if err != nil || int(number) != track.ID || block.size > maxTextBlock {
+			continue
+		}
+		data, err := readData(r, block, maxTextBlock)
+		if err != nil || len(data) < length+3 {
+			continue
+		}
+		// Laced blocks hold several frames, which text tracks don't use
+		if data[length+2]&0x06 != 0 {
+			continue
+		}
+
+		cue := TextCue{
+			Start: time.Duration(timestamp+int64(int16(binary.BigEndian.Uint16(data[length:])))) * scale,
+			Text:  textPayload(track.Codec, data[length+3:]),
+		}
+		cue.End = cue.Start
+		if duration >= 0 {
+			cue.End = cue.Start + time.Duration(duration)*scale
+		}
+		*cues = append(*cues, cue)
+	}
+	return end, nil
+}
+
+// textPayload returns the text of a frame of a text track. ASS/SSA frames
+// hold the fields of a dialogue line, of which the text is the last
+func textPayload(codec string, data []byte) string {
+	data = bytes.TrimRight(data, "\x00")
+	if codec == "S_TEXT/ASS" || codec == "S_TEXT/SSA" {
+		if fields := strings.SplitN(string(data), ",", 9); len(fields) == 9 {
+			return fields[8]
+		}
+	}
+	return string(data)
+}
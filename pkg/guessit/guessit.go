@@ -4,6 +4,9 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/text/language"
 )
 
 // Patterns taken (and modified) from:
@@ -11,12 +14,14 @@ import (
 
 func init() {
 	reSeason = regexp.MustCompile(`(?i)(s?([0-9]{1,2}))[ex]`)
-	reEpisode = regexp.MustCompile(`(?i)([ex]([0-9]{2}))(?:[^0-9]|$)`)
+	reEpisode = regexp.MustCompile(`(?i)([ex]([0-9]{2})((?:-?[ex][0-9]{2}|-[0-9]{2}\b)*))(?:[^0-9]|$)`)
+	reSeasonPack = regexp.MustCompile(`(?i)\b(?:S|Season[ ._-]?)(\d{1,2})\b`)
+	reEpisodeList = regexp.MustCompile(`(?i)(-)?[ex]?([0-9]{2})`)
 	reYear = regexp.MustCompile(`(?i)\b([\[\(]?(\d{4})[\]\)]?)\b`)
 	reResolution = regexp.MustCompile(`(?i)\b([0-9]{3,4}p)\b`)
 	reRelease = regexp.MustCompile(`(?i)\b((?:PPV\.)?[HP]DTV|(?:HD)?CAM|B[DR]Rip|(?:HD-?)?TS|(?:PPV )?WEB-?DL(?: DVDRip)?|HDRip|DVDRip|DVDRIP|CamRip|W[EB]BRip|BluRay|Blu-ray|Blu Ray|DvDScr|hdtv|telesync)\b`)
 	reVideoCodec = regexp.MustCompile(`(?i)\b(xvid|[hx]\.?26[45])\b`)
-	reAudioCodec = regexp.MustCompile(`(?i)\b(MP3|DD5\.?1|Dual[\- ]Audio|LiNE|DTS|AAC[.-]LC|AAC(?:\.?2\.0)?|AC3(?:\.5\.1)?)\b`)
+	reAudioCodec = regexp.MustCompile(`(?i)\b(MP3|DD5\.?1|DDP|DD\+|E-?AC-?3|Dual[\- ]Audio|LiNE|DTS-HD(?:[ .]MA)?|DTS-X|DTS|AAC[.-]LC|AAC(?:\.?2\.0)?|AC3(?:\.5\.1)?|FLAC|Opus)(?:[^a-z]|$)`)
 	reGroup = regexp.MustCompile(`(?i)(- ?([^-\.\[ ]+(?:-={[^-]+-?$)?))`)
 	reRegion = regexp.MustCompile(`(?i)\bR[0-9]\b`)
 	reExtended = regexp.MustCompile(`(?i)\b(EXTENDED(:?.CUT)?)\b`)
@@ -32,6 +37,19 @@ func init() {
 	reUnrated = regexp.MustCompile(`(?i)\bUNRATED\b`)
 	reSize = regexp.MustCompile(`(?i)\b(\d+(?:\.\d+)?(?:GB|MB))\b`)
 	reThreeD = regexp.MustCompile(`(?i)\b3D\b`)
+	reAbsoluteEpisode = regexp.MustCompile(`(?i)(?:^|[ _.])(-[ _.]?(\d{1,4})(?:v\d{1,2})?(?:[ _.]?[-~][ _.]?(\d{1,4})(?:v\d{1,2})?)?)(?:[ _.\[\(]|$)`)
+	reBatch = regexp.MustCompile(`(?i)[\[\(](\d{1,4}) ?[-~] ?(\d{1,4})[\]\)]`)
+	reVersion = regexp.MustCompile(`(?i)\d(v(\d{1,2}))\b`)
+	reCRC32 = regexp.MustCompile(`(?i)\[([0-9A-F]{8})\]`)
+	reDomain = regexp.MustCompile(`(?i)^www\.|\.[a-z]{2,4}$`)
+	reStreamingSource = regexp.MustCompile(`\b(NF|NFLX|AMZN|DSNP|DSNY|ATVP|HMAX|HULU|PCOK|PMTP|CRAV|STAN)\b`)
+	reHDR = regexp.MustCompile(`(?i)\b(HDR10(?:\+|Plus)|HDR10|HDR|DV|DoVi|Dolby[ .]?Vision|HLG)(?:[^a-z0-9+]|$)`)
+	reBitDepth = regexp.MustCompile(`(?i)\b(?:(8|10|12)[ .-]?bits?|Hi(10)P)\b`)
+	reAudioChannels = regexp.MustCompile(`(?i)(?:DDP|DD\+|DD|E-?AC-?3|AC3|AAC|DTS(?:-HD)?(?:[ .]MA)?|DTS-X|TrueHD|FLAC|Opus|L?PCM|Atmos)[ .]?([1-9]\.[0-2])\b`)
+	reAtmos = regexp.MustCompile(`(?i)\bAtmos\b`)
+	reRemux = regexp.MustCompile(`(?i)\b(?:BD)?REMUX\b`)
+	reUHD = regexp.MustCompile(`(?i)\b(?:4K|UHD)\b`)
+	reAirDate = regexp.MustCompile(`\b(?:((?:19|20)\d{2})([.\- ])(\d{2})([.\- ])(\d{2})|(\d{2})([.\- ])(\d{2})([.\- ])((?:19|20)\d{2}))\b`)
 
 	reRemoveDotsLeft = regexp.MustCompile(`(?i)([ \.])([^ \.]{2,})`)
 	reRemoveDotsRight = regexp.MustCompile(`(?i)([^ \.]{2,})([ \.])`)
@@ -41,6 +59,8 @@ func init() {
 var (
 	reSeason       *regexp.Regexp
 	reEpisode      *regexp.Regexp
+	reEpisodeList  *regexp.Regexp
+	reSeasonPack   *regexp.Regexp
 	reYear         *regexp.Regexp
 	reResolution   *regexp.Regexp
 	reRelease      *regexp.Regexp
@@ -62,6 +82,21 @@ var (
 	reSize         *regexp.Regexp
 	reThreeD       *regexp.Regexp
 
+	reAbsoluteEpisode *regexp.Regexp
+	reBatch           *regexp.Regexp
+	reVersion         *regexp.Regexp
+	reCRC32           *regexp.Regexp
+	reDomain          *regexp.Regexp
+	reAirDate         *regexp.Regexp
+
+	reStreamingSource *regexp.Regexp
+	reHDR             *regexp.Regexp
+	reBitDepth        *regexp.Regexp
+	reAudioChannels   *regexp.Regexp
+	reAtmos           *regexp.Regexp
+	reRemux           *regexp.Regexp
+	reUHD             *regexp.Regexp
+
 	reRemoveDotsLeft  *regexp.Regexp
 	reRemoveDotsRight *regexp.Regexp
 	reTwoSeparators   *regexp.Regexp
@@ -71,7 +106,8 @@ var (
 type Information struct {
 	Title        string // Media title. "" if none
 	Season       int    // Season number. 0 if none
-	Episode      int    // Episode number. 0 if none
+	Episode      int    // Episode number. 0 if none. The absolute episode number for anime releases without a season
+	Episodes     []int  // Every episode of multi-episode media (S04E19E20E21, S02E01-E03), in order. nil for a single episode. See GetEpisodes
 	Year         int    // Media release year. 0 if none
 	Resolution   string // Video mode (1080p, 720i...). "" if none
 	Release      string // Release type (BDRip, WEBRip...). "" if none. See https://en.wikipedia.org/wiki/Pirated_movie_release_types
@@ -93,13 +129,92 @@ type Information struct {
 	Size         string // Media size (900MB, 1.3 GB). "" if none
 	ThreeD       bool   // Is the media 3D?
 
+	AbsoluteEpisode int    // Episode number counted from the start of the series, as in anime releases ("Show - 24"). 0 if none
+	Version         int    // Version of a release that was fixed and released again ("24v2"). 0 if none
+	BatchStart      int    // First episode of a batch release ("(01-12)"). 0 if none
+	BatchEnd        int    // Last episode of a batch release. 0 if none
+	CRC32           string // Checksum of the file, as in "[A1B2C3D4]". "" if none
+
+	AirDate time.Time // When an episode of a daily show aired ("2023.03.14", "14.03.2023"). Zero if none
+
+	Languages         []language.Tag // Languages of the audio ("KOREAN", "iTA-ENG", "TRUEFRENCH"). nil if none
+	SubtitleLanguages []language.Tag // Languages of the subtitles the media has ("VOSTFR", "KORSUB", "NORDiC"). nil if none
+	Subbed            bool           // Has the media subtitles, usually burnt in? ("SUBBED", "VOSTFR")
+	Dubbed            bool           // Was the audio dubbed?
+	Multi             bool           // Has the media audio in several languages? ("MULTi")
+
+	StreamingSource string   // Streaming service a WEB release was taken from, by its usual tag ("NF", "AMZN", "DSNP"...). "" if none
+	HDR             []string // HDR formats of the video, in order ("HDR10", "HDR10+", "DV", "HLG"). nil if none
+	BitDepth        int      // Bits per color of the video ("10bit", "Hi10P"). 0 if none
+	AudioChannels   string   // Audio channels ("5.1", "7.1", "2.0"). "" if none
+	Atmos           bool     // Has the audio Dolby Atmos?
+	Remux           bool     // Is the release the untouched video of a disc, in another container?
+
 	Rest []string // Information that couldn't be interpreted
 }
 
+// Span is where a field was found in a parsed string, as in str[Start:End]
+type Span struct {
+	Start int
+	End   int
+}
+
+// Details holds the information in a string along with where each
+// field was found in it and how sure the parse is of each of them
+type Details struct {
+	Information
+	Spans      map[string]Span    // Spans of the fields that were found, by field name ("Year", "Group"...)
+	Confidence map[string]float64 // How sure the parse is of each field it found, by field name. See GetConfidence
+}
+
+// GetConfidence returns how sure the parse is of a field, from 0 to 1, by its
+// name ("Year", "Group"...). Fields that weren't parsed, like the ones set by services, are certain
+func (d Details) GetConfidence(field string) float64 {
+	if c, ok := d.Confidence[field]; ok {
+		return c
+	}
+	return 1
+}
+
 // Parse parses a string extraction information in it
 func Parse(str string) Information {
+	return ParseDetailed(str).Information
+}
+
+// ParseDetailed parses a string like Parse, telling where each field was found in it
+func ParseDetailed(str string) Details {
 	res := Information{}
 
+	// Anime releases start with the group and end with a checksum, which
+	// are hidden from the other patterns, since they may match anything
+	masked := str
+
+	crcMatchAll := reCRC32.FindAllStringSubmatchIndex(str, -1)
+	var crcMatch []int
+	if len(crcMatchAll) > 0 {
+		crcMatchGroups := crcMatchAll[len(crcMatchAll)-1]
+		crcMatch = crcMatchGroups[:2]
+		res.CRC32 = getNthGroup(str, crcMatchGroups, 1)
+		masked = maskString(masked, crcMatch)
+	}
+
+	websiteMatchGroups := reWebsite.FindStringSubmatchIndex(str)
+	var websiteMatch []int
+	var leadingGroup bool
+	if websiteMatchGroups != nil {
+		websiteMatch = websiteMatchGroups[:2]
+		masked = maskString(masked, websiteMatch)
+
+		// Anything but a domain, like "[SubsPlease]", is the release group
+		if name := getNthGroup(str, websiteMatchGroups, 2); reDomain.MatchString(name) {
+			res.Website = name
+		} else {
+			res.Group = name
+			leadingGroup = true
+		}
+	}
+	str, original := masked, str
+
 	seasonMatchGroups := reSeason.FindStringSubmatchIndex(str)
 	var seasonMatch []int
 	if seasonStr := getNthGroup(str, seasonMatchGroups, 2); seasonStr != "" {
@@ -117,11 +232,30 @@ func Parse(str string) Information {
 		if err == nil {
 			episodeMatch = episodeMatchGroups[2:4]
 			res.Episode = episode
+			res.Episodes = parseEpisodeList(episode, getNthGroup(str, episodeMatchGroups, 3))
+		}
+	}
+
+	// Season packs have a season but no episode, like "S01" or "Season 2"
+	seasonPack := false
+	if seasonMatch == nil && episodeMatch == nil {
+		seasonPackMatchGroups := reSeasonPack.FindStringSubmatchIndex(str)
+		if season, err := strconv.Atoi(getNthGroup(str, seasonPackMatchGroups, 1)); err == nil {
+			seasonMatch = seasonPackMatchGroups[:2]
+			res.Season = season
+			seasonPack = true
 		}
 	}
 
-	// Find last occurrance of a year
+	// Daily shows are told apart by their air date instead of an episode
+	airDateMatch, airDate := findAirDate(str)
+	res.AirDate = airDate
+
+	// Find last occurrance of a year, outside of the air date
 	yearMatchAll := reYear.FindAllStringIndex(str, -1)
+	if len(yearMatchAll) > 0 && overlaps(yearMatchAll[len(yearMatchAll)-1], airDateMatch) {
+		yearMatchAll = yearMatchAll[:len(yearMatchAll)-1]
+	}
 	var yearMatch []int
 	if len(yearMatchAll) > 0 {
 		yearMatch = yearMatchAll[len(yearMatchAll)-1]
@@ -138,21 +272,128 @@ func Parse(str string) Information {
 	resolutionMatch := reResolution.FindStringIndex(str)
 	res.Resolution = getNthGroup(str, resolutionMatch, 0)
 
+	// "4K" and "UHD" only tell the resolution when it isn't written
+	uhdMatch := reUHD.FindStringIndex(str)
+	if resolutionMatch == nil && uhdMatch != nil {
+		res.Resolution = "2160p"
+	}
+
 	releaseMatch := reRelease.FindStringIndex(str)
 	res.Release = getNthGroup(str, releaseMatch, 0)
 
 	videoCodecMatch := reVideoCodec.FindStringIndex(str)
 	res.VideoCodec = getNthGroup(str, videoCodecMatch, 0)
 
-	audioCodecMatch := reAudioCodec.FindStringIndex(str)
-	res.AudioCodec = getNthGroup(str, audioCodecMatch, 0)
+	audioCodecMatchGroups := reAudioCodec.FindStringSubmatchIndex(str)
+	var audioCodecMatch []int
+	if audioCodecMatchGroups != nil {
+		audioCodecMatch = audioCodecMatchGroups[2:4]
+		res.AudioCodec = getNthGroup(str, audioCodecMatchGroups, 1)
+	}
 
-	groupMatchGroups := reGroup.FindStringSubmatchIndex(str)
-	var groupMatch []int
-	if groupMatchGroups != nil {
-		groupMatch = groupMatchGroups[:2]
+	audioChannelsMatchGroups := reAudioChannels.FindStringSubmatchIndex(str)
+	var audioChannelsMatch []int
+	if audioChannelsMatchGroups != nil {
+		audioChannelsMatch = audioChannelsMatchGroups[2:4]
+		res.AudioChannels = getNthGroup(str, audioChannelsMatchGroups, 1)
+	}
+
+	bitDepthMatchGroups := reBitDepth.FindStringSubmatchIndex(str)
+	var bitDepthMatch []int
+	if bitDepthMatchGroups != nil {
+		bitDepthMatch = bitDepthMatchGroups[:2]
+		depth := getNthGroup(str, bitDepthMatchGroups, 1)
+		if depth == "" {
+			depth = getNthGroup(str, bitDepthMatchGroups, 2)
+		}
+		res.BitDepth, _ = strconv.Atoi(depth)
+	}
+
+	versionMatchGroups := reVersion.FindStringSubmatchIndex(str)
+	var versionMatch []int
+	if version, err := strconv.Atoi(getNthGroup(str, versionMatchGroups, 2)); err == nil {
+		versionMatch = versionMatchGroups[2:4]
+		res.Version = version
+	}
+
+	// Anime episodes are numbered from the start of the series, and may come in
+	// batches. Neither use "SxxEyy", so they are only looked for without it, and
+	// only in names that look like anime: with a leading group, a checksum or a
+	// version. Otherwise, " - 2" is as likely part of the title ("Spider-Man - 2")
+	anime := leadingGroup || crcMatch != nil || versionMatch != nil
+	var absoluteEpisodeMatch []int
+	var batchMatch []int
+	if anime && seasonMatch == nil && episodeMatch == nil && airDateMatch == nil {
+		for _, groups := range reAbsoluteEpisode.FindAllStringSubmatchIndex(str, -1) {
+			start, _ := strconv.Atoi(getNthGroup(str, groups, 2))
+			end, _ := strconv.Atoi(getNthGroup(str, groups, 3))
+			if looksLikeYear(getNthGroup(str, groups, 2)) || start == 0 {
+				continue
+			}
+
+			// The last match wins, since titles may have dashes too
+			absoluteEpisodeMatch = groups[2:4]
+			res.AbsoluteEpisode, res.BatchStart, res.BatchEnd = 0, 0, 0
+			if end > start {
+				res.BatchStart, res.BatchEnd = start, end
+			} else {
+				res.AbsoluteEpisode = start
+			}
+		}
+
+		if absoluteEpisodeMatch == nil {
+			batchMatchGroups := reBatch.FindStringSubmatchIndex(str)
+			start, _ := strconv.Atoi(getNthGroup(str, batchMatchGroups, 1))
+			end, _ := strconv.Atoi(getNthGroup(str, batchMatchGroups, 2))
+			if start > 0 && end > start && !looksLikeYear(getNthGroup(str, batchMatchGroups, 1)) {
+				batchMatch = batchMatchGroups[:2]
+				res.BatchStart, res.BatchEnd = start, end
+			}
+		}
+		res.Episode = res.AbsoluteEpisode
+
+		// Long running shows have episodes like "1000"
+		if overlaps(yearMatch, absoluteEpisodeMatch) || overlaps(yearMatch, batchMatch) {
+			yearMatch = nil
+			res.Year = 0
+		}
+	}
+
+	// Language tags come after the title, which starts at the first field found
+	titleEnd := -1
+	for _, match := range [][]int{seasonMatch, episodeMatch, yearMatch, resolutionMatch, uhdMatch, releaseMatch, videoCodecMatch, audioCodecMatch, airDateMatch, absoluteEpisodeMatch, batchMatch} {
+		if match != nil && (titleEnd < 0 || match[0] < titleEnd) {
+			titleEnd = match[0]
+		}
+	}
+	langs := findLanguages(str, titleEnd)
+	res.Languages = langs.languages
+	res.SubtitleLanguages = langs.subtitles
+	res.Subbed = langs.subbed
+	res.Dubbed = langs.dubbed
+	res.Multi = langs.multi
+
+	// Streaming sources and HDR formats are short tags ("NF", "DV"), so they
+	// are only looked for after the title as well
+	var streamingSourceMatch []int
+	if groups := findAfter(reStreamingSource, str, titleEnd); groups != nil {
+		streamingSourceMatch = groups[2:4]
+		res.StreamingSource = getNthGroup(str, groups, 1)
+		if alias, ok := streamingSourceAliases[res.StreamingSource]; ok {
+			res.StreamingSource = alias
+		}
+	}
+
+	var hdrMatches [][]int
+	for _, groups := range reHDR.FindAllStringSubmatchIndex(str, -1) {
+		if titleEnd < 0 || groups[0] < titleEnd {
+			continue
+		}
+		hdrMatches = append(hdrMatches, groups[2:4])
+		if format := normalizeHDR(getNthGroup(str, groups, 1)); !containsString(res.HDR, format) {
+			res.HDR = append(res.HDR, format)
+		}
 	}
-	res.Group = getNthGroup(str, groupMatchGroups, 2)
 
 	regionMatch := reRegion.FindStringIndex(str)
 	res.Region = getNthGroup(str, regionMatch, 0)
@@ -180,17 +421,10 @@ func Parse(str string) Information {
 	res.Container = getNthGroup(str, containerMatch, 0)
 
 	repackMatch := reRepack.FindStringIndex(str)
-	res.Proper = repackMatch != nil
+	res.Repack = repackMatch != nil
 
 	widescreenMatch := reWidescreen.FindStringIndex(str)
-	res.Proper = widescreenMatch != nil
-
-	websiteMatchGroups := reWebsite.FindStringSubmatchIndex(str)
-	var websiteMatch []int
-	if websiteMatchGroups != nil {
-		websiteMatch = websiteMatchGroups[:2]
-	}
-	res.Website = getNthGroup(str, websiteMatchGroups, 2)
+	res.Widescreen = widescreenMatch != nil
 
 	unratedMatch := reUnrated.FindStringIndex(str)
 	res.Unrated = unratedMatch != nil
@@ -201,8 +435,147 @@ func Parse(str string) Information {
 	threeDMatch := reThreeD.FindStringIndex(str)
 	res.ThreeD = threeDMatch != nil
 
+	atmosMatch := reAtmos.FindStringIndex(str)
+	res.Atmos = atmosMatch != nil
+
+	remuxMatch := reRemux.FindStringIndex(str)
+	res.Remux = remuxMatch != nil
+
+	// How sure the parse is of each field, and where it was found. Short
+	// abbreviations ("DC", "WS") and numbers that may be in the title are guesses
+	spans := map[string]Span{}
+	confidences := map[string]float64{}
+	found := func(field string, match []int, confidence float64) {
+		if match != nil {
+			spans[field] = Span{match[0], match[1]}
+			confidences[field] = confidence
+		}
+	}
+
+	switch {
+	case seasonPack:
+		found("Season", seasonMatch, 0.8)
+	case seasonMatch != nil && strings.EqualFold(str[seasonMatch[0]:seasonMatch[0]+1], "s"):
+		found("Season", seasonMatch, 1)
+	default:
+		found("Season", seasonMatch, 0.9)
+	}
+	if episodeMatch != nil {
+		confidence := 0.9
+		if strings.EqualFold(str[episodeMatch[0]:episodeMatch[0]+1], "e") {
+			confidence = 1
+		}
+		found("Episode", episodeMatch, confidence)
+		if res.Episodes != nil {
+			found("Episodes", episodeMatch, confidence)
+		}
+	}
+	if res.AbsoluteEpisode != 0 {
+		found("AbsoluteEpisode", absoluteEpisodeMatch, 0.7)
+		found("Episode", absoluteEpisodeMatch, 0.7)
+	}
+	found("BatchStart", batchMatch, 0.8)
+	found("BatchEnd", batchMatch, 0.8)
+	if res.BatchStart != 0 && absoluteEpisodeMatch != nil {
+		found("BatchStart", absoluteEpisodeMatch, 0.8)
+		found("BatchEnd", absoluteEpisodeMatch, 0.8)
+	}
+	found("AirDate", airDateMatch, 1)
+	found("Year", yearMatch, yearConfidence(str, yearMatch, len(yearMatchAll)))
+	found("Resolution", resolutionMatch, 1)
+	if resolutionMatch == nil {
+		found("Resolution", uhdMatch, 0.8)
+	}
+	if strings.EqualFold(res.Release, "TS") {
+		found("Release", releaseMatch, 0.5)
+	} else {
+		found("Release", releaseMatch, 1)
+	}
+	found("VideoCodec", videoCodecMatch, 1)
+	if strings.EqualFold(res.AudioCodec, "LiNE") {
+		found("AudioCodec", audioCodecMatch, 0.6)
+	} else {
+		found("AudioCodec", audioCodecMatch, 1)
+	}
+	found("AudioChannels", audioChannelsMatch, 1)
+	found("Atmos", atmosMatch, 1)
+	found("BitDepth", bitDepthMatch, 1)
+	found("Remux", remuxMatch, 1)
+	if len(res.StreamingSource) == 2 {
+		found("StreamingSource", streamingSourceMatch, 0.8)
+	} else {
+		found("StreamingSource", streamingSourceMatch, 1)
+	}
+	if len(hdrMatches) > 0 && res.HDR[0] == "DV" {
+		found("HDR", hdrMatches[0], 0.8)
+	} else if len(hdrMatches) > 0 {
+		found("HDR", hdrMatches[0], 1)
+	}
+	if leadingGroup {
+		found("Group", websiteMatch, 0.9)
+	} else {
+		found("Website", websiteMatch, 0.9)
+	}
+	found("Region", regionMatch, 0.6)
+	found("Extended", extendedMatch, 1)
+	found("Remastered", remasteredMatch, 1)
+	found("Theatrical", theatricalMatch, 1)
+	if directorsCutMatch != nil && directorsCutMatch[1]-directorsCutMatch[0] == 2 {
+		found("DirectorsCut", directorsCutMatch, 0.5)
+	} else {
+		found("DirectorsCut", directorsCutMatch, 1)
+	}
+	found("Hardcoded", hardcodedMatch, 0.6)
+	found("Proper", properMatch, 1)
+	if containerMatch != nil && containerMatch[1] == len(str) {
+		found("Container", containerMatch, 1)
+	} else {
+		found("Container", containerMatch, 0.6)
+	}
+	found("Repack", repackMatch, 1)
+	found("Widescreen", widescreenMatch, 0.6)
+	found("Unrated", unratedMatch, 1)
+	found("Size", sizeMatch, 1)
+	found("ThreeD", threeDMatch, 0.8)
+	found("Version", versionMatch, 0.9)
+	found("CRC32", crcMatch, 1)
+	for field, match := range langs.spans {
+		switch {
+		case field == "Languages" && langs.guessed:
+			found(field, match, 0.7)
+		case field == "Languages" || field == "SubtitleLanguages":
+			found(field, match, 0.9)
+		default:
+			found(field, match, 1)
+		}
+	}
+	// Groups come last, after every other field. Dashes that are part of them
+	// ("S02E01-E03", "WEB-DL", "Show - 24") are skipped, as are the ones in the
+	// title ("Spider-Man"). Of the rest, the first that is at the end of the name wins
+	var groupMatch []int
+	if !leadingGroup {
+		best := 0.0
+		for _, groups := range reGroup.FindAllStringSubmatchIndex(str, -1) {
+			if groups[0] < titleEnd || overlaps(groups[:2], yearMatch) {
+				continue
+			}
+			if overlaps(groups[:2], episodeMatch) || overlaps(groups[:2], absoluteEpisodeMatch) || overlaps(groups[:2], batchMatch) ||
+				overlaps(groups[:2], airDateMatch) || overlaps(groups[:2], releaseMatch) || overlaps(groups[:2], audioCodecMatch) ||
+				overlapsAny(groups[:2], langs.matches) || overlapsAny(groups[:2], hdrMatches) {
+				continue
+			}
+			if confidence := groupConfidence(str, groups[:2], spans); confidence > best {
+				best = confidence
+				groupMatch = groups[:2]
+				res.Group = getNthGroup(str, groups, 2)
+			}
+		}
+		found("Group", groupMatch, best)
+	}
+
 	// Join all the regex matches into a interval union
-	intervals := intervalsFromPairs([][]int{
+	tagMatches := append(langs.matches, hdrMatches...)
+	intervals := intervalsFromPairs(append(tagMatches,
 		seasonMatch,
 		episodeMatch,
 		yearMatch,
@@ -225,12 +598,23 @@ func Parse(str string) Information {
 		unratedMatch,
 		sizeMatch,
 		threeDMatch,
-	})
+		absoluteEpisodeMatch,
+		batchMatch,
+		airDateMatch,
+		versionMatch,
+		crcMatch,
+		uhdMatch,
+		audioChannelsMatch,
+		bitDepthMatch,
+		streamingSourceMatch,
+		atmosMatch,
+		remuxMatch,
+	))
 	intervals = joinIntervals(intervals)
 
 	// Remove all the characters that were present in
 	// a regex match
-	str = stripString(str, intervals)
+	str = stripString(original, intervals)
 
 	str = strings.ReplaceAll(str, "()", "  ")
 	str = strings.ReplaceAll(str, "[]", "  ")
@@ -245,22 +629,241 @@ func Parse(str string) Information {
 		res.Rest = strings.Fields(str[index[0]:])
 		str = str[:index[0]]
 	}
-	res.Title = str
+	// Names with nothing but an episode, like "E05.mkv", have no title
+	res.Title = strings.Trim(str, " ._-")
+
+	return Details{Information: res, Spans: spans, Confidence: confidences}
+}
+
+// streamingSourceAliases are the tags some groups use for the same streaming services
+var streamingSourceAliases = map[string]string{
+	"NFLX": "NF",
+	"DSNY": "DSNP",
+}
+
+// normalizeHDR names the variants of HDR formats in a single way ("DoVi" and "Dolby Vision" are "DV")
+func normalizeHDR(format string) string {
+	format = strings.ToUpper(format)
+	switch {
+	case strings.HasPrefix(format, "DOVI"), strings.HasPrefix(format, "DOLBY"):
+		return "DV"
+	case format == "HDR10PLUS":
+		return "HDR10+"
+	}
+	return format
+}
+
+// findAfter returns the groups of the first match of re at or after from. Returns nil if from is negative
+func findAfter(re *regexp.Regexp, str string, from int) []int {
+	if from < 0 {
+		return nil
+	}
+	for _, groups := range re.FindAllStringSubmatchIndex(str, -1) {
+		if groups[0] >= from {
+			return groups
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var reGroupEnd = regexp.MustCompile(`^(?:[ .]?\[[^\]]*\]|\.[\w-]{2,5})*[ .]*$`)
+
+// yearConfidence tells how sure the parse is of a year, of all the candidates found.
+// Titles may start with a year ("1917") or have a number before it ("Blade Runner 2049 2017")
+func yearConfidence(str string, match []int, candidates int) float64 {
+	switch {
+	case match == nil:
+		return 0
+	case match[0] > 0 && strings.ContainsAny(str[match[0]-1:match[0]], "(["):
+		return 1
+	case match[0] == 0:
+		return 0.4
+	case candidates > 1:
+		return 0.7
+	}
+	return 0.9
+}
+
+// groupConfidence tells how sure the parse is of a group. Groups come at the end of
+// names, with nothing but the extension or tags ("[ettv]") after them; dashes in the
+// middle of names ("WEB-DL", "Extra-Terrestrial") are more likely part of something else
+func groupConfidence(str string, match []int, spans map[string]Span) float64 {
+	if match == nil || !reGroupEnd.MatchString(str[match[1]:]) {
+		return 0.4
+	}
+	for field, span := range spans {
+		if span.Start >= match[1] && field != "Container" && field != "CRC32" && field != "Website" {
+			return 0.4
+		}
+	}
+	return 0.9
+}
+
+// maxEpisodeRange is the most episodes a range like "E01-E10" may have,
+// since wider ones are more likely something else
+const maxEpisodeRange = 50
+
+// parseEpisodeList reads the episodes that come after the first one in multi-episode
+// media, like "E20E21" or "-E03". Returns nil if there are none
+func parseEpisodeList(first int, list string) []int {
+	if list == "" {
+		return nil
+	}
+
+	res := []int{first}
+	for _, groups := range reEpisodeList.FindAllStringSubmatch(list, -1) {
+		episode, err := strconv.Atoi(groups[2])
+		if err != nil {
+			continue
+		}
+
+		last := res[len(res)-1]
+		if groups[1] != "" && episode > last && episode-last <= maxEpisodeRange {
+			// A range has every episode in between
+			for e := last + 1; e <= episode; e++ {
+				res = append(res, e)
+			}
+		} else if episode != last {
+			res = append(res, episode)
+		}
+	}
 
+	if len(res) == 1 {
+		return nil
+	}
 	return res
 }
 
+// GetEpisodes returns every episode of the media, in order, or nil if it has none
+func (i Information) GetEpisodes() []int {
+	if len(i.Episodes) > 0 {
+		return i.Episodes
+	}
+	if i.Episode != 0 {
+		return []int{i.Episode}
+	}
+	return nil
+}
+
+// SameEpisodes returns wether two medias have the same episodes, or are both not episodes
+func (i Information) SameEpisodes(other Information) bool {
+	a, b := i.GetEpisodes(), other.GetEpisodes()
+	if len(a) != len(b) {
+		return false
+	}
+	for _, e := range a {
+		if !other.HasEpisode(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// SharesEpisode returns wether two medias have any episode in common
+func (i Information) SharesEpisode(other Information) bool {
+	for _, e := range i.GetEpisodes() {
+		if other.HasEpisode(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasEpisode returns wether an episode is one of the media's
+func (i Information) HasEpisode(episode int) bool {
+	for _, e := range i.GetEpisodes() {
+		if e == episode {
+			return true
+		}
+	}
+	return false
+}
+
 // getNthGroup returns the string in the nth capture group
 // indexPairs is an array returned by a regexp's FindIndex or similar
 // The 0th group is the whole match
-// If the requested group doesn't exist, getNthGroup returns ""
+// If the requested group doesn't exist or didn't match, getNthGroup returns ""
 func getNthGroup(str string, indexPairs []int, group int) string {
-	if group >= len(indexPairs)/2 {
+	if group >= len(indexPairs)/2 || indexPairs[group*2] < 0 {
 		return ""
 	}
 	return str[indexPairs[group*2]:indexPairs[group*2+1]]
 }
 
+// findAirDate finds the first valid date, either as YYYY.MM.DD or as DD.MM.YYYY, using the
+// same separator twice. Returns its [start, end) pair, or nil if there is none
+func findAirDate(str string) ([]int, time.Time) {
+	for offset := 0; offset < len(str); {
+		groups := reAirDate.FindStringSubmatchIndex(str[offset:])
+		if groups == nil {
+			break
+		}
+		start := offset + groups[0]
+		offset = start + 1
+
+		// Matches after the offset may start in the middle of a word
+		if start > 0 && isAlnum(str[start-1]) {
+			continue
+		}
+		match := str[start : start+groups[1]-groups[0]]
+		parts := reAirDate.FindStringSubmatch(match)
+
+		year, month, day := parts[1], parts[3], parts[5]
+		if year == "" {
+			day, month, year = parts[6], parts[8], parts[10]
+		}
+		if parts[2]+parts[7] != parts[4]+parts[9] {
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02", year+"-"+month+"-"+day)
+		if err == nil {
+			return []int{start, start + len(match)}, date
+		}
+	}
+	return nil, time.Time{}
+}
+
+// isAlnum returns wether a character is an ASCII letter or digit
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// maskString replaces the characters in the [start, end) pair with spaces,
+// so that they aren't matched while their indexes are kept
+func maskString(str string, pair []int) string {
+	return str[:pair[0]] + strings.Repeat(" ", pair[1]-pair[0]) + str[pair[1]:]
+}
+
+// overlaps returns wether two [start, end) pairs share any character
+func overlaps(a, b []int) bool {
+	return a != nil && b != nil && a[0] < b[1] && b[0] < a[1]
+}
+
+// overlapsAny returns wether a [start, end) pair shares any character with any of others
+func overlapsAny(pair []int, others [][]int) bool {
+	for _, other := range others {
+		if overlaps(pair, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeYear returns wether a number is more likely a year than an episode, like "2020"
+func looksLikeYear(number string) bool {
+	return len(number) == 4 && (strings.HasPrefix(number, "19") || strings.HasPrefix(number, "20"))
+}
+
 // stripString removes characters which index is contained in the intervals.
 // The intervals must be non-overlapping and the start of the nth interval
 // must never be greater than the (n+1)th interval (they are sorted by their start)
@@ -1,7 +1,11 @@
 package guessit
 
 import (
+	"reflect"
 	"testing"
+	"time"
+
+	"golang.org/x/text/language"
 )
 
 var testCases = map[string]Information{
@@ -14,6 +18,17 @@ var testCases = map[string]Information{
 		VideoCodec: "x264",
 		Group:      "ASAP",
 	},
+	"Show.S01E01.REPACK.WS.720p.HDTV.x264-GRP": {
+		Title:      "Show",
+		Season:     1,
+		Episode:    1,
+		Resolution: "720p",
+		Release:    "HDTV",
+		VideoCodec: "x264",
+		Group:      "GRP",
+		Repack:     true,
+		Widescreen: true,
+	},
 	"Battlestar.Galactica.S04E01.BDRip.x264-FGT.mp4": {
 		Title:      "Battlestar Galactica",
 		Season:     4,
@@ -76,13 +91,15 @@ var testCases = map[string]Information{
 		Group:        "WAF",
 	},
 	"Blade.Runner.2049.2017.4K.UltraHD.BluRay.2160p.x264.TrueHD.Atmos.7.1.AC3-POOP": {
-		Title:      "Blade Runner 2049",
-		Year:       2017,
-		Release:    "BluRay",
-		Resolution: "2160p",
-		VideoCodec: "x264",
-		AudioCodec: "AC3",
-		Group:      "POOP",
+		Title:         "Blade Runner 2049",
+		Year:          2017,
+		Release:       "BluRay",
+		Resolution:    "2160p",
+		VideoCodec:    "x264",
+		AudioCodec:    "AC3",
+		AudioChannels: "7.1",
+		Atmos:         true,
+		Group:         "POOP",
 	},
 	"Terminator.2.Judgment.Day.1991.Extended.REMASTERED.1080p.BluRay.H264.AAC.READ.NFO-RARBG": {
 		Title:      "Terminator 2 Judgment Day",
@@ -113,12 +130,13 @@ var testCases = map[string]Information{
 		Group:      "GAnGSteR",
 	},
 	"Marvel's.Agents.of.S.H.I.E.L.D.S02E01.Shadows.1080p.WEB-DL.DD5.1": {
-		Title:      "Marvel's Agents of S.H.I.E.L.D",
-		Season:     2,
-		Episode:    1,
-		Resolution: "1080p",
-		Release:    "WEB-DL",
-		AudioCodec: "DD5.1",
+		Title:         "Marvel's Agents of S.H.I.E.L.D",
+		Season:        2,
+		Episode:       1,
+		Resolution:    "1080p",
+		Release:       "WEB-DL",
+		AudioCodec:    "DD5.1",
+		AudioChannels: "5.1",
 	},
 	"Interstellar.2014.1080p.BluRay.H264.AAC-RARBG": {
 		Title:      "Interstellar",
@@ -141,11 +159,37 @@ var testCases = map[string]Information{
 		Title:      "Battlestar Galactica",
 		Season:     4,
 		Episode:    19,
+		Episodes:   []int{19, 20, 21},
 		Extended:   true,
 		Release:    "BDRip",
 		VideoCodec: "x264",
 		Group:      "FGT",
 	},
+	"Doctor.Who.2005.S02E01-E03.720p.BluRay.x264-SiNNERS": {
+		Title:      "Doctor Who",
+		Year:       2005,
+		Season:     2,
+		Episode:    1,
+		Episodes:   []int{1, 2, 3},
+		Resolution: "720p",
+		Release:    "BluRay",
+		VideoCodec: "x264",
+		Group:      "SiNNERS",
+	},
+	"The.Daily.Show.2023.03.14.Guest.720p.WEB": {
+		Title:      "The Daily Show",
+		AirDate:    time.Date(2023, time.March, 14, 0, 0, 0, 0, time.UTC),
+		Resolution: "720p",
+	},
+	"Last.Week.Tonight.2014.12-03-2023.1080p.WEBRip.x264-GRP": {
+		Title:      "Last Week Tonight",
+		Year:       2014,
+		AirDate:    time.Date(2023, time.March, 12, 0, 0, 0, 0, time.UTC),
+		Resolution: "1080p",
+		Release:    "WEBRip",
+		VideoCodec: "x264",
+		Group:      "GRP",
+	},
 	"THX.1138.1971.Directors.Cut.1080p.BluRay.H264.AAC-RARBG": {
 		Title:        "THX 1138",
 		Year:         1971,
@@ -178,6 +222,149 @@ var testCases = map[string]Information{
 		Group:      "RARBG",
 		Container:  "mp4",
 	},
+	"[SubsPlease] Jujutsu Kaisen - 24 (1080p) [A1B2C3D4].mkv": {
+		Title:           "Jujutsu Kaisen",
+		Episode:         24,
+		AbsoluteEpisode: 24,
+		Resolution:      "1080p",
+		Group:           "SubsPlease",
+		CRC32:           "A1B2C3D4",
+		Container:       "mkv",
+	},
+	"[Erai-raws] Shingeki no Kyojin - The Final Season - 28v2 [1080p][Multiple Subtitle].mkv": {
+		Title:           "Shingeki no Kyojin - The Final Season",
+		Episode:         28,
+		AbsoluteEpisode: 28,
+		Version:         2,
+		Resolution:      "1080p",
+		Group:           "Erai-raws",
+		Container:       "mkv",
+	},
+	"[HorribleSubs] One Piece - 1000 [720p].mkv": {
+		Title:           "One Piece",
+		Episode:         1000,
+		AbsoluteEpisode: 1000,
+		Resolution:      "720p",
+		Group:           "HorribleSubs",
+		Container:       "mkv",
+	},
+	"[Judas] Spy x Family (01-12) [1080p][HEVC x265 10bit][Batch]": {
+		Title:      "Spy x Family",
+		BatchStart: 1,
+		BatchEnd:   12,
+		Resolution: "1080p",
+		VideoCodec: "x265",
+		BitDepth:   10,
+		Group:      "Judas",
+	},
+	"Spider-Man - 2 (2004)": {
+		Title: "Spider-Man - 2",
+		Year:  2004,
+	},
+	"Mission Impossible - Dead Reckoning 2023": {
+		Title: "Mission Impossible - Dead Reckoning",
+		Year:  2023,
+	},
+	"Star Wars - Episode IV - 1977": {
+		Title: "Star Wars - Episode IV",
+		Year:  1977,
+	},
+	"Il.Traditore.2019.iTA-ENG.1080p.BluRay.x264-GRP": {
+		Title:      "Il Traditore",
+		Year:       2019,
+		Languages:  []language.Tag{language.Italian, language.English},
+		Resolution: "1080p",
+		Release:    "BluRay",
+		VideoCodec: "x264",
+		Group:      "GRP",
+	},
+	"Le.Bureau.des.Legendes.S01E01.VOSTFR.720p.HDTV.x264-GRP": {
+		Title:             "Le Bureau des Legendes",
+		Season:            1,
+		Episode:           1,
+		SubtitleLanguages: []language.Tag{language.French},
+		Subbed:            true,
+		Resolution:        "720p",
+		Release:           "HDTV",
+		VideoCodec:        "x264",
+		Group:             "GRP",
+	},
+	"Parasite.2019.KOREAN.SUBBED.1080p.WEB-DL.x264": {
+		Title:      "Parasite",
+		Year:       2019,
+		Languages:  []language.Tag{language.Korean},
+		Subbed:     true,
+		Resolution: "1080p",
+		Release:    "WEB-DL",
+		VideoCodec: "x264",
+	},
+	"The.French.Dispatch.2021.MULTi.1080p.BluRay.x264-GRP": {
+		Title:      "The French Dispatch",
+		Year:       2021,
+		Multi:      true,
+		Resolution: "1080p",
+		Release:    "BluRay",
+		VideoCodec: "x264",
+		Group:      "GRP",
+	},
+	"Druk.2020.NORDiC.1080p.BluRay.x264-GRP": {
+		Title:             "Druk",
+		Year:              2020,
+		SubtitleLanguages: []language.Tag{language.Danish, language.Finnish, language.Norwegian, language.Swedish},
+		Resolution:        "1080p",
+		Release:           "BluRay",
+		VideoCodec:        "x264",
+		Group:             "GRP",
+	},
+	"The.Crown.S05E01.2160p.NF.WEB-DL.DDP5.1.Atmos.DV.HDR10.H.265-FLUX": {
+		Title:           "The Crown",
+		Season:          5,
+		Episode:         1,
+		Resolution:      "2160p",
+		StreamingSource: "NF",
+		Release:         "WEB-DL",
+		AudioCodec:      "DDP",
+		AudioChannels:   "5.1",
+		Atmos:           true,
+		HDR:             []string{"DV", "HDR10"},
+		VideoCodec:      "H.265",
+		Group:           "FLUX",
+	},
+	"Stan.and.Ollie.2018.1080p.AMZN.WEB-DL.DD+5.1.H.264-NTG": {
+		Title:           "Stan and Ollie",
+		Year:            2018,
+		Resolution:      "1080p",
+		StreamingSource: "AMZN",
+		Release:         "WEB-DL",
+		AudioCodec:      "DD+",
+		AudioChannels:   "5.1",
+		VideoCodec:      "H.264",
+		Group:           "NTG",
+	},
+	"The.Last.of.Us.S01E01.UHD.HMAX.WEB-DL.x265.10bit.HDR10Plus.DTS-HD.MA.7.1-SMURF": {
+		Title:           "The Last of Us",
+		Season:          1,
+		Episode:         1,
+		Resolution:      "2160p",
+		StreamingSource: "HMAX",
+		Release:         "WEB-DL",
+		VideoCodec:      "x265",
+		BitDepth:        10,
+		HDR:             []string{"HDR10+"},
+		AudioCodec:      "DTS-HD.MA",
+		AudioChannels:   "7.1",
+		Group:           "SMURF",
+	},
+	"Dune.2021.1080p.BluRay.REMUX.TrueHD.Atmos.7.1-FGT": {
+		Title:         "Dune",
+		Year:          2021,
+		Resolution:    "1080p",
+		Release:       "BluRay",
+		Remux:         true,
+		AudioChannels: "7.1",
+		Atmos:         true,
+		Group:         "FGT",
+	},
 }
 
 func TestParse(t *testing.T) {
@@ -192,6 +379,63 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseDetailed(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		field      string
+		span       string
+		confidence float64
+	}{
+		{"Blade.Runner.1982.DC.Remastered.XviD.AC3-WAF", "Year", "1982", 0.9},
+		{"Blade.Runner.1982.DC.Remastered.XviD.AC3-WAF", "DirectorsCut", "DC", 0.5},
+		{"Blade.Runner.1982.DC.Remastered.XviD.AC3-WAF", "Group", "-WAF", 0.9},
+		{"Hercules (2014) 1080p BrRip H264 - YIFY.avi", "Year", "2014", 1},
+		{"1917.2019.1080p.BluRay.x264-GRP", "Year", "2019", 0.7},
+		{"Spider-Man.2002.720p-GRP.BluRay.x264", "Group", "-GRP", 0.4},
+		{"Spider-Man.2002.720p.BluRay.x264-GRP", "Group", "-GRP", 0.9},
+		{"The.Walking.Dead.S05E03.720p.WEB-DL.x264", "Season", "S05", 1},
+		{"The Missing 1x01 Pilot HDTV x264-FoV [eztv]", "Episode", "x01", 0.9},
+		{"[SubsPlease] Jujutsu Kaisen - 24 (1080p) [A1B2C3D4].mkv", "Group", "[SubsPlease]", 0.9},
+		{"[SubsPlease] Jujutsu Kaisen - 24 (1080p) [A1B2C3D4].mkv", "AbsoluteEpisode", "- 24", 0.7},
+	}
+
+	for _, c := range cases {
+		details := ParseDetailed(c.name)
+		span, ok := details.Spans[c.field]
+		if !ok {
+			t.Errorf(`(case: "%s") Expected "%s" to be found`, c.name, c.field)
+			continue
+		}
+		if value := c.name[span.Start:span.End]; value != c.span || details.GetConfidence(c.field) != c.confidence {
+			t.Errorf(`(case: "%s") Expected "%s" at "%s" with %.1f, but got "%s" with %.1f`, c.name, c.field, c.span, c.confidence, value, details.GetConfidence(c.field))
+		}
+	}
+}
+
+func TestEpisodes(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		a, b   string
+		same   bool
+		shares bool
+	}{
+		{"Show.S01E01E02.720p", "Show.S01E01-E02.1080p", true, true},
+		{"Show.S01E01E02.720p", "Show.S01E02.720p", false, true},
+		{"Show.S01E01.720p", "Show.S01E03.720p", false, false},
+		{"Movie.2020.720p", "Movie.2020.1080p", true, false},
+	}
+
+	for _, c := range cases {
+		a, b := Parse(c.a), Parse(c.b)
+		if a.SameEpisodes(b) != c.same || a.SharesEpisode(b) != c.shares {
+			t.Errorf(`(case: "%s" "%s") Expected %v and %v, but got %v and %v`, c.a, c.b, c.same, c.shares, a.SameEpisodes(b), a.SharesEpisode(b))
+		}
+	}
+}
+
 func assertEqualsInformation(t *testing.T, testcase string, target, value Information) bool {
 	hasError := false
 
@@ -207,6 +451,14 @@ func assertEqualsInformation(t *testing.T, testcase string, target, value Inform
 		t.Errorf(`(case: "%s") Expected "Episode" to be %#v, but got %#v`, testcase, target.Episode, value.Episode)
 		hasError = true
 	}
+	if !reflect.DeepEqual(target.Episodes, value.Episodes) {
+		t.Errorf(`(case: "%s") Expected "Episodes" to be %#v, but got %#v`, testcase, target.Episodes, value.Episodes)
+		hasError = true
+	}
+	if !target.AirDate.Equal(value.AirDate) {
+		t.Errorf(`(case: "%s") Expected "AirDate" to be %s, but got %s`, testcase, target.AirDate, value.AirDate)
+		hasError = true
+	}
 	if target.Year != value.Year {
 		t.Errorf(`(case: "%s") Expected "Year" to be %#v, but got %#v`, testcase, target.Year, value.Year)
 		hasError = true
@@ -279,6 +531,70 @@ func assertEqualsInformation(t *testing.T, testcase string, target, value Inform
 		t.Errorf(`(case: "%s") Expected "ThreeD" to be %#v, but got %#v`, testcase, target.ThreeD, value.ThreeD)
 		hasError = true
 	}
+	if target.AbsoluteEpisode != value.AbsoluteEpisode {
+		t.Errorf(`(case: "%s") Expected "AbsoluteEpisode" to be %#v, but got %#v`, testcase, target.AbsoluteEpisode, value.AbsoluteEpisode)
+		hasError = true
+	}
+	if target.Version != value.Version {
+		t.Errorf(`(case: "%s") Expected "Version" to be %#v, but got %#v`, testcase, target.Version, value.Version)
+		hasError = true
+	}
+	if target.BatchStart != value.BatchStart {
+		t.Errorf(`(case: "%s") Expected "BatchStart" to be %#v, but got %#v`, testcase, target.BatchStart, value.BatchStart)
+		hasError = true
+	}
+	if target.BatchEnd != value.BatchEnd {
+		t.Errorf(`(case: "%s") Expected "BatchEnd" to be %#v, but got %#v`, testcase, target.BatchEnd, value.BatchEnd)
+		hasError = true
+	}
+	if target.CRC32 != value.CRC32 {
+		t.Errorf(`(case: "%s") Expected "CRC32" to be %#v, but got %#v`, testcase, target.CRC32, value.CRC32)
+		hasError = true
+	}
+	if !reflect.DeepEqual(target.Languages, value.Languages) {
+		t.Errorf(`(case: "%s") Expected "Languages" to be %v, but got %v`, testcase, target.Languages, value.Languages)
+		hasError = true
+	}
+	if !reflect.DeepEqual(target.SubtitleLanguages, value.SubtitleLanguages) {
+		t.Errorf(`(case: "%s") Expected "SubtitleLanguages" to be %v, but got %v`, testcase, target.SubtitleLanguages, value.SubtitleLanguages)
+		hasError = true
+	}
+	if target.Subbed != value.Subbed {
+		t.Errorf(`(case: "%s") Expected "Subbed" to be %#v, but got %#v`, testcase, target.Subbed, value.Subbed)
+		hasError = true
+	}
+	if target.Dubbed != value.Dubbed {
+		t.Errorf(`(case: "%s") Expected "Dubbed" to be %#v, but got %#v`, testcase, target.Dubbed, value.Dubbed)
+		hasError = true
+	}
+	if target.Multi != value.Multi {
+		t.Errorf(`(case: "%s") Expected "Multi" to be %#v, but got %#v`, testcase, target.Multi, value.Multi)
+		hasError = true
+	}
+	if target.StreamingSource != value.StreamingSource {
+		t.Errorf(`(case: "%s") Expected "StreamingSource" to be %#v, but got %#v`, testcase, target.StreamingSource, value.StreamingSource)
+		hasError = true
+	}
+	if !reflect.DeepEqual(target.HDR, value.HDR) {
+		t.Errorf(`(case: "%s") Expected "HDR" to be %#v, but got %#v`, testcase, target.HDR, value.HDR)
+		hasError = true
+	}
+	if target.BitDepth != value.BitDepth {
+		t.Errorf(`(case: "%s") Expected "BitDepth" to be %#v, but got %#v`, testcase, target.BitDepth, value.BitDepth)
+		hasError = true
+	}
+	if target.AudioChannels != value.AudioChannels {
+		t.Errorf(`(case: "%s") Expected "AudioChannels" to be %#v, but got %#v`, testcase, target.AudioChannels, value.AudioChannels)
+		hasError = true
+	}
+	if target.Atmos != value.Atmos {
+		t.Errorf(`(case: "%s") Expected "Atmos" to be %#v, but got %#v`, testcase, target.Atmos, value.Atmos)
+		hasError = true
+	}
+	if target.Remux != value.Remux {
+		t.Errorf(`(case: "%s") Expected "Remux" to be %#v, but got %#v`, testcase, target.Remux, value.Remux)
+		hasError = true
+	}
 
 	return !hasError
 }
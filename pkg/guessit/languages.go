@@ -0,0 +1,198 @@
+package guessit
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// languageNames are the languages of the audio, as release names tag them
+var languageNames = map[string][]language.Tag{
+	"ENGLISH":    {language.English},
+	"FRENCH":     {language.French},
+	"TRUEFRENCH": {language.French},
+	"VFF":        {language.French},
+	"VFQ":        {language.French},
+	"GERMAN":     {language.German},
+	"ITALIAN":    {language.Italian},
+	"SPANISH":    {language.Spanish},
+	"CASTELLANO": {language.Spanish},
+	"LATINO":     {language.LatinAmericanSpanish},
+	"PORTUGUESE": {language.Portuguese},
+	"RUSSIAN":    {language.Russian},
+	"POLISH":     {language.Polish},
+	"DUTCH":      {language.Dutch},
+	"FLEMISH":    {language.Dutch},
+	"SWEDISH":    {language.Swedish},
+	"DANISH":     {language.Danish},
+	"NORWEGIAN":  {language.Norwegian},
+	"FINNISH":    {language.Finnish},
+	"TURKISH":    {language.Turkish},
+	"GREEK":      {language.Greek},
+	"CZECH":      {language.Czech},
+	"HUNGARIAN":  {language.Hungarian},
+	"HEBREW":     {language.Hebrew},
+	"ARABIC":     {language.Arabic},
+	"HINDI":      {language.Hindi},
+	"TAMIL":      {language.Tamil},
+	"THAI":       {language.Thai},
+	"KOREAN":     {language.Korean},
+	"JAPANESE":   {language.Japanese},
+	"CHINESE":    {language.Chinese},
+	"MANDARIN":   {language.Chinese},
+	"CANTONESE":  {language.MustParse("yue")},
+}
+
+// subtitleNames are the languages of the subtitles a release has, usually burnt in
+var subtitleNames = map[string][]language.Tag{
+	"VOSTFR":    {language.French},
+	"SUBFRENCH": {language.French},
+	"KORSUB":    {language.Korean},
+	"KORSUBS":   {language.Korean},
+	"ENGSUB":    {language.English},
+	"ENGSUBS":   {language.English},
+	"CHISUB":    {language.Chinese},
+	"NORDIC":    {language.Danish, language.Finnish, language.Norwegian, language.Swedish},
+}
+
+// languageCodes are the short tags of the audio languages, as in "iTA-ENG"
+var languageCodes = map[string]language.Tag{
+	"ENG": language.English,
+	"FRE": language.French,
+	"FRA": language.French,
+	"GER": language.German,
+	"DEU": language.German,
+	"ITA": language.Italian,
+	"SPA": language.Spanish,
+	"ESP": language.Spanish,
+	"POR": language.Portuguese,
+	"RUS": language.Russian,
+	"JPN": language.Japanese,
+	"KOR": language.Korean,
+	"HIN": language.Hindi,
+}
+
+var (
+	reLanguageTag  = regexp.MustCompile(`(?i)\b(` + alternation(languageNames, subtitleNames, map[string][]language.Tag{"VOST": nil, "SUBBED": nil, "DUBBED": nil, "MULTI": nil}) + `)\b`)
+	reLanguageCode = regexp.MustCompile(`\b(?:i|[A-Z])[A-Z]{2}(?:-(?:i|[A-Z])[A-Z]{2})*\b`)
+)
+
+// alternation joins the keys of maps into a regexp alternation, longest first
+func alternation(maps ...map[string][]language.Tag) string {
+	var keys []string
+	for _, m := range maps {
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j])
+	})
+	return strings.Join(keys, "|")
+}
+
+// languageTags are the language and subtitle tags of a release name
+type languageTags struct {
+	matches   [][]int
+	spans     map[string][]int // The first match of each field
+	languages []language.Tag
+	subtitles []language.Tag
+	subbed    bool
+	dubbed    bool
+	multi     bool
+	guessed   bool // The languages were only found in short codes ("ITA")
+}
+
+// set records that a match told a field, if it is the first one that did
+func (t *languageTags) set(field string, match []int) {
+	if _, ok := t.spans[field]; !ok {
+		t.spans[field] = match
+	}
+}
+
+// findLanguages finds the language tags after from, where the title ended. Before
+// it, words like "French" are more likely part of the title ("The French Dispatch")
+func findLanguages(str string, from int) languageTags {
+	res := languageTags{spans: make(map[string][]int)}
+	if from < 0 {
+		return res
+	}
+
+	for _, match := range reLanguageTag.FindAllStringIndex(str, -1) {
+		if match[0] < from {
+			continue
+		}
+		res.matches = append(res.matches, match)
+
+		tag := strings.ToUpper(str[match[0]:match[1]])
+		switch tag {
+		case "SUBBED", "VOST":
+			res.subbed = true
+			res.set("Subbed", match)
+		case "DUBBED":
+			res.dubbed = true
+			res.set("Dubbed", match)
+		case "MULTI":
+			res.multi = true
+			res.set("Multi", match)
+		}
+		if langs, ok := subtitleNames[tag]; ok {
+			res.subtitles = appendTags(res.subtitles, langs...)
+			res.set("SubtitleLanguages", match)
+			// Nordic releases have their subtitles in tracks
+			if tag != "NORDIC" {
+				res.subbed = true
+				res.set("Subbed", match)
+			}
+		}
+		if langs, ok := languageNames[tag]; ok {
+			res.languages = appendTags(res.languages, langs...)
+			res.set("Languages", match)
+		}
+	}
+
+	for _, match := range reLanguageCode.FindAllStringIndex(str, -1) {
+		if match[0] < from {
+			continue
+		}
+
+		var langs []language.Tag
+		for _, code := range strings.Split(str[match[0]:match[1]], "-") {
+			lang, ok := languageCodes[strings.ToUpper(code)]
+			if !ok {
+				langs = nil
+				break
+			}
+			langs = append(langs, lang)
+		}
+		if langs != nil {
+			res.matches = append(res.matches, match)
+			res.languages = appendTags(res.languages, langs...)
+			if _, ok := res.spans["Languages"]; !ok {
+				res.guessed = true
+				res.set("Languages", match)
+			}
+		}
+	}
+
+	sort.Slice(res.matches, func(i, j int) bool {
+		return res.matches[i][0] < res.matches[j][0]
+	})
+	return res
+}
+
+// appendTags appends the tags that are not in list yet
+func appendTags(list []language.Tag, tags ...language.Tag) []language.Tag {
+	for _, tag := range tags {
+		found := false
+		for _, t := range list {
+			found = found || t == tag
+		}
+		if !found {
+			list = append(list, tag)
+		}
+	}
+	return list
+}
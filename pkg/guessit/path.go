@@ -0,0 +1,152 @@
+package guessit
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	reSeasonDir   = regexp.MustCompile(`(?i)^(?:season|series|temporada|saison|s)[ ._-]*(\d{1,2})$`)
+	reBareEpisode = regexp.MustCompile(`(?i)^(?:e|ep|episode)?[ ._-]*(\d{1,3})\b`)
+)
+
+// maxParentDirs is how many directories above a file are looked at
+const maxParentDirs = 3
+
+// ParsePath parses the name of a file, completing what it lacks with the directories
+// it is in: the series ("Show (2019)/"), the season ("Season 2/") and the release
+// ("Show.S01.1080p.WEBRip.x264-GRP/"). What the name tells always wins.
+// Both "/" and "\" split directories, whatever the system is
+func ParsePath(p string) Information {
+	return ParsePathDetailed(p).Information
+}
+
+// ParsePathDetailed parses a path like ParsePath, telling where each field was found
+// in the name of the file. Fields taken from the directories have no span
+func ParsePathDetailed(p string) Details {
+	p = strings.ReplaceAll(p, "\\", "/")
+	name := path.Base(p)
+	details := ParseDetailed(name)
+	dirs := parseDirs(path.Dir(p))
+
+	// Inside a season, names like "01.mkv" are episodes
+	if details.Episode == 0 && details.Season == 0 && dirs.Season != 0 {
+		if match := reBareEpisode.FindStringSubmatch(name); match != nil {
+			details.Episode, _ = strconv.Atoi(match[1])
+			details.Title = ""
+			details.Confidence["Episode"] = 0.7
+		}
+	}
+
+	return MergeDetails(details, dirs)
+}
+
+// parseDirs parses the directories of a path, from the innermost to the outermost,
+// until one of them has a title. Season directories only have a season
+func parseDirs(dir string) Details {
+	details := Details{}
+	for i := 0; i < maxParentDirs && dir != "." && dir != "/" && dir != ""; i++ {
+		name := path.Base(dir)
+		dir = path.Dir(dir)
+
+		if match := reSeasonDir.FindStringSubmatch(name); match != nil {
+			if details.Season == 0 {
+				details.Season, _ = strconv.Atoi(match[1])
+			}
+			continue
+		}
+
+		details = MergeDetails(details, ParseDetailed(name))
+		if details.Title != "" {
+			break
+		}
+	}
+	return details
+}
+
+// Merge fills what info lacks with what parent has, like a file with its directory
+// or a subtitle with its release. Batches and checksums belong to a single name,
+// so they are never taken from the parent
+func Merge(info, parent Information) Information {
+	mergeString(&info.Title, parent.Title)
+	mergeInt(&info.Season, parent.Season)
+	if info.Episode == 0 && parent.Episode != 0 {
+		info.Episode = parent.Episode
+		info.Episodes = parent.Episodes
+		info.AbsoluteEpisode = parent.AbsoluteEpisode
+	}
+	if info.AirDate.IsZero() && !parent.AirDate.IsZero() {
+		info.AirDate = parent.AirDate
+	}
+	mergeInt(&info.Year, parent.Year)
+	mergeString(&info.Resolution, parent.Resolution)
+	mergeString(&info.Release, parent.Release)
+	mergeString(&info.VideoCodec, parent.VideoCodec)
+	mergeString(&info.AudioCodec, parent.AudioCodec)
+	mergeString(&info.Group, parent.Group)
+	mergeString(&info.Region, parent.Region)
+	mergeString(&info.Website, parent.Website)
+	mergeInt(&info.Version, parent.Version)
+	if info.Languages == nil {
+		info.Languages = parent.Languages
+	}
+	if info.SubtitleLanguages == nil {
+		info.SubtitleLanguages = parent.SubtitleLanguages
+	}
+	mergeString(&info.StreamingSource, parent.StreamingSource)
+	if info.HDR == nil {
+		info.HDR = parent.HDR
+	}
+	mergeInt(&info.BitDepth, parent.BitDepth)
+	mergeString(&info.AudioChannels, parent.AudioChannels)
+
+	info.Extended = info.Extended || parent.Extended
+	info.Remastered = info.Remastered || parent.Remastered
+	info.Theatrical = info.Theatrical || parent.Theatrical
+	info.DirectorsCut = info.DirectorsCut || parent.DirectorsCut
+	info.Hardcoded = info.Hardcoded || parent.Hardcoded
+	info.Proper = info.Proper || parent.Proper
+	info.Repack = info.Repack || parent.Repack
+	info.Widescreen = info.Widescreen || parent.Widescreen
+	info.Unrated = info.Unrated || parent.Unrated
+	info.ThreeD = info.ThreeD || parent.ThreeD
+	info.Subbed = info.Subbed || parent.Subbed
+	info.Dubbed = info.Dubbed || parent.Dubbed
+	info.Multi = info.Multi || parent.Multi
+	info.Atmos = info.Atmos || parent.Atmos
+	info.Remux = info.Remux || parent.Remux
+
+	return info
+}
+
+// MergeDetails merges like Merge, taking the confidence of the fields details lacked
+// from parent. The spans are only the ones of details, since they are in its name
+func MergeDetails(details, parent Details) Details {
+	confidence := make(map[string]float64, len(details.Confidence)+len(parent.Confidence))
+	for field, c := range parent.Confidence {
+		if field != "BatchStart" && field != "BatchEnd" && field != "CRC32" {
+			confidence[field] = c
+		}
+	}
+	for field, c := range details.Confidence {
+		confidence[field] = c
+	}
+
+	details.Information = Merge(details.Information, parent.Information)
+	details.Confidence = confidence
+	return details
+}
+
+func mergeString(value *string, parent string) {
+	if *value == "" {
+		*value = parent
+	}
+}
+
+func mergeInt(value *int, parent int) {
+	if *value == 0 {
+		*value = parent
+	}
+}
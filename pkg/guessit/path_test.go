@@ -0,0 +1,89 @@
+package guessit
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+var pathTestCases = map[string]Information{
+	"Squid.Game.S01.KOREAN.WEBRip.x264-ION10/01.mkv": {
+		Title:      "Squid Game",
+		Season:     1,
+		Episode:    1,
+		Release:    "WEBRip",
+		VideoCodec: "x264",
+		Group:      "ION10",
+		Container:  "mkv",
+		Languages:  []language.Tag{language.Korean},
+	},
+	"/media/TV/Show (2019)/Season 2/E05.mkv": {
+		Title:     "Show",
+		Year:      2019,
+		Season:    2,
+		Episode:   5,
+		Container: "mkv",
+	},
+	"Show.S01.1080p.WEBRip.x264-GRP/Show.S01E02.720p.mkv": {
+		Title:      "Show",
+		Season:     1,
+		Episode:    2,
+		Resolution: "720p",
+		Release:    "WEBRip",
+		VideoCodec: "x264",
+		Group:      "GRP",
+		Container:  "mkv",
+	},
+	`C:\tv\Show\Season 2\E05.mkv`: {
+		Title:     "Show",
+		Season:    2,
+		Episode:   5,
+		Container: "mkv",
+	},
+	"/home/user/Movies/300.2006.1080p.BluRay.x264-GRP.mkv": {
+		Title:      "300",
+		Year:       2006,
+		Resolution: "1080p",
+		Release:    "BluRay",
+		VideoCodec: "x264",
+		Group:      "GRP",
+		Container:  "mkv",
+	},
+}
+
+func TestParsePath(t *testing.T) {
+	t.Parallel()
+
+	for p, target := range pathTestCases {
+		// Paths may also use the separator of the system
+		for _, p := range []string{p, filepath.FromSlash(p)} {
+			value := ParsePath(p)
+
+			if !assertEqualsInformation(t, p, target, value) {
+				t.Logf(`(case: "%s") value.Rest => %#v`, p, value.Rest)
+			}
+		}
+	}
+}
+
+func TestParsePathDetailed(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		path       string
+		field      string
+		confidence float64
+	}{
+		// Bare numbers inside a season are guessed to be episodes
+		{"/media/TV/Show (2019)/Season 2/05.mkv", "Episode", 0.7},
+		{"/media/TV/Show (2019)/Season 2/E05.mkv", "Year", 1},
+		{"Show.S01.1080p.WEBRip.x264-GRP/Show.S01E02.720p.mkv", "Group", 0.9},
+	}
+
+	for _, c := range cases {
+		if confidence := ParsePathDetailed(c.path).GetConfidence(c.field); confidence != c.confidence {
+			t.Errorf(`(case: "%s") Expected "%s" with %.1f, but got %.1f`, c.path, c.field, c.confidence, confidence)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package sublime
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"github.com/PietroCarrara/sublime/pkg/subtitle"
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archiver/v3"
+	"github.com/nwaples/rardecode"
+	"golang.org/x/text/language"
+)
+
+const (
+	maxSubtitleSize = 10 * 1024 * 1024  // Size of the largest subtitle that will be extracted
+	maxArchiveSize  = 100 * 1024 * 1024 // Size of the largest archive that will be read
+	maxArchiveDepth = 3                 // How deep archives may be nested in each other
+)
+
+// ErrArchiveTooLarge is returned when reading an archive larger than what is read
+var ErrArchiveTooLarge = errors.New("archive too large")
+
+// ArchiveEntry is a subtitle extracted from an archive, like
+// the ones with a whole season or with many releases of a movie
+type ArchiveEntry struct {
+	// Path of the subtitle inside the archive. The paths of nested
+	// archives are joined, like "Show.S01.zip/Show.S01E01.srt"
+	Name string
+	// Information in the name of the subtitle, completed by the
+	// names of the directories and archives it is in
+	Info guessit.Information
+
+	confidence map[string]float64
+	data       []byte
+}
+
+// Open returns the content of the subtitle
+func (e ArchiveEntry) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(e.data)), nil
+}
+
+// GetFormatExtension returns the extension of the subtitle (like "srt")
+func (e ArchiveEntry) GetFormatExtension() string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(e.Name), "."))
+}
+
+// ReadArchive lists the subtitles in a ZIP or RAR archive, including the ones in archives
+// nested in it. Archives over 100MB, or with more than 100MB inside them (counting every
+// level of nesting), return ErrArchiveTooLarge. Subtitles and nested archives too large
+// to be what they claim are left out
+func ReadArchive(r io.Reader) ([]ArchiveEntry, error) {
+	data, err := readLimited(r, maxArchiveSize)
+	if err != nil {
+		return nil, err
+	}
+	budget := int64(maxArchiveSize)
+	return readArchive(data, "", maxArchiveDepth, &budget)
+}
+
+// readLimited reads at most limit bytes, returning ErrArchiveTooLarge if there are more
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrArchiveTooLarge
+	}
+	return data, nil
+}
+
+// readArchive lists the subtitles in an archive. budget is what is left to be extracted
+// from the outermost archive, shared by all of the archives nested in it
+func readArchive(data []byte, prefix string, depth int, budget *int64) ([]ArchiveEntry, error) {
+	input := bytes.NewReader(data)
+	format, err := archiver.ByHeader(input)
+	if err != nil {
+		return nil, err
+	}
+	reader, ok := format.(archiver.Reader)
+	if !ok {
+		return nil, errors.New("unsupported archive format")
+	}
+
+	if err := reader.Open(input, int64(len(data))); err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var entries []ArchiveEntry
+	for {
+		f, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Zip and RAR files only give the base name, but their headers have the full path
+		name := f.Name()
+		switch h := f.Header.(type) {
+		case zip.FileHeader:
+			name = h.Name
+		case *rardecode.FileHeader:
+			name = h.Name
+		}
+		name = prefix + strings.ReplaceAll(name, `\`, "/")
+
+		_, err = subtitle.FormatFromExtension(path.Ext(name))
+		nested := isArchive(name) && depth > 1
+		if f.IsDir() || (err != nil && !nested) {
+			f.Close()
+			continue
+		}
+
+		limit := int64(maxSubtitleSize)
+		if nested {
+			limit = maxArchiveSize
+		}
+		exhausted := limit >= *budget
+		if exhausted {
+			limit = *budget
+		}
+		content, err := readLimited(f, limit)
+		f.Close()
+		if err == ErrArchiveTooLarge && !exhausted {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		*budget -= int64(len(content))
+
+		if nested {
+			inner, err := readArchive(content, name+"/", depth-1, budget)
+			if err == ErrArchiveTooLarge {
+				return nil, err
+			}
+			// A broken archive inside another doesn't spoil the rest of it
+			if err == nil {
+				entries = append(entries, inner...)
+			}
+			continue
+		}
+
+		details := entryDetails(name)
+		entries = append(entries, ArchiveEntry{Name: name, Info: details.Information, confidence: details.Confidence, data: content})
+	}
+
+	return entries, nil
+}
+
+func isArchive(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".zip", ".rar":
+		return true
+	}
+	return false
+}
+
+// entryDetails parses the name of a subtitle. What it lacks is taken from its
+// directories and archives, from the innermost to the outermost
+func entryDetails(name string) guessit.Details {
+	details := guessit.ParseDetailed(strings.TrimSuffix(path.Base(name), path.Ext(name)))
+
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if details.Title != "" && details.Season != 0 {
+			break
+		}
+
+		parent := guessit.ParseDetailed(strings.TrimSuffix(path.Base(dir), path.Ext(dir)))
+		if details.Title == "" {
+			details.Title = parent.Title
+		}
+		if details.Season == 0 && parent.Season != 0 {
+			details.Season = parent.Season
+			details.Confidence["Season"] = parent.GetConfidence("Season")
+		}
+	}
+	return details
+}
+
+// MatchArchiveEntries returns the entries that may be for the season and episode
+// (or air date) of a target. Entries without a season or episode (like the ones of movies) are kept
+func MatchArchiveEntries(entries []ArchiveEntry, t *FileTarget) []ArchiveEntry {
+	target := t.GetInfo()
+
+	var res []ArchiveEntry
+	for _, e := range entries {
+		if target.Season != 0 && e.Info.Season != 0 && e.Info.Season != target.Season {
+			continue
+		}
+		if target.Episode != 0 && e.Info.Episode != 0 && !target.SharesEpisode(e.Info) {
+			continue
+		}
+		if !target.AirDate.IsZero() && !e.Info.AirDate.IsZero() && !target.AirDate.Equal(e.Info.AirDate) {
+			continue
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// PickArchiveEntry returns the entry that best matches a target, as
+// rated by a scorer. Returns false if none of them match
+func PickArchiveEntry(entries []ArchiveEntry, t *FileTarget, scorer Scorer) (ArchiveEntry, bool) {
+	target := t.GetDetails()
+
+	var best ArchiveEntry
+	bestScore := -1.0
+	for _, e := range MatchArchiveEntries(entries, t) {
+		if score := scorer.Score(target, ArchiveCandidate{Entry: e, Target: t}); score > bestScore {
+			best, bestScore = e, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// ArchiveCandidate is a SubtitleCandidate for a subtitle inside an archive.
+// Services fill in what they know about it
+type ArchiveCandidate struct {
+	Entry   ArchiveEntry
+	Target  *FileTarget
+	Lang    language.Tag
+	Service string
+	Ranking float32
+}
+
+func (c ArchiveCandidate) GetFormatExtension() string {
+	return c.Entry.GetFormatExtension()
+}
+
+func (c ArchiveCandidate) GetFileTarget() *FileTarget {
+	return c.Target
+}
+
+func (c ArchiveCandidate) GetLang() language.Tag {
+	return c.Lang
+}
+
+func (c ArchiveCandidate) GetService() string {
+	return c.Service
+}
+
+func (c ArchiveCandidate) GetRanking() float32 {
+	return c.Ranking
+}
+
+func (c ArchiveCandidate) GetInfo() guessit.Information {
+	return c.Entry.Info
+}
+
+func (c ArchiveCandidate) GetDetails() guessit.Details {
+	return guessit.Details{Information: c.Entry.Info, Confidence: c.Entry.confidence}
+}
+
+func (c ArchiveCandidate) Open() (io.ReadCloser, error) {
+	return c.Entry.Open()
+}
@@ -0,0 +1,128 @@
+package sublime
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"testing"
+)
+
+type zipFile struct {
+	name string
+	data []byte
+}
+
+func makeZip(t *testing.T, files ...zipFile) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range files {
+		out, err := w.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Write(f.data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadArchive(t *testing.T) {
+	t.Parallel()
+
+	season := makeZip(t,
+		zipFile{"Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.srt", []byte("1")},
+		zipFile{"Mr.Robot.S02E02.720p.WEBRip.x264-KILLERS.srt", []byte("2")},
+	)
+	archive := makeZip(t,
+		zipFile{"Mr.Robot.S02.WEBRip.zip", season},
+		zipFile{"HDTV/E01.HDTV.x264-FLEET.srt", []byte("3")},
+		zipFile{"Broken.zip", []byte("not a zip")},
+		zipFile{"Read me.txt", nil},
+	)
+
+	entries, err := ReadArchive(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	targets := []struct {
+		name    string
+		content string
+		season  int
+		episode int
+	}{
+		{"HDTV/E01.HDTV.x264-FLEET.srt", "3", 0, 1},
+		{"Mr.Robot.S02.WEBRip.zip/Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.srt", "1", 2, 1},
+		{"Mr.Robot.S02.WEBRip.zip/Mr.Robot.S02E02.720p.WEBRip.x264-KILLERS.srt", "2", 2, 2},
+	}
+	if len(entries) != len(targets) {
+		t.Fatalf(`Expected %d entries, but got %+v`, len(targets), entries)
+	}
+	for i, target := range targets {
+		e := entries[i]
+		stream, _ := e.Open()
+		content, _ := ioutil.ReadAll(stream)
+		if e.Name != target.name || string(content) != target.content {
+			t.Errorf(`(case: "%s") Expected "%s" with "%s", but got "%s" with "%s"`, target.name, target.name, target.content, e.Name, content)
+		}
+		if e.Info.Season != target.season || e.Info.Episode != target.episode || e.GetFormatExtension() != "srt" {
+			t.Errorf(`(case: "%s") Expected S%02dE%02d in srt, but got S%02dE%02d in %s`, target.name, target.season, target.episode, e.Info.Season, e.Info.Episode, e.GetFormatExtension())
+		}
+	}
+
+	if _, err := ReadArchive(bytes.NewReader([]byte("not an archive"))); err == nil {
+		t.Errorf(`Expected an error for something that is not an archive`)
+	}
+
+	// What is extracted at every level of nesting adds up
+	nested := makeZip(t, zipFile{"a.srt", []byte("1")}, zipFile{"b.srt", []byte("2")})
+	outer := makeZip(t, zipFile{"c.srt", []byte("3")}, zipFile{"inner.zip", nested})
+	for extra, target := range map[int64]error{3: nil, 2: ErrArchiveTooLarge} {
+		budget := int64(len(nested)) + extra
+		if _, err := readArchive(outer, "", maxArchiveDepth, &budget); err != target {
+			t.Errorf(`(case: %d bytes besides the nested archive) Expected %v, but got %v`, extra, target, err)
+		}
+	}
+
+	// Archives are not cut at the limit, which would break them
+	for size, target := range map[int]error{4: nil, 5: ErrArchiveTooLarge} {
+		if _, err := readLimited(bytes.NewReader(make([]byte, size)), 4); err != target {
+			t.Errorf(`(case: %d bytes) Expected %v, but got %v`, size, target, err)
+		}
+	}
+}
+
+func TestPickArchiveEntry(t *testing.T) {
+	t.Parallel()
+
+	entries := []ArchiveEntry{
+		{Name: "Mr.Robot.S02E01.HDTV.x264-FLEET.srt"},
+		{Name: "Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.srt"},
+		{Name: "Mr.Robot.S02E02.720p.WEBRip.x264-KILLERS.srt"},
+		{Name: "Mr.Robot.S01E02.720p.WEBRip.x264-KILLERS.srt"},
+	}
+	for i := range entries {
+		details := entryDetails(entries[i].Name)
+		entries[i].Info, entries[i].confidence = details.Information, details.Confidence
+	}
+
+	cases := map[string]string{
+		"Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.mkv": "Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.srt",
+		"Mr.Robot.S02E01.HDTV.x264-FLEET.mkv":          "Mr.Robot.S02E01.HDTV.x264-FLEET.srt",
+		"Mr.Robot.S02E02.HDTV.x264-FLEET.mkv":          "Mr.Robot.S02E02.720p.WEBRip.x264-KILLERS.srt",
+		"Mr.Robot.S03E01.720p.WEBRip.x264-KILLERS.mkv": "",
+	}
+
+	for file, target := range cases {
+		e, ok := PickArchiveEntry(entries, NewFileTarget(file), NewDefaultScorer())
+		if e.Name != target || ok != (target != "") {
+			t.Errorf(`(case: "%s") Expected "%s", but got "%s"`, file, target, e.Name)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package sublime
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"golang.org/x/text/language"
+)
+
+// ErrNotCached is returned when a subtitle is not in a cache that can't go online
+var ErrNotCached = errors.New("subtitle is not cached")
+
+// Ways of using a Cache
+type CacheMode int
+
+const (
+	CacheReadWrite CacheMode = iota // Use what is cached, searching and downloading the rest
+	CacheOnly                       // Only use what is cached, never going online
+)
+
+// Cache keeps the search results and the downloaded subtitles of services on disk, so
+// that running again over the same files doesn't repeat them. Results are kept by
+// service, file (by its hash, or its name when it can't be hashed) and language
+type Cache struct {
+	dir  string
+	ttl  time.Duration
+	mode CacheMode
+}
+
+// cacheEntry is the result of a search for a file in a language
+type cacheEntry struct {
+	Created    time.Time
+	Candidates []candidateData
+}
+
+// candidateData is what is kept of a candidate
+type candidateData struct {
+	Format          string
+	Lang            string
+	Service         string
+	Ranking         float32
+	Info            guessit.Information
+	Confidence      map[string]float64
+	Hash            bool
+	HearingImpaired bool
+	Path            string
+}
+
+// DefaultCacheDir returns the directory the cache is kept in by default,
+// inside the user cache directory (like $XDG_CACHE_HOME/sublime)
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sublime"), nil
+}
+
+// NewCache creates a cache in a directory. Entries older than ttl are
+// ignored; a ttl of 0 keeps them forever
+func NewCache(dir string, ttl time.Duration, mode CacheMode) *Cache {
+	return &Cache{dir: dir, ttl: ttl, mode: mode}
+}
+
+// Wrap decorates a service so that it uses the cache. The service should be
+// configured through the CachedService, since its options are part of the cache keys
+func (c *Cache) Wrap(s Service) *CachedService {
+	return &CachedService{ContextService: AsContextService(s), service: s, cache: c, config: make(map[string]string)}
+}
+
+func cacheKey(parts ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(kind, key string) string {
+	return filepath.Join(c.dir, kind, key[:2], key)
+}
+
+func (c *Cache) expired(created time.Time) bool {
+	return c.ttl > 0 && time.Since(created) > c.ttl
+}
+
+func (c *Cache) loadSearch(key string) (*cacheEntry, bool) {
+	data, err := ioutil.ReadFile(c.path("searches", key))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	if json.Unmarshal(data, &entry) != nil || c.expired(entry.Created) {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (c *Cache) saveSearch(key string, entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.write(c.path("searches", key), data)
+}
+
+func (c *Cache) loadSubtitle(key string) ([]byte, bool) {
+	path := c.path("subtitles", key)
+	stat, err := os.Stat(path)
+	if err != nil || c.expired(stat.ModTime()) {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(path)
+	return data, err == nil
+}
+
+func (c *Cache) saveSubtitle(key string, data []byte) error {
+	return c.write(c.path("subtitles", key), data)
+}
+
+// write saves a file atomically, so that concurrent runs never read half of it
+func (c *Cache) write(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// CachedService decorates a Service with a Cache. Failing to write to the cache
+// never fails a search or a download, since it is only an optimization
+type CachedService struct {
+	ContextService
+	service Service
+	cache   *Cache
+	config  map[string]string
+}
+
+// Unwrap returns the decorated service
+func (s *CachedService) Unwrap() Service {
+	return s.service
+}
+
+// SetConfig configures the decorated service, remembering the option. Searches with other
+// options (like another account or another server) are cached apart, except for the
+// options of a LimitedService, which don't change what is found
+func (s *CachedService) SetConfig(name, value string) error {
+	if err := s.ContextService.SetConfig(name, value); err != nil {
+		return err
+	}
+	if !limitsOption(s.service, name) {
+		s.config[name] = value
+	}
+	return nil
+}
+
+func (s *CachedService) GetCandidatesForFiles(files []*FileTarget, langs []language.Tag) <-chan SubtitleCandidate {
+	return LegacyCandidates(s, files, langs)
+}
+
+// searchKey identifies the search for a file in a language
+func (s *CachedService) searchKey(f *FileTarget, lang language.Tag) string {
+	query := "name:" + f.GetName()
+	if hash, err := f.GetHash(); err == nil {
+		size, _ := f.GetSize()
+		query = fmt.Sprintf("hash:%s:%d", FormatMovieHash(hash), size)
+	}
+
+	names := make([]string, 0, len(s.config))
+	for name := range s.config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	config := make([]string, len(names))
+	for i, name := range names {
+		config[i] = name + "=" + s.config[name]
+	}
+
+	return cacheKey(s.GetName(), cacheKey(config...), query, lang.String())
+}
+
+// GetCandidates offers the cached candidates, and searches the service only for
+// the files and languages that aren't cached. Files missing the same languages
+// are searched together
+func (s *CachedService) GetCandidates(ctx context.Context, files []*FileTarget, langs []language.Tag) <-chan CandidateResult {
+	channel := make(chan CandidateResult)
+
+	go func() {
+		defer close(channel)
+
+		var groups []string
+		missing := make(map[string][]*FileTarget)
+		missingLangs := make(map[string][]language.Tag)
+
+		for _, f := range files {
+			var miss []language.Tag
+			for _, lang := range langs {
+				entry, ok := s.cache.loadSearch(s.searchKey(f, lang))
+				if !ok {
+					miss = append(miss, lang)
+					continue
+				}
+
+				for _, data := range entry.Candidates {
+					c := &cachedCandidate{data: data, t: f, lang: lang, s: s}
+					// Offline, only the subtitles that were downloaded are useful
+					if s.cache.mode == CacheOnly && !c.cached() {
+						continue
+					}
+					channel <- CandidateResult{Candidate: c}
+				}
+			}
+
+			if len(miss) == 0 || s.cache.mode == CacheOnly {
+				continue
+			}
+			group := languageList(miss)
+			if _, ok := missing[group]; !ok {
+				groups = append(groups, group)
+			}
+			missing[group] = append(missing[group], f)
+			missingLangs[group] = miss
+		}
+
+		for _, group := range groups {
+			if !s.search(ctx, missing[group], missingLangs[group], channel) {
+				return
+			}
+		}
+	}()
+
+	return channel
+}
+
+func languageList(langs []language.Tag) string {
+	names := make([]string, len(langs))
+	for i, l := range langs {
+		names[i] = l.String()
+	}
+	return strings.Join(names, ",")
+}
+
+// search searches the service, forwarding its results and caching the ones of the files
+// it had no problems with. Returns false if the search was interrupted by the context
+func (s *CachedService) search(ctx context.Context, files []*FileTarget, langs []language.Tag, channel chan<- CandidateResult) bool {
+	found := make(map[*FileTarget]map[language.Tag][]candidateData)
+	failed := make(map[*FileTarget]bool)
+	failedAll := false
+
+	for res := range s.ContextService.GetCandidates(ctx, files, langs) {
+		if res.Err != nil {
+			var serviceErr *ServiceError
+			if errors.As(res.Err, &serviceErr) && serviceErr.File != nil {
+				failed[serviceErr.File] = true
+			} else {
+				failedAll = true
+			}
+			channel <- res
+			continue
+		}
+
+		c := res.Candidate
+		data := newCandidateData(c)
+		f := c.GetFileTarget()
+		if found[f] == nil {
+			found[f] = make(map[language.Tag][]candidateData)
+		}
+		found[f][c.GetLang()] = append(found[f][c.GetLang()], data)
+
+		channel <- CandidateResult{Candidate: &cachingCandidate{c, s, s.subtitleKey(f, c.GetLang(), data)}}
+	}
+
+	if ctx.Err() != nil {
+		return false
+	}
+	if failedAll {
+		return true
+	}
+
+	// Searches without results are cached too, so they are not repeated
+	for _, f := range files {
+		if failed[f] {
+			continue
+		}
+		for _, lang := range langs {
+			s.cache.saveSearch(s.searchKey(f, lang), &cacheEntry{Created: time.Now(), Candidates: found[f][lang]})
+		}
+	}
+	return true
+}
+
+func newCandidateData(c SubtitleCandidate) candidateData {
+	return candidateData{
+		Format:          c.GetFormatExtension(),
+		Lang:            c.GetLang().String(),
+		Service:         c.GetService(),
+		Ranking:         c.GetRanking(),
+		Info:            c.GetInfo(),
+		Confidence:      candidateDetails(c).Confidence,
+		Hash:            matchesHash(c),
+		HearingImpaired: hearingImpaired(c),
+		Path:            candidatePath(c),
+	}
+}
+
+// subtitleKey identifies a candidate of a search by what tells it apart from the others:
+// its service, language, format and release. The ranking is left out, since it changes
+// between searches (like the download count of a subtitle)
+func (s *CachedService) subtitleKey(f *FileTarget, lang language.Tag, data candidateData) string {
+	data.Ranking = 0
+	encoded, _ := json.Marshal(data)
+	return cacheKey(s.searchKey(f, lang), string(encoded))
+}
+
+// open downloads a candidate, caching its content
+func (s *CachedService) open(ctx context.Context, c SubtitleCandidate, key string) (io.ReadCloser, error) {
+	stream, err := OpenCandidate(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.saveSubtitle(key, data)
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// cachingCandidate is a candidate found by the service, whose content is cached when downloaded
+type cachingCandidate struct {
+	SubtitleCandidate
+	s   *CachedService
+	key string
+}
+
+// Unwrap returns the candidate found by the decorated service
+func (c *cachingCandidate) Unwrap() SubtitleCandidate {
+	return c.SubtitleCandidate
+}
+
+func (c *cachingCandidate) MatchesHash() bool     { return matchesHash(c.SubtitleCandidate) }
+func (c *cachingCandidate) HearingImpaired() bool { return hearingImpaired(c.SubtitleCandidate) }
+func (c *cachingCandidate) GetPath() string       { return candidatePath(c.SubtitleCandidate) }
+
+func (c *cachingCandidate) GetDetails() guessit.Details {
+	return candidateDetails(c.SubtitleCandidate)
+}
+
+func (c *cachingCandidate) Open() (io.ReadCloser, error) {
+	return c.OpenContext(context.Background())
+}
+
+func (c *cachingCandidate) OpenContext(ctx context.Context) (io.ReadCloser, error) {
+	return c.s.open(ctx, c.SubtitleCandidate, c.key)
+}
+
+// cachedCandidate is a candidate found in the cache. If its content is not
+// cached, the service is searched again to download it
+type cachedCandidate struct {
+	data candidateData
+	t    *FileTarget
+	lang language.Tag
+	s    *CachedService
+
+	once sync.Once
+	key  string
+}
+
+func (c *cachedCandidate) GetFormatExtension() string   { return c.data.Format }
+func (c *cachedCandidate) GetFileTarget() *FileTarget   { return c.t }
+func (c *cachedCandidate) GetLang() language.Tag        { return c.lang }
+func (c *cachedCandidate) GetService() string           { return c.data.Service }
+func (c *cachedCandidate) GetRanking() float32          { return c.data.Ranking }
+func (c *cachedCandidate) GetInfo() guessit.Information { return c.data.Info }
+func (c *cachedCandidate) MatchesHash() bool            { return c.data.Hash }
+func (c *cachedCandidate) HearingImpaired() bool        { return c.data.HearingImpaired }
+func (c *cachedCandidate) GetPath() string              { return c.data.Path }
+
+func (c *cachedCandidate) GetDetails() guessit.Details {
+	return guessit.Details{Information: c.data.Info, Confidence: c.data.Confidence}
+}
+
+func (c *cachedCandidate) subtitleKey() string {
+	c.once.Do(func() {
+		c.key = c.s.subtitleKey(c.t, c.lang, c.data)
+	})
+	return c.key
+}
+
+// cached returns wether the content of the candidate is cached
+func (c *cachedCandidate) cached() bool {
+	_, ok := c.s.cache.loadSubtitle(c.subtitleKey())
+	return ok
+}
+
+func (c *cachedCandidate) Open() (io.ReadCloser, error) {
+	return c.OpenContext(context.Background())
+}
+
+func (c *cachedCandidate) OpenContext(ctx context.Context) (io.ReadCloser, error) {
+	if data, ok := c.s.cache.loadSubtitle(c.subtitleKey()); ok {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	if c.s.cache.mode == CacheOnly {
+		return nil, ErrNotCached
+	}
+
+	// The candidate is found again, to be downloaded
+	var errs []error
+	results := c.s.ContextService.GetCandidates(ctx, []*FileTarget{c.t}, []language.Tag{c.lang})
+	for res := range results {
+		if res.Err != nil {
+			errs = append(errs, res.Err)
+			continue
+		}
+		if c.s.subtitleKey(c.t, c.lang, newCandidateData(res.Candidate)) == c.subtitleKey() {
+			// The channel must be consumed until it is closed
+			go func() {
+				for range results {
+				}
+			}()
+			return c.s.open(ctx, res.Candidate, c.subtitleKey())
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return nil, fmt.Errorf("the cached subtitle was not found again in %s", c.s.GetName())
+}
@@ -0,0 +1,198 @@
+package sublime
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"golang.org/x/text/language"
+)
+
+// countingService finds a subtitle for each file and language, counting its searches
+// and downloads. Files named "broken" fail, and rankings grow with every search
+type countingService struct {
+	lock      sync.Mutex
+	account   string
+	searches  int
+	downloads int
+}
+
+func (s *countingService) GetName() string   { return "counting" }
+func (s *countingService) Initialize() error { return nil }
+
+func (s *countingService) SetConfig(name, value string) error {
+	if name != "account" {
+		return errors.New("not found")
+	}
+	s.account = value
+	return nil
+}
+
+func (s *countingService) GetCandidatesForFiles(files []*FileTarget, langs []language.Tag) <-chan SubtitleCandidate {
+	return LegacyCandidates(s, files, langs)
+}
+
+func (s *countingService) GetCandidates(ctx context.Context, files []*FileTarget, langs []language.Tag) <-chan CandidateResult {
+	s.lock.Lock()
+	s.searches++
+	ranking := float32(s.searches * 10)
+	s.lock.Unlock()
+
+	var res []CandidateResult
+	for _, f := range files {
+		if f.GetName() == "broken" {
+			res = append(res, CandidateResult{Err: &ServiceError{Service: "counting", File: f, Err: errors.New("failed")}})
+			continue
+		}
+		for _, l := range langs {
+			res = append(res, CandidateResult{Candidate: countingCandidate{f, l, ranking, s}})
+		}
+	}
+
+	channel := make(chan CandidateResult, len(res))
+	for _, r := range res {
+		channel <- r
+	}
+	close(channel)
+	return channel
+}
+
+type countingCandidate struct {
+	t       *FileTarget
+	lang    language.Tag
+	ranking float32
+	s       *countingService
+}
+
+func (c countingCandidate) GetFormatExtension() string { return "srt" }
+func (c countingCandidate) GetFileTarget() *FileTarget { return c.t }
+func (c countingCandidate) GetLang() language.Tag      { return c.lang }
+func (c countingCandidate) GetService() string         { return "counting" }
+func (c countingCandidate) GetRanking() float32        { return c.ranking }
+func (c countingCandidate) MatchesHash() bool          { return true }
+func (c countingCandidate) HearingImpaired() bool      { return true }
+
+func (c countingCandidate) GetInfo() guessit.Information {
+	return c.t.GetInfo()
+}
+
+func (c countingCandidate) Open() (io.ReadCloser, error) {
+	c.s.lock.Lock()
+	c.s.downloads++
+	c.s.lock.Unlock()
+	return ioutil.NopCloser(strings.NewReader(c.t.GetName() + " " + c.lang.String())), nil
+}
+
+func searchCache(s *CachedService, files ...string) []SubtitleCandidate {
+	targets := make([]*FileTarget, len(files))
+	for i, f := range files {
+		targets[i] = NewFileTarget(f)
+	}
+
+	var res []SubtitleCandidate
+	for c := range s.GetCandidates(context.Background(), targets, []language.Tag{language.English, language.BrazilianPortuguese}) {
+		if c.Err == nil {
+			res = append(res, c.Candidate)
+		}
+	}
+	return res
+}
+
+func readCandidate(t *testing.T, c SubtitleCandidate) string {
+	stream, err := OpenCandidate(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	content, _ := ioutil.ReadAll(stream)
+	return string(content)
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "sublime-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &countingService{}
+	cache := NewCache(dir, time.Hour, CacheReadWrite)
+
+	// Both files are searched together
+	if res := searchCache(cache.Wrap(s), "Movie.2020.mkv", "broken"); len(res) != 2 || s.searches != 1 {
+		t.Fatalf(`Expected 2 candidates in 1 search, but got %d in %d`, len(res), s.searches)
+	}
+
+	// Only the file that failed is searched again
+	res := searchCache(cache.Wrap(s), "Movie.2020.mkv", "broken")
+	if len(res) != 2 || s.searches != 2 {
+		t.Fatalf(`Expected 2 candidates in 2 searches, but got %d in %d`, len(res), s.searches)
+	}
+	c := res[0]
+	if h, ok := c.(HashMatcher); !ok || !h.MatchesHash() || c.GetInfo().Year != 2020 || c.GetRanking() != 10 || c.GetLang() != language.English {
+		t.Errorf(`Expected the cached candidate to be kept, but got %+v`, c)
+	}
+	if h, ok := c.(HearingImpaired); !ok || !h.HearingImpaired() {
+		t.Errorf(`Expected the cached candidate to be for the hearing impaired`)
+	}
+
+	// Downloading a cached candidate searches for it again, once. It is
+	// found again even though its ranking changed since it was cached
+	for i := 0; i < 2; i++ {
+		if content := readCandidate(t, c); content != "Movie.2020.mkv en" || s.searches != 3 || s.downloads != 1 {
+			t.Errorf(`(case: download %d) Expected "Movie.2020.mkv en" in 3 searches and 1 download, but got "%s" in %d and %d`, i, content, s.searches, s.downloads)
+		}
+	}
+
+	// Offline, only downloaded subtitles are offered
+	offline := NewCache(dir, time.Hour, CacheOnly)
+	res = searchCache(offline.Wrap(s), "Movie.2020.mkv", "Other.mkv")
+	if len(res) != 1 || s.searches != 3 {
+		t.Fatalf(`Expected 1 candidate without searching, but got %d in %d searches`, len(res), s.searches)
+	}
+	if content := readCandidate(t, res[0]); content != "Movie.2020.mkv en" || s.downloads != 1 {
+		t.Errorf(`Expected the cached subtitle, but got "%s" in %d downloads`, content, s.downloads)
+	}
+
+	// Expired entries are searched again
+	time.Sleep(time.Millisecond)
+	expired := NewCache(dir, time.Millisecond, CacheReadWrite)
+	res = searchCache(expired.Wrap(s), "Movie.2020.mkv")
+	if len(res) != 2 || s.searches != 4 {
+		t.Errorf(`Expected 2 candidates in 4 searches, but got %d in %d`, len(res), s.searches)
+	}
+	if content := readCandidate(t, res[1]); content != "Movie.2020.mkv pt-BR" || s.downloads != 2 {
+		t.Errorf(`Expected the subtitle to be downloaded, but got "%s" in %d downloads`, content, s.downloads)
+	}
+
+	// Searches with other options are cached apart
+	for i := 0; i < 2; i++ {
+		other := cache.Wrap(s)
+		if err := other.SetConfig("account", "other"); err != nil {
+			t.Fatal(err)
+		}
+		if res := searchCache(other, "Movie.2020.mkv"); len(res) != 2 || s.searches != 5 {
+			t.Errorf(`(case: search %d) Expected 2 candidates in 5 searches, but got %d in %d`, i, len(res), s.searches)
+		}
+	}
+
+	// The options of the limiter don't change what is found
+	limited := cache.Wrap(Limit(s))
+	for _, option := range [][2]string{{"account", "other"}, {"rateLimit", "100"}, {"retries", "2"}} {
+		if err := limited.SetConfig(option[0], option[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if res := searchCache(limited, "Movie.2020.mkv"); len(res) != 2 || s.searches != 5 {
+		t.Errorf(`Expected 2 candidates in 5 searches, but got %d in %d`, len(res), s.searches)
+	}
+}
@@ -0,0 +1,35 @@
+package sublime
+
+import (
+	"bytes"
+
+	"github.com/PietroCarrara/sublime/pkg/subtitle"
+)
+
+// ConvertSubtitle converts UTF-8 subtitle data to the given format. The format is detected from
+// the contents, falling back to ext (the extension reported by the candidate).
+// Data already in the format is returned untouched
+func ConvertSubtitle(data []byte, ext string, format subtitle.Format) ([]byte, error) {
+	from, err := subtitle.Detect(data)
+	if err != nil {
+		from, err = subtitle.FormatFromExtension(ext)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if from == format {
+		return data, nil
+	}
+
+	sub, err := subtitle.ParseFormat(bytes.NewReader(data), from)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := subtitle.Write(&buf, sub, format); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,45 @@
+package sublime
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PietroCarrara/sublime/pkg/subtitle"
+)
+
+func TestConvertSubtitle(t *testing.T) {
+	t.Parallel()
+
+	ass := "[Script Info]\nScriptType: v4.00+\n\n[V4+ Styles]\nFormat: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\nStyle: Default,Arial,20,&H00FFFFFF,&H000000FF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,2,2,10,10,10,1\n\n[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\nDialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Hello\n"
+	ssa := "[Script Info]\nScriptType: v4.00\n\n[V4 Styles]\nFormat: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, TertiaryColour, BackColour, Bold, Italic, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, AlphaLevel, Encoding\nStyle: Default,Arial,20,16777215,65535,65535,0,0,0,1,2,2,2,10,10,10,0,1\n\n[Events]\nFormat: Marked, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\nDialogue: Marked=0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Hello\n"
+
+	cases := []struct {
+		data    string
+		format  subtitle.Format
+		present []string
+		absent  []string
+	}{
+		{ass, subtitle.SSA, []string{"ScriptType: v4.00\n", "[V4 Styles]", "Marked=0", "Hello"}, []string{"v4.00+", "[V4+ Styles]"}},
+		{ssa, subtitle.ASS, []string{"ScriptType: v4.00+\n", "[V4+ Styles]", "Dialogue: 0,", "Hello"}, []string{"[V4 Styles]", "Marked=0"}},
+		{ass, subtitle.ASS, []string{ass}, nil},
+		{ass, subtitle.SRT, []string{"00:00:01,000 --> 00:00:02,000", "Hello"}, []string{"[Events]"}},
+	}
+
+	for i, c := range cases {
+		data, err := ConvertSubtitle([]byte(c.data), "", c.format)
+		if err != nil {
+			t.Errorf(`(case: %d) %s`, i, err)
+			continue
+		}
+		for _, s := range c.present {
+			if !strings.Contains(string(data), s) {
+				t.Errorf(`(case: %d) Expected "%s" in %q`, i, s, data)
+			}
+		}
+		for _, s := range c.absent {
+			if strings.Contains(string(data), s) {
+				t.Errorf(`(case: %d) Expected no "%s" in %q`, i, s, data)
+			}
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package sublime
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+
+	"github.com/PietroCarrara/sublime/pkg/subtitle"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/language"
+)
+
+// NameFunc returns the path where the subtitle for
+// a file in a given language and format will be saved
+type NameFunc func(f *FileTarget, lang language.Tag, format string) string
+
+// SidecarName returns a NameFunc that saves subtitles next to the video file.
+// The languages are named after the names map, falling back to the tag itself
+func SidecarName(names map[language.Tag]string) NameFunc {
+	return func(f *FileTarget, lang language.Tag, format string) string {
+		name, ok := names[lang]
+		if !ok {
+			name = lang.String()
+		}
+		return f.SubtitlePath(name, format)
+	}
+}
+
+// DownloaderOptions configures a Downloader
+type DownloaderOptions struct {
+	Languages   []language.Tag // Languages to download subtitles for
+	Services    []Service      // Services to search. They will be initialized by the Downloader
+	Naming      NameFunc       // Where to save the subtitles. Defaults to SidecarName(nil)
+	Concurrency int            // Maximum number of simultaneous downloads. Defaults to 1
+	Scorer      Scorer         // How to choose between candidates. Defaults to NewDefaultScorer()
+
+	// Format all subtitles are converted to. "" keeps the format of each candidate
+	Format subtitle.Format
+	// Character encoding subtitles are saved in. Defaults to UTF-8
+	Encoding encoding.Encoding
+	// Save subtitles in the encoding they were downloaded in, ignoring Encoding
+	KeepEncoding bool
+	// Align the timings of the subtitles to the ones already next to the video, if there are any
+	Sync bool
+	// Download subtitles even for languages the videos already have, next to them or embedded
+	Force bool
+
+	// Called every time a new candidate is evaluated,
+	// with the number of candidates evaluated so far
+	Progress func(evaluated int)
+}
+
+// Downloader searches subtitles on multiple services,
+// picks the best ones and saves them
+type Downloader struct {
+	opts DownloaderOptions
+
+	initOnce sync.Once
+	services []ContextService
+	initErrs []error
+}
+
+// Result holds what happened to a FileTarget in a given language
+type Result struct {
+	File      *FileTarget
+	Lang      language.Tag
+	Candidate SubtitleCandidate   // Best candidate found. nil if none was found
+	Path      string              // Where the subtitle was saved. "" if it was not
+	Alignment *subtitle.Alignment // Timing correction applied to the subtitle. nil if it was not synced
+	Err       error               // Why the subtitle could not be saved. nil on success or if there was no candidate
+	Skipped   string              // Why no subtitle was searched for (SkipSidecar, SkipEmbedded or SkipHardcoded). "" if it was
+}
+
+// Ok returns wether a subtitle was saved
+func (r Result) Ok() bool {
+	return r.Path != "" && r.Err == nil
+}
+
+// Report is the outcome of a Downloader run
+type Report struct {
+	Results   []Result // A result for every file and language, in the order they were requested
+	Errors    []error  // Errors reported by the services
+	Evaluated int      // Number of candidates evaluated
+}
+
+// NewDownloader creates a new Downloader
+func NewDownloader(opts DownloaderOptions) *Downloader {
+	if opts.Naming == nil {
+		opts.Naming = SidecarName(nil)
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.Scorer == nil {
+		opts.Scorer = NewDefaultScorer()
+	}
+
+	return &Downloader{
+		opts: opts,
+	}
+}
+
+// Download searches, picks and saves the best subtitle for every target in every language.
+// Services are initialized in the first call. The returned error is only set when the
+// run couldn't happen at all; failures of single services or files are in the report
+func (d *Downloader) Download(ctx context.Context, targets []*FileTarget) (*Report, error) {
+	if len(d.opts.Languages) == 0 {
+		return nil, errors.New("no languages to download")
+	}
+
+	d.initOnce.Do(d.initialize)
+	if len(d.services) == 0 {
+		if len(d.initErrs) > 0 {
+			return &Report{Errors: d.initErrs}, errors.New("no service could be initialized")
+		}
+		return nil, errors.New("no services to search")
+	}
+
+	report := &Report{}
+	report.Errors = append(report.Errors, d.initErrs...)
+
+	skipped := make(map[*FileTarget]map[language.Tag]string)
+	search := targets
+	if !d.opts.Force {
+		search = nil
+		for _, f := range targets {
+			skipped[f] = d.existingSubtitles(f)
+			if len(skipped[f]) < len(d.opts.Languages) {
+				search = append(search, f)
+			}
+		}
+	}
+
+	best := make(map[*FileTarget]map[language.Tag]SubtitleCandidate)
+	if len(search) > 0 {
+		best = d.search(ctx, search, skipped, report)
+	}
+	report.Results = d.save(ctx, targets, best, skipped)
+
+	return report, nil
+}
+
+// initialize initializes every service, setting aside the ones that fail
+func (d *Downloader) initialize() {
+	for _, s := range d.opts.Services {
+		if err := s.Initialize(); err != nil {
+			d.initErrs = append(d.initErrs, &ServiceError{Service: s.GetName(), Err: err})
+			continue
+		}
+		d.services = append(d.services, AsContextService(s))
+	}
+}
+
+// search evaluates the candidates of every service and returns the best for each file and language.
+// Candidates for skipped languages are ignored
+func (d *Downloader) search(ctx context.Context, targets []*FileTarget, skipped map[*FileTarget]map[language.Tag]string, report *Report) map[*FileTarget]map[language.Tag]SubtitleCandidate {
+	chans := make([]<-chan CandidateResult, len(d.services))
+	for i, s := range d.services {
+		chans[i] = s.GetCandidates(ctx, targets, d.opts.Languages)
+	}
+
+	best := make(map[*FileTarget]map[language.Tag]SubtitleCandidate)
+	bestScore := make(map[*FileTarget]map[language.Tag]float64)
+	for res := range unifyChannels(chans) {
+		if res.Err != nil {
+			report.Errors = append(report.Errors, res.Err)
+			continue
+		}
+		sub := res.Candidate
+
+		report.Evaluated++
+		if d.opts.Progress != nil {
+			d.opts.Progress(report.Evaluated)
+		}
+
+		f := sub.GetFileTarget()
+		l := sub.GetLang()
+		if _, ok := skipped[f][l]; ok {
+			continue
+		}
+		if best[f] == nil {
+			best[f] = make(map[language.Tag]SubtitleCandidate)
+			bestScore[f] = make(map[language.Tag]float64)
+		}
+		score := d.opts.Scorer.Score(f.GetDetails(), sub)
+		if best[f][l] == nil || score > bestScore[f][l] {
+			best[f][l] = sub
+			bestScore[f][l] = score
+		}
+	}
+
+	return best
+}
+
+// save downloads the chosen candidates, returning a result for every file and language
+func (d *Downloader) save(ctx context.Context, targets []*FileTarget, best map[*FileTarget]map[language.Tag]SubtitleCandidate, skipped map[*FileTarget]map[language.Tag]string) []Result {
+	results := make([]Result, 0, len(targets)*len(d.opts.Languages))
+	for _, f := range targets {
+		for _, l := range d.opts.Languages {
+			results = append(results, Result{
+				File:      f,
+				Lang:      l,
+				Candidate: best[f][l],
+				Skipped:   skipped[f][l],
+			})
+		}
+	}
+
+	// References are looked for before anything is saved, since the
+	// subtitles saved by this run would be taken for one otherwise
+	paths := make([]string, len(results))
+	references := make([]*subtitle.Subtitle, len(results))
+	finder := newReferenceFinder()
+	for i, r := range results {
+		if r.Candidate == nil {
+			continue
+		}
+
+		format := r.Candidate.GetFormatExtension()
+		if d.opts.Format != "" {
+			format = string(d.opts.Format)
+		}
+		paths[i] = d.opts.Naming(r.File, r.Lang, format)
+		if d.opts.Sync {
+			references[i] = finder.find(r.File, paths[i])
+		}
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, d.opts.Concurrency)
+	for i := range results {
+		if results[i].Candidate == nil {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *Result, path string, reference *subtitle.Subtitle) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := d.saveCandidate(ctx, r, path, reference); err != nil {
+				r.Err = &ServiceError{Service: r.Candidate.GetService(), File: r.File, Err: err}
+				return
+			}
+			r.Path = path
+		}(&results[i], paths[i], references[i])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// saveCandidate downloads the candidate of a result into path, converting it if needed.
+// reference is the subtitle it is synced to, if syncing is enabled and there is one
+func (d *Downloader) saveCandidate(ctx context.Context, r *Result, path string, reference *subtitle.Subtitle) error {
+	sub := r.Candidate
+	stream, err := OpenCandidate(ctx, sub)
+	if err != nil {
+		return errors.Wrap(err, "could not download subtitle")
+	}
+	defer stream.Close()
+
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return errors.Wrap(err, "could not download subtitle")
+	}
+
+	data, err = d.process(data, r, reference)
+	if err != nil {
+		return err
+	}
+
+	return saveFile(path, bytes.NewReader(data))
+}
+
+// process transcodes, syncs and converts the downloaded data of a result's candidate
+func (d *Downloader) process(data []byte, r *Result, reference *subtitle.Subtitle) ([]byte, error) {
+	sub := r.Candidate
+	if d.opts.KeepEncoding && d.opts.Format == "" && reference == nil {
+		return data, nil
+	}
+
+	enc := subtitle.DetectEncoding(data, sub.GetLang())
+	data, err := subtitle.Decode(data, enc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decode subtitle from %s", subtitle.EncodingName(enc))
+	}
+
+	if reference != nil {
+		synced, alignment, err := SyncSubtitle(data, sub.GetFormatExtension(), reference)
+		// A subtitle that can't be synced is still saved
+		if err == nil {
+			data = synced
+			r.Alignment = &alignment
+		}
+	}
+
+	if d.opts.Format != "" {
+		data, err = ConvertSubtitle(data, sub.GetFormatExtension(), d.opts.Format)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not convert subtitle to %s", d.opts.Format)
+		}
+	}
+
+	target := d.opts.Encoding
+	if d.opts.KeepEncoding {
+		target = enc
+	}
+	if target == nil {
+		return data, nil
+	}
+
+	data, err = subtitle.Encode(data, target)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not encode subtitle to %s", subtitle.EncodingName(target))
+	}
+	return data, nil
+}
+
+// unifyChannels merges all channels into a single one,
+// which is closed once all of them are closed. nil channels are ignored
+func unifyChannels(channels []<-chan CandidateResult) <-chan CandidateResult {
+	res := make(chan CandidateResult)
+	var wg sync.WaitGroup
+
+	for _, c := range channels {
+		if c == nil {
+			continue
+		}
+		wg.Add(1)
+		c := c
+		go func() {
+			defer wg.Done()
+			for sub := range c {
+				res <- sub
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(res)
+	}()
+
+	return res
+}
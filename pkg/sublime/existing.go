@@ -0,0 +1,130 @@
+package sublime
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PietroCarrara/sublime/pkg/container"
+	"github.com/PietroCarrara/sublime/pkg/subtitle"
+	"golang.org/x/text/language"
+)
+
+// Reasons for a language to be skipped. See Result.Skipped
+const (
+	SkipSidecar   = "sidecar"   // There is a subtitle file next to the video
+	SkipEmbedded  = "embedded"  // The video has a subtitle track
+	SkipHardcoded = "hardcoded" // The release name says its subtitles are burnt into the picture ("VOSTFR")
+)
+
+// subtitleFormats are the formats looked for when checking if a subtitle was already saved
+var subtitleFormats = []subtitle.Format{subtitle.SRT, subtitle.ASS, subtitle.SSA, subtitle.VTT}
+
+// existingSubtitles returns, for each language of a file that already has
+// subtitles, why it doesn't need a new one
+func (d *Downloader) existingSubtitles(f *FileTarget) map[language.Tag]string {
+	res := make(map[language.Tag]string)
+
+	for _, l := range d.opts.Languages {
+		for _, format := range subtitleFormats {
+			if _, err := os.Stat(d.opts.Naming(f, l, string(format))); err == nil {
+				res[l] = SkipSidecar
+				break
+			}
+		}
+	}
+
+	for _, sidecar := range f.Sidecars() {
+		tag, ok := sidecarLanguage(f, sidecar)
+		if !ok {
+			continue
+		}
+		for _, l := range d.opts.Languages {
+			if _, ok := res[l]; !ok && CoversLanguage(tag, l) {
+				res[l] = SkipSidecar
+			}
+		}
+	}
+
+	if info := f.GetInfo(); info.Subbed {
+		for _, tag := range info.SubtitleLanguages {
+			for _, l := range d.opts.Languages {
+				if _, ok := res[l]; !ok && CoversLanguage(tag, l) {
+					res[l] = SkipHardcoded
+				}
+			}
+		}
+	}
+
+	if len(res) == len(d.opts.Languages) {
+		return res
+	}
+
+	// Files that are not Matroska or MP4 simply have no tracks
+	info, err := f.GetMedia()
+	if err != nil {
+		return res
+	}
+	for _, track := range info.TracksOf(container.SubtitleTrack) {
+		// Forced tracks only translate a few lines, so they don't count
+		if track.Forced || track.Language == language.Und {
+			continue
+		}
+		for _, l := range d.opts.Languages {
+			if _, ok := res[l]; !ok && CoversLanguage(track.Language, l) {
+				res[l] = SkipEmbedded
+			}
+		}
+	}
+
+	return res
+}
+
+// sidecarLanguage reads the language of a sidecar named like "<video name>.<lang>[.forced].<format>".
+// Sidecars without a language, or with only forced subtitles, are ignored
+func sidecarLanguage(f *FileTarget, sidecar string) (language.Tag, bool) {
+	base := strings.TrimSuffix(f.GetPath(), filepath.Ext(f.GetPath()))
+	name := strings.TrimPrefix(strings.TrimSuffix(sidecar, filepath.Ext(sidecar)), base+".")
+
+	for _, part := range strings.Split(name, ".") {
+		if strings.EqualFold(part, "forced") {
+			return language.Und, false
+		}
+	}
+
+	tag, err := language.Parse(strings.Split(name, ".")[0])
+	if err != nil || tag == language.Und {
+		return language.Und, false
+	}
+	return tag, true
+}
+
+// CoversLanguage returns wether a subtitle in have serves someone who wants want.
+// Regions only have to match when both tags name them explicitly. Services use
+// it to map the languages they find to the requested ones
+func CoversLanguage(have, want language.Tag) bool {
+	if have == want {
+		return true
+	}
+
+	haveBase, _ := have.Base()
+	wantBase, _ := want.Base()
+	if haveBase != wantBase {
+		return false
+	}
+
+	haveRegion, haveConf := have.Region()
+	wantRegion, wantConf := want.Region()
+	return haveConf != language.Exact || wantConf != language.Exact || haveRegion == wantRegion
+}
+
+// RequestedLanguage returns the first of the requested languages served by a
+// subtitle in have. See CoversLanguage
+func RequestedLanguage(have language.Tag, langs []language.Tag) (language.Tag, bool) {
+	for _, l := range langs {
+		if CoversLanguage(have, l) {
+			return l, true
+		}
+	}
+	return language.Und, false
+}
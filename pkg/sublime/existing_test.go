@@ -0,0 +1,106 @@
+package sublime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestCoversLanguage(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		have, want string
+		target     bool
+	}{
+		{"pt-BR", "pt-BR", true},
+		{"pt", "pt-BR", true},
+		{"pt-BR", "pt", true},
+		{"pt-PT", "pt-BR", false},
+		{"en", "pt-BR", false},
+		{"eng", "en-US", true},
+	}
+
+	for _, c := range cases {
+		value := CoversLanguage(language.MustParse(c.have), language.MustParse(c.want))
+		if value != c.target {
+			t.Errorf(`(case: "%s" -> "%s") Expected %v, but got %v`, c.have, c.want, c.target, value)
+		}
+	}
+}
+
+func TestRequestedLanguage(t *testing.T) {
+	t.Parallel()
+
+	langs := []language.Tag{language.BrazilianPortuguese, language.English}
+	cases := map[string]string{
+		"pt":    "pt-BR",
+		"pt-BR": "pt-BR",
+		"en-US": "en",
+		"pt-PT": "",
+		"es":    "",
+	}
+
+	for have, target := range cases {
+		value, ok := RequestedLanguage(language.MustParse(have), langs)
+		if target == "" && ok {
+			t.Errorf(`(case: "%s") Expected no language, but got "%s"`, have, value)
+		} else if target != "" && (!ok || value.String() != target) {
+			t.Errorf(`(case: "%s") Expected "%s", but got "%s"`, have, target, value)
+		}
+	}
+}
+
+func TestSidecarLanguage(t *testing.T) {
+	t.Parallel()
+
+	f := NewFileTarget("/videos/Movie.2020.1080p.mkv")
+	cases := map[string]string{
+		"/videos/Movie.2020.1080p.pt-BR.srt":        "pt-BR",
+		"/videos/Movie.2020.1080p.en.forced.srt":    "",
+		"/videos/Movie.2020.1080p.srt":              "",
+		"/videos/Movie.2020.1080p.por.ass":          "pt",
+		"/videos/Movie.2020.1080p.fre.hi.vtt":       "fr",
+		"/videos/Movie.2020.1080p.director-cut.srt": "",
+	}
+
+	for sidecar, target := range cases {
+		value, ok := sidecarLanguage(f, sidecar)
+		if !ok {
+			value = language.Und
+		}
+		if (target == "" && ok) || (target != "" && value != language.MustParse(target)) {
+			t.Errorf(`(case: "%s") Expected language to be "%s", but got "%s"`, sidecar, target, value)
+		}
+	}
+}
+
+func TestExistingSubtitlesHardcoded(t *testing.T) {
+	t.Parallel()
+
+	d := NewDownloader(DownloaderOptions{
+		Languages: []language.Tag{language.French, language.BrazilianPortuguese},
+	})
+	cases := map[string]map[language.Tag]string{
+		"Le.Bureau.des.Legendes.S01E01.VOSTFR.720p.HDTV.x264-GRP.mkv": {language.French: SkipHardcoded},
+		"Druk.2020.NORDiC.1080p.BluRay.x264-GRP.mkv":                  {},
+		"Amelie.2001.FRENCH.1080p.BluRay.x264-GRP.mkv":                {},
+	}
+
+	dir, err := ioutil.TempDir("", "sublime-existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, target := range cases {
+		value := d.existingSubtitles(NewFileTarget(filepath.Join(dir, name)))
+		if !reflect.DeepEqual(value, target) {
+			t.Errorf(`(case: "%s") Expected %v, but got %v`, name, target, value)
+		}
+	}
+}
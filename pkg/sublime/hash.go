@@ -0,0 +1,41 @@
+package sublime
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// movieHashChunkSize is the size of the head and tail blocks used by MovieHash
+const movieHashChunkSize = 64 * 1024
+
+// ErrFileTooSmall is returned when a file is too small to be hashed
+var ErrFileTooSmall = errors.New("file is too small to be hashed")
+
+// MovieHash computes the OpenSubtitles hash of a file with the given size:
+// the size plus the sum of the first and last 64KiB, read as little-endian uint64s.
+// See https://trac.opensubtitles.org/projects/opensubtitles/wiki/HashSourceCodes
+func MovieHash(r io.ReaderAt, size int64) (uint64, error) {
+	if size < movieHashChunkSize {
+		return 0, ErrFileTooSmall
+	}
+
+	hash := uint64(size)
+	buf := make([]byte, movieHashChunkSize)
+	for _, offset := range []int64{0, size - movieHashChunkSize} {
+		if _, err := r.ReadAt(buf, offset); err != nil {
+			return 0, err
+		}
+		for i := 0; i < len(buf); i += 8 {
+			hash += binary.LittleEndian.Uint64(buf[i:])
+		}
+	}
+
+	return hash, nil
+}
+
+// FormatMovieHash returns the hash in the format used by OpenSubtitles
+func FormatMovieHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
@@ -0,0 +1,49 @@
+package sublime
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/oz/osdb"
+)
+
+func TestMovieHash(t *testing.T) {
+	t.Parallel()
+
+	sizes := []int{movieHashChunkSize, movieHashChunkSize*2 + 13, 1024 * 1024}
+	for _, size := range sizes {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+
+		file, err := ioutil.TempFile("", "sublime-hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+		if _, err := file.Write(data); err != nil {
+			t.Fatal(err)
+		}
+
+		target, err := osdb.HashFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value, err := MovieHash(bytes.NewReader(data), int64(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if value != target {
+			t.Errorf(`(size: %d) Expected hash to be %s, but got %s`, size, FormatMovieHash(target), FormatMovieHash(value))
+		}
+	}
+
+	if _, err := MovieHash(bytes.NewReader(nil), 10); err != ErrFileTooSmall {
+		t.Errorf(`Expected ErrFileTooSmall, but got %v`, err)
+	}
+}
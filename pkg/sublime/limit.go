@@ -0,0 +1,457 @@
+package sublime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"golang.org/x/text/language"
+)
+
+// maxRetryDelay is the longest a retry waits, unless the service asks for more
+const maxRetryDelay = time.Minute
+
+// TemporaryError describes a failure that may not happen if the request is
+// made again later, like a rate limit or a server that is unavailable
+type TemporaryError struct {
+	Err        error
+	RetryAfter time.Duration // How long the service asked to wait. 0 if it didn't say
+}
+
+func (e *TemporaryError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *TemporaryError) Unwrap() error {
+	return e.Err
+}
+
+// ResponseError describes a failed HTTP response, like "download failed: 404 Not Found".
+// See TemporaryStatus
+func ResponseError(res *http.Response, action string) error {
+	return TemporaryStatus(res, fmt.Errorf("%s: %s", action, res.Status))
+}
+
+// TemporaryStatus returns err as a TemporaryError if the response is a rate limit
+// or a server error, waiting for as long as its Retry-After header asks
+func TemporaryStatus(res *http.Response, err error) error {
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode < 500 {
+		return err
+	}
+	return &TemporaryError{Err: err, RetryAfter: retryAfter(res.Header.Get("Retry-After"))}
+}
+
+// retryAfter reads a Retry-After header, which is either a number of seconds or a date
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(time.Now()) {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// temporary returns wether an error may not happen again, and how long to wait before trying
+func temporary(err error) (time.Duration, bool) {
+	var tempErr *TemporaryError
+	if errors.As(err, &tempErr) {
+		return tempErr.RetryAfter, true
+	}
+	var netErr net.Error
+	return 0, errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// LimitedService decorates a Service, limiting how often it searches and downloads, and
+// retrying the ones that fail with temporary errors. It has no limits until they are set
+// with SetConfig; other options are passed to the service:
+//   - rateLimit: searches and downloads allowed in a period, like "2" (per second) or "40/10s"
+//   - burst: how many of them may be made at once, after a quiet period. Defaults to 1
+//   - retries: how many times a failure is retried
+//   - retryDelay: how long the first retry waits, doubled (with jitter) for the following ones. Defaults to 1s
+type LimitedService struct {
+	ContextService
+	service Service
+
+	retries    int
+	retryDelay time.Duration
+	bucket     *tokenBucket
+}
+
+// Limit decorates a service. See LimitedService
+func Limit(s Service) *LimitedService {
+	return &LimitedService{
+		ContextService: AsContextService(s),
+		service:        s,
+		retryDelay:     time.Second,
+		bucket:         &tokenBucket{burst: 1},
+	}
+}
+
+// Unwrap returns the decorated service
+func (l *LimitedService) Unwrap() Service {
+	return l.service
+}
+
+func (l *LimitedService) SetConfig(name, value string) error {
+	switch name {
+	case "rateLimit":
+		rate, err := parseRate(value)
+		if err != nil {
+			return err
+		}
+		l.bucket.rate = rate
+	case "burst":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf(`invalid value "%s" for option "burst"`, value)
+		}
+		l.bucket.burst = float64(n)
+	case "retries":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf(`invalid value "%s" for option "retries"`, value)
+		}
+		l.retries = n
+	case "retryDelay":
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf(`invalid value "%s" for option "retryDelay"`, value)
+		}
+		l.retryDelay = d
+	default:
+		return l.ContextService.SetConfig(name, value)
+	}
+
+	return nil
+}
+
+// limitOptions are the options a LimitedService takes for itself. They
+// change how often the service is searched, but not what it finds
+var limitOptions = map[string]bool{"rateLimit": true, "burst": true, "retries": true, "retryDelay": true}
+
+// limitsOption returns wether an option is taken by a LimitedService
+// that decorates the service, instead of reaching the service
+func limitsOption(s Service, name string) bool {
+	for s != nil {
+		if _, ok := s.(*LimitedService); ok && limitOptions[name] {
+			return true
+		}
+		d, ok := s.(interface{ Unwrap() Service })
+		if !ok {
+			return false
+		}
+		s = d.Unwrap()
+	}
+	return false
+}
+
+// parseRate reads a rate like "2" (per second) or "40/10s", in requests per second
+func parseRate(value string) (float64, error) {
+	parts := strings.SplitN(value, "/", 2)
+	n, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf(`invalid value "%s" for option "rateLimit"`, value)
+	}
+	if len(parts) == 1 {
+		return n, nil
+	}
+
+	// Periods of a single unit, like "/m", have no number
+	period := parts[1]
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf(`invalid value "%s" for option "rateLimit"`, value)
+	}
+	return n / d.Seconds(), nil
+}
+
+// limited returns wether any limit was set
+func (l *LimitedService) limited() bool {
+	return l.bucket.rate > 0 || l.retries > 0
+}
+
+func (l *LimitedService) GetCandidatesForFiles(files []*FileTarget, langs []language.Tag) <-chan SubtitleCandidate {
+	return LegacyCandidates(l, files, langs)
+}
+
+// GetCandidates searches all the files at once, as the service would, so that services
+// that search several files together (like a season page) still do. Every request of the
+// search takes from the rate limit, as long as the service calls WaitRequest before them.
+// The files that failed with temporary errors are searched again, together, unless they
+// found candidates before failing, so that they are not offered twice
+func (l *LimitedService) GetCandidates(ctx context.Context, files []*FileTarget, langs []language.Tag) <-chan CandidateResult {
+	if !l.limited() {
+		return l.ContextService.GetCandidates(ctx, files, langs)
+	}
+
+	channel := make(chan CandidateResult)
+
+	go func() {
+		defer close(channel)
+
+		pending := files
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if err := l.bucket.wait(ctx); err != nil {
+				channel <- CandidateResult{Err: &ServiceError{Service: l.GetName(), Err: err}}
+				return
+			}
+
+			found := make(map[*FileTarget]bool)
+			retry := make(map[*FileTarget]error)
+			var retryAll error // A failure of the whole search
+			for res := range l.ContextService.GetCandidates(l.gate(ctx), pending, langs) {
+				if res.Err != nil {
+					if attempt < l.retries && ctx.Err() == nil {
+						if _, ok := temporary(res.Err); ok {
+							var serviceErr *ServiceError
+							switch {
+							case errors.As(res.Err, &serviceErr) && serviceErr.File != nil && retry[serviceErr.File] == nil:
+								retry[serviceErr.File] = res.Err
+								continue
+							case (!errors.As(res.Err, &serviceErr) || serviceErr.File == nil) && retryAll == nil:
+								retryAll = res.Err
+								continue
+							}
+						}
+					}
+					channel <- res
+					continue
+				}
+
+				found[res.Candidate.GetFileTarget()] = true
+				channel <- CandidateResult{Candidate: limitedCandidate{res.Candidate, l}}
+			}
+
+			// The errors of files that found candidates anyway are not retried
+			var next []*FileTarget
+			var first error
+			for _, f := range pending {
+				err := retry[f]
+				if err == nil {
+					err = retryAll
+				}
+				if err == nil {
+					continue
+				}
+				if found[f] {
+					if retry[f] != nil {
+						channel <- CandidateResult{Err: retry[f]}
+					}
+					continue
+				}
+				if first == nil {
+					first = err
+				}
+				next = append(next, f)
+			}
+			if retryAll != nil && len(next) == 0 {
+				channel <- CandidateResult{Err: retryAll}
+			}
+			if len(next) == 0 {
+				return
+			}
+
+			if err := l.pause(ctx, first, attempt); err != nil {
+				channel <- CandidateResult{Err: &ServiceError{Service: l.GetName(), Err: err}}
+				return
+			}
+			pending = next
+		}
+	}()
+
+	return channel
+}
+
+// pause waits before the next attempt of a request that failed with a temporary error.
+// When the service asks for a delay, every request to it waits for it
+func (l *LimitedService) pause(ctx context.Context, err error, attempt int) error {
+	after, _ := temporary(err)
+	if after > 0 {
+		l.bucket.block(after)
+		return nil
+	}
+
+	delay := l.retryDelay
+	for i := 0; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	// Jitter keeps concurrent retries from happening at the same time
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// open downloads a candidate, under the same limits as the searches
+func (l *LimitedService) open(ctx context.Context, c SubtitleCandidate) (io.ReadCloser, error) {
+	for attempt := 0; ; attempt++ {
+		if err := l.bucket.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		stream, err := OpenCandidate(l.gate(ctx), c)
+		if err == nil {
+			return stream, nil
+		}
+		if _, ok := temporary(err); !ok || attempt >= l.retries || ctx.Err() != nil {
+			return nil, err
+		}
+		if err := l.pause(ctx, err, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// requestGate lets the requests of a search or a download through as the rate limit allows
+type requestGate struct {
+	bucket *tokenBucket
+
+	lock sync.Mutex
+	paid bool // Wether the next request was paid for before the search started
+}
+
+type requestGateKey struct{}
+
+// gate returns a context for a search or download that has just taken from
+// the rate limit, which pays for its first request. See WaitRequest
+func (l *LimitedService) gate(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestGateKey{}, &requestGate{bucket: l.bucket, paid: true})
+}
+
+// WaitRequest waits until the rate limit of a LimitedService allows another request. Services
+// that make several requests in a search or a download (like one for each file) call it
+// before each of them, so that they are not made back to back. Searches and downloads that
+// are not limited return right away
+func WaitRequest(ctx context.Context) error {
+	gate, ok := ctx.Value(requestGateKey{}).(*requestGate)
+	if !ok {
+		return nil
+	}
+
+	gate.lock.Lock()
+	paid := gate.paid
+	gate.paid = false
+	gate.lock.Unlock()
+	if paid {
+		return nil
+	}
+	return gate.bucket.wait(ctx)
+}
+
+// limitedCandidate is a candidate of a LimitedService
+type limitedCandidate struct {
+	SubtitleCandidate
+	l *LimitedService
+}
+
+// Unwrap returns the candidate found by the decorated service
+func (c limitedCandidate) Unwrap() SubtitleCandidate {
+	return c.SubtitleCandidate
+}
+
+func (c limitedCandidate) MatchesHash() bool     { return matchesHash(c.SubtitleCandidate) }
+func (c limitedCandidate) HearingImpaired() bool { return hearingImpaired(c.SubtitleCandidate) }
+func (c limitedCandidate) GetPath() string       { return candidatePath(c.SubtitleCandidate) }
+
+func (c limitedCandidate) GetDetails() guessit.Details {
+	return candidateDetails(c.SubtitleCandidate)
+}
+
+func (c limitedCandidate) Open() (io.ReadCloser, error) {
+	return c.OpenContext(context.Background())
+}
+
+func (c limitedCandidate) OpenContext(ctx context.Context) (io.ReadCloser, error) {
+	return c.l.open(ctx, c.SubtitleCandidate)
+}
+
+// tokenBucket allows rate requests per second, up to burst at once
+type tokenBucket struct {
+	lock    sync.Mutex
+	rate    float64 // 0 for no limit
+	burst   float64
+	tokens  float64
+	last    time.Time
+	blocked time.Time // No requests are allowed before it
+}
+
+// wait blocks until a request is allowed
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		delay := b.take()
+		if delay == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// take takes a token, or returns how long until there is one
+func (b *tokenBucket) take() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	if now.Before(b.blocked) {
+		return b.blocked.Sub(now)
+	}
+	if b.rate <= 0 {
+		return 0
+	}
+
+	// The bucket starts full
+	if b.last.IsZero() {
+		b.tokens = b.burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// block refuses every request for a while
+func (b *tokenBucket) block(d time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if until := time.Now().Add(d); until.After(b.blocked) {
+		b.blocked = until
+	}
+}
@@ -0,0 +1,234 @@
+package sublime
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// flakyService fails the first searches of each file, and the first downloads, with temporary errors
+type flakyService struct {
+	failures   int
+	retryAfter time.Duration
+
+	lock     sync.Mutex
+	calls    int // Searches of the service, each of them for several files
+	searches map[string]int
+	opens    int
+}
+
+func (s *flakyService) GetName() string                    { return "flaky" }
+func (s *flakyService) SetConfig(name, value string) error { return errors.New("not found") }
+func (s *flakyService) Initialize() error                  { return nil }
+
+func (s *flakyService) GetCandidatesForFiles(files []*FileTarget, langs []language.Tag) <-chan SubtitleCandidate {
+	return LegacyCandidates(s, files, langs)
+}
+
+func (s *flakyService) GetCandidates(ctx context.Context, files []*FileTarget, langs []language.Tag) <-chan CandidateResult {
+	channel := make(chan CandidateResult, len(files))
+	s.lock.Lock()
+	s.calls++
+	s.lock.Unlock()
+	for _, f := range files {
+		if err := WaitRequest(ctx); err != nil {
+			channel <- CandidateResult{Err: &ServiceError{Service: "flaky", File: f, Err: err}}
+			continue
+		}
+
+		s.lock.Lock()
+		s.searches[f.GetPath()]++
+		failed := s.searches[f.GetPath()] <= s.failures
+		s.lock.Unlock()
+
+		if failed {
+			err := &TemporaryError{Err: errors.New("429 Too Many Requests"), RetryAfter: s.retryAfter}
+			channel <- CandidateResult{Err: &ServiceError{Service: "flaky", File: f, Err: err}}
+		} else {
+			channel <- CandidateResult{Candidate: flakyCandidate{fakeCandidate{name: f.GetPath(), hash: true, hi: true}, s}}
+		}
+	}
+	close(channel)
+	return channel
+}
+
+type flakyCandidate struct {
+	fakeCandidate
+	s *flakyService
+}
+
+func (c flakyCandidate) Open() (io.ReadCloser, error) {
+	c.s.lock.Lock()
+	defer c.s.lock.Unlock()
+
+	c.s.opens++
+	if c.s.opens <= c.s.failures {
+		return nil, &TemporaryError{Err: errors.New("503 Service Unavailable")}
+	}
+	return ioutil.NopCloser(strings.NewReader(c.name)), nil
+}
+
+func search(l *LimitedService, files ...string) ([]SubtitleCandidate, []error) {
+	targets := make([]*FileTarget, len(files))
+	for i, f := range files {
+		targets[i] = NewFileTarget(f)
+	}
+
+	var res []SubtitleCandidate
+	var errs []error
+	for c := range l.GetCandidates(context.Background(), targets, []language.Tag{language.English}) {
+		if c.Err != nil {
+			errs = append(errs, c.Err)
+			continue
+		}
+		res = append(res, c.Candidate)
+	}
+	return res, errs
+}
+
+func TestLimitedServiceRetries(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		failures   int
+		retries    string
+		candidates int
+		searches   int
+		calls      int // The files are searched together, in every attempt
+	}{
+		{0, "2", 2, 2, 1},
+		{2, "2", 2, 6, 3},
+		{3, "2", 0, 6, 3},
+		{1, "0", 0, 2, 1},
+	}
+
+	for _, c := range cases {
+		s := &flakyService{failures: c.failures, searches: make(map[string]int)}
+		l := Limit(s)
+		l.SetConfig("retryDelay", "1ms")
+		if err := l.SetConfig("retries", c.retries); err != nil {
+			t.Fatal(err)
+		}
+
+		res, errs := search(l, "a.mkv", "b.mkv")
+		searches := s.searches["a.mkv"] + s.searches["b.mkv"]
+		if len(res) != c.candidates || len(errs) != 2-c.candidates || searches != c.searches || s.calls != c.calls {
+			t.Errorf(`(case: %d failures, %s retries) Expected %d candidates in %d searches (%d calls), but got %d in %d (%d calls, errors: %v)`, c.failures, c.retries, c.candidates, c.searches, c.calls, len(res), searches, s.calls, errs)
+		}
+	}
+}
+
+func TestLimitedServiceOpen(t *testing.T) {
+	t.Parallel()
+
+	s := &flakyService{searches: make(map[string]int)}
+	l := Limit(s)
+	l.SetConfig("retries", "1")
+	l.SetConfig("retryDelay", "1ms")
+
+	res, _ := search(l, "a.mkv")
+	if len(res) != 1 {
+		t.Fatalf(`Expected 1 candidate, but got %d`, len(res))
+	}
+	// Decorated candidates still tell how they were found
+	if h, ok := res[0].(HashMatcher); !ok || !h.MatchesHash() {
+		t.Errorf(`Expected the candidate to match the hash`)
+	}
+	if h, ok := res[0].(HearingImpaired); !ok || !h.HearingImpaired() {
+		t.Errorf(`Expected the candidate to be for the hearing impaired`)
+	}
+
+	s.failures = 1
+	stream, err := OpenCandidate(context.Background(), res[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(stream)
+	if string(content) != "a.mkv" || s.opens != 2 {
+		t.Errorf(`Expected "a.mkv" after 2 attempts, but got "%s" after %d`, content, s.opens)
+	}
+
+	s.failures, s.opens = 2, 0
+	if _, err := res[0].Open(); err == nil || s.opens != 2 {
+		t.Errorf(`Expected the download to fail after 2 attempts, but got %v after %d`, err, s.opens)
+	}
+}
+
+func TestLimitedServiceRate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		rate    string
+		retries string
+		retry   time.Duration
+		minimum time.Duration
+		calls   int
+	}{
+		// The first file is in the burst, the other 4 wait 10ms each
+		{"100", "0", 0, 40 * time.Millisecond, 1},
+		{"1/10ms", "0", 0, 40 * time.Millisecond, 1},
+		// Every file fails once, and the service asks to wait 20ms before searching them again
+		{"1000", "1", 20 * time.Millisecond, 20 * time.Millisecond, 2},
+	}
+
+	for _, c := range cases {
+		s := &flakyService{searches: make(map[string]int), retryAfter: c.retry}
+		if c.retry > 0 {
+			s.failures = 1
+		}
+		l := Limit(s)
+		if err := l.SetConfig("rateLimit", c.rate); err != nil {
+			t.Fatal(err)
+		}
+		l.SetConfig("retries", c.retries)
+		// The delay asked by the service is used instead
+		l.SetConfig("retryDelay", "1h")
+
+		// The files are searched together, but each of them takes from the rate limit
+		start := time.Now()
+		res, errs := search(l, "a.mkv", "b.mkv", "c.mkv", "d.mkv", "e.mkv")
+		if elapsed := time.Since(start); elapsed < c.minimum || len(res) != 5 || s.calls != c.calls {
+			t.Errorf(`(case: "%s") Expected 5 candidates in %d calls and at least %s, but got %d in %d and %s (errors: %v)`, c.rate, c.calls, c.minimum, len(res), s.calls, elapsed, errs)
+		}
+	}
+
+	for _, rate := range []string{"0", "x", "2/", "2/0s"} {
+		if err := Limit(&flakyService{}).SetConfig("rateLimit", rate); err == nil {
+			t.Errorf(`(case: "%s") Expected an invalid rate`, rate)
+		}
+	}
+}
+
+func TestTemporaryStatus(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		status     int
+		retryAfter string
+		temporary  bool
+		delay      time.Duration
+	}{
+		{http.StatusNotFound, "", false, 0},
+		{http.StatusTooManyRequests, "", true, 0},
+		{http.StatusTooManyRequests, "120", true, 2 * time.Minute},
+		{http.StatusServiceUnavailable, "invalid", true, 0},
+	}
+
+	for _, c := range cases {
+		res := &http.Response{StatusCode: c.status, Status: http.StatusText(c.status), Header: http.Header{}}
+		res.Header.Set("Retry-After", c.retryAfter)
+
+		delay, ok := temporary(ResponseError(res, "request failed"))
+		if ok != c.temporary || delay != c.delay {
+			t.Errorf(`(case: %d "%s") Expected %v and %s, but got %v and %s`, c.status, c.retryAfter, c.temporary, c.delay, ok, delay)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package sublime
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"github.com/agnivade/levenshtein"
+)
+
+// Scorer rates how well a subtitle candidate matches the file it targets
+type Scorer interface {
+	// Score returns a number that grows as the candidate gets closer to the target
+	Score(target guessit.Details, sub SubtitleCandidate) float64
+}
+
+// Score components known by the DefaultScorer
+const (
+	ScoreHash         = "hash"         // Candidate was found by the file's hash
+	ScoreRelease      = "release"      // Release type (BluRay, WEB, HDTV...), or streaming service of WEB releases (NF, AMZN...)
+	ScoreExtended     = "extended"     // Extended cut
+	ScoreTheatrical   = "theatrical"   // Theatrical cut
+	ScoreDirectorsCut = "directorscut" // Director's cut
+	ScoreRemastered   = "remastered"   // Remastered version
+	ScoreResolution   = "resolution"   // Video resolution
+	ScoreGroup        = "group"        // Release group
+	ScoreVideoCodec   = "videocodec"   // Video codec
+	ScoreAudioCodec   = "audiocodec"   // Audio codec
+	ScoreYear         = "year"         // Release year
+	ScoreSeason       = "season"       // Season number
+	ScoreEpisode      = "episode"      // Episode number, or air date of daily shows
+	ScoreTitle        = "title"        // Similarity between the titles
+	ScoreRanking      = "ranking"      // Ranking given by the service
+)
+
+// Weights maps a score component to how much it is worth
+type Weights map[string]float64
+
+// DefaultWeights are the weights used by NewDefaultScorer. A subtitle matched by hash was
+// made for the very file, so the hash is worth more than all of the others together
+var DefaultWeights = Weights{
+	ScoreHash:         150,
+	ScoreRelease:      30,
+	ScoreEpisode:      25,
+	ScoreGroup:        20,
+	ScoreExtended:     15,
+	ScoreSeason:       10,
+	ScoreTheatrical:   8,
+	ScoreDirectorsCut: 8,
+	ScoreYear:         6,
+	ScoreRemastered:   4,
+	ScoreResolution:   4,
+	ScoreTitle:        3,
+	ScoreVideoCodec:   2,
+	ScoreRanking:      2,
+	ScoreAudioCodec:   1,
+}
+
+// scoreComponents computes, for each component, how much a
+// candidate matches the target, from 0 (not at all) to 1 (fully)
+var scoreComponents = map[string]func(target, info guessit.Information, sub SubtitleCandidate) float64{
+	ScoreHash: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		h, ok := sub.(HashMatcher)
+		return matchIf(ok && h.MatchesHash())
+	},
+	ScoreRelease: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		// WEB releases of a streaming service share its cut, which other services
+		// may not have. A WEB release of an unknown service may still be the same
+		if target.StreamingSource != "" {
+			switch {
+			case info.StreamingSource != "":
+				return matchIf(target.StreamingSource == info.StreamingSource)
+			case parseRelease(info.Release) == web:
+				return 0.5
+			}
+		}
+		t := parseRelease(target.Release)
+		return matchIf(t != unknown && t == parseRelease(info.Release))
+	},
+	ScoreExtended: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		return matchIf(target.Extended == info.Extended)
+	},
+	ScoreTheatrical: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		return matchIf(target.Theatrical == info.Theatrical)
+	},
+	ScoreDirectorsCut: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		return matchIf(target.DirectorsCut == info.DirectorsCut)
+	},
+	ScoreRemastered: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		return matchIf(target.Remastered == info.Remastered)
+	},
+	ScoreResolution: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		return matchString(target.Resolution, info.Resolution)
+	},
+	ScoreGroup: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		return matchString(target.Group, info.Group)
+	},
+	ScoreVideoCodec: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		return matchString(normalizeCodec(target.VideoCodec), normalizeCodec(info.VideoCodec))
+	},
+	ScoreAudioCodec: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		return matchString(target.AudioCodec, info.AudioCodec)
+	},
+	ScoreYear: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		return matchIf(target.Year != 0 && target.Year == info.Year)
+	},
+	ScoreSeason: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		return matchIf(target.Season != 0 && target.Season == info.Season)
+	},
+	ScoreEpisode: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		if !target.AirDate.IsZero() {
+			return matchIf(target.AirDate.Equal(info.AirDate))
+		}
+		// Subtitles of multi-episode media only sync with the same episodes
+		return matchIf(target.Episode != 0 && target.SameEpisodes(info))
+	},
+	ScoreTitle: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		a := strings.ToLower(target.Title)
+		b := strings.ToLower(info.Title)
+		longest := math.Max(float64(len(a)), float64(len(b)))
+		if longest == 0 {
+			return 0
+		}
+		return 1 - float64(levenshtein.ComputeDistance(a, b))/longest
+	},
+	ScoreRanking: func(target, info guessit.Information, sub SubtitleCandidate) float64 {
+		// Rankings have no upper bound, so they are compressed in a logarithmic
+		// scale that reaches 1 at 100000 (downloads, for example)
+		r := float64(sub.GetRanking())
+		if r <= 0 {
+			return 0
+		}
+		return math.Min(1, math.Log10(1+r)/5)
+	},
+}
+
+// DefaultScorer scores candidates as a weighted sum of how much each
+// of their attributes matches the target's
+type DefaultScorer struct {
+	Weights Weights
+}
+
+// NewDefaultScorer creates a DefaultScorer using a copy of DefaultWeights
+func NewDefaultScorer() *DefaultScorer {
+	weights := make(Weights, len(DefaultWeights))
+	for k, v := range DefaultWeights {
+		weights[k] = v
+	}
+
+	return &DefaultScorer{
+		Weights: weights,
+	}
+}
+
+// Score returns the weighted sum of the candidate's components
+func (s *DefaultScorer) Score(target guessit.Details, sub SubtitleCandidate) float64 {
+	total := 0.0
+	for _, v := range s.Components(target, sub) {
+		total += v
+	}
+	return total
+}
+
+// Components returns the weighted value of each component for the candidate
+func (s *DefaultScorer) Components(target guessit.Details, sub SubtitleCandidate) map[string]float64 {
+	info := candidateDetails(sub)
+
+	res := make(map[string]float64, len(s.Weights))
+	for name, weight := range s.Weights {
+		if f, ok := scoreComponents[name]; ok && weight != 0 {
+			res[name] = weight * f(target.Information, info.Information, sub) * confidence(name, target, info)
+		}
+	}
+	return res
+}
+
+// SetConfig sets the weight of a component, in the same way services are configured
+func (s *DefaultScorer) SetConfig(name, value string) error {
+	if _, ok := scoreComponents[name]; !ok {
+		return fmt.Errorf(`score component "%s" was not found`, name)
+	}
+
+	weight, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf(`invalid weight "%s" for "%s"`, value, name)
+	}
+
+	s.Weights[name] = weight
+	return nil
+}
+
+// scoreFields maps the components that compare fields to their names in guessit.Information
+var scoreFields = map[string][]string{
+	ScoreRelease:      {"Release", "StreamingSource"},
+	ScoreExtended:     {"Extended"},
+	ScoreTheatrical:   {"Theatrical"},
+	ScoreDirectorsCut: {"DirectorsCut"},
+	ScoreRemastered:   {"Remastered"},
+	ScoreResolution:   {"Resolution"},
+	ScoreGroup:        {"Group"},
+	ScoreVideoCodec:   {"VideoCodec"},
+	ScoreAudioCodec:   {"AudioCodec"},
+	ScoreYear:         {"Year"},
+	ScoreSeason:       {"Season"},
+	ScoreEpisode:      {"Episode"},
+}
+
+// confidence returns how sure the parses of the target and the candidate are of the
+// fields a component compares, so that guesses (like "DC" for director's cut) are worth less
+func confidence(component string, target, info guessit.Details) float64 {
+	res := 1.0
+	for _, field := range scoreFields[component] {
+		res = math.Min(res, math.Min(target.GetConfidence(field), info.GetConfidence(field)))
+	}
+	return res
+}
+
+func matchIf(cond bool) float64 {
+	if cond {
+		return 1
+	}
+	return 0
+}
+
+// matchString returns wether a non-empty target equals value, ignoring case
+func matchString(target, value string) float64 {
+	return matchIf(target != "" && strings.EqualFold(target, value))
+}
+
+// normalizeCodec makes equivalent codec names equal (x264, H.264 and h264, for example)
+func normalizeCodec(codec string) string {
+	codec = strings.ToLower(strings.ReplaceAll(codec, ".", ""))
+	if strings.HasPrefix(codec, "x26") {
+		codec = "h26" + strings.TrimPrefix(codec, "x26")
+	}
+	return codec
+}
+
+type releaseType int
+
+const (
+	bluray releaseType = iota
+	hdtv
+	cam
+	dvd
+	web
+
+	unknown
+)
+
+func parseRelease(t string) releaseType {
+	t = strings.ToLower(t)
+
+	switch t {
+	case "cam-rip",
+		"cam",
+		"hdcam":
+		return cam
+
+	case "dvdr",
+		"dvdrip",
+		"dvd-full",
+		"full-rip",
+		"iso rip",
+		"lossless rip",
+		"untouched rip",
+		"dvd-5",
+		"dvd-9":
+		return dvd
+
+	case "dsr",
+		"dsrip",
+		"satrip",
+		"dthrip",
+		"dvbrip",
+		"hdtv",
+		"pdtv",
+		"dtvrip",
+		"tvrip",
+		"hdtvrip":
+		return hdtv
+
+	case "webdl",
+		"web dl",
+		"web-dl",
+		"hdrip",
+		"web-dlrip",
+		"webrip",
+		"web rip",
+		"web-rip",
+		"web",
+		"web-cap",
+		"webcap",
+		"web cap",
+		"hc",
+		"hd-rip":
+		return web
+
+	case "blu-ray",
+		"bluray",
+		"blu ray",
+		"bdrip",
+		"brip",
+		"brrip",
+		"bdmv",
+		"bdr",
+		"bd25",
+		"bd50",
+		"bd5",
+		"bd9":
+		return bluray
+
+	default:
+		return unknown
+	}
+}
@@ -0,0 +1,129 @@
+package sublime
+
+import (
+	"io"
+	"math"
+	"testing"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"golang.org/x/text/language"
+)
+
+type fakeCandidate struct {
+	name    string
+	ranking float32
+	hash    bool
+	hi      bool
+}
+
+func (c fakeCandidate) GetFormatExtension() string   { return "srt" }
+func (c fakeCandidate) GetFileTarget() *FileTarget   { return nil }
+func (c fakeCandidate) GetLang() language.Tag        { return language.English }
+func (c fakeCandidate) GetService() string           { return "fake" }
+func (c fakeCandidate) GetRanking() float32          { return c.ranking }
+func (c fakeCandidate) GetInfo() guessit.Information { return guessit.Parse(c.name) }
+func (c fakeCandidate) GetDetails() guessit.Details  { return guessit.ParseDetailed(c.name) }
+func (c fakeCandidate) Open() (io.ReadCloser, error) { return nil, nil }
+func (c fakeCandidate) MatchesHash() bool            { return c.hash }
+func (c fakeCandidate) HearingImpaired() bool        { return c.hi }
+
+// Each case maps a target to a better and a worse candidate
+var scoreTestCases = map[string][2]fakeCandidate{
+	"The.Walking.Dead.S05E03.720p.HDTV.x264-ASAP": {
+		{name: "The.Walking.Dead.S05E03.720p.HDTV.x264-ASAP"},
+		{name: "The.Walking.Dead.S05E03.720p.WEB-DL.x264-NTb"},
+	},
+	"Alien.1979.REMASTERED.1080p.BluRay.H264.AAC-RARBG": {
+		{name: "Alien.1979.REMASTERED.BDRip.x264-GRP"},
+		{name: "Alien.1979.BluRay.x264-GRP"},
+	},
+	"Show.S01E02.720p.WEBRip.x264-GRP": {
+		{name: "Show.S01E02.720p.WEBRip.x264-OTHER"},
+		{name: "Show.S01E03.720p.WEBRip.x264-GRP"},
+	},
+	"Show.S01E01E02.720p.WEBRip.x264-GRP": {
+		{name: "Show.S01E01-E02.720p.WEBRip.x264-OTHER"},
+		{name: "Show.S01E01.720p.WEBRip.x264-GRP"},
+	},
+	"The.Daily.Show.2023.03.14.720p.WEB.H264-GRP": {
+		{name: "The.Daily.Show.2023.03.14.1080p.WEB.H264-OTHER"},
+		{name: "The.Daily.Show.2023.03.13.720p.WEB.H264-GRP"},
+	},
+	"The.Crown.S05E01.1080p.NF.WEB-DL.DDP5.1.H.264-FLUX": {
+		{name: "The.Crown.S05E01.720p.NF.WEB-DL.DDP5.1.H.264-OTHER"},
+		{name: "The.Crown.S05E01.1080p.AMZN.WEB-DL.DDP5.1.H.264-FLUX"},
+	},
+	"Andor.S01E01.1080p.DSNP.WEB-DL.DDP5.1.H.264-NTb": {
+		{name: "Andor.S01E01.1080p.WEB-DL.DDP5.1.H.264-NTb"},
+		{name: "Andor.S01E01.1080p.ATVP.WEB-DL.DDP5.1.H.264-NTb"},
+	},
+	"Greyhound.2020.1080p.WEBRip.x264-RARBG": {
+		{name: "Greyhound.2020.1080p.WEBRip.x264-RARBG", ranking: 5000},
+		{name: "Greyhound.2020.1080p.WEBRip.x264-RARBG", ranking: 10},
+	},
+	"Renamed.Movie.mkv": {
+		{name: "Die.Hard.1988.DVDRip.XviD-GRP", hash: true},
+		{name: "Renamed.Movie.1080p.BluRay.x264-GRP"},
+	},
+	// A hash match beats a subtitle that matches everything else
+	"Fargo.S02E05.EXTENDED.720p.BluRay.x264.AAC-GRP": {
+		{name: "Unrelated.S09E09.2160p.WEB.H265-XYZ", hash: true},
+		{name: "Fargo.S02E05.EXTENDED.720p.BluRay.x264.AAC-GRP", ranking: 100000},
+	},
+}
+
+func TestDefaultScorer(t *testing.T) {
+	t.Parallel()
+
+	scorer := NewDefaultScorer()
+	for target, cands := range scoreTestCases {
+		info := guessit.ParseDetailed(target)
+		better := scorer.Score(info, cands[0])
+		worse := scorer.Score(info, cands[1])
+
+		if better <= worse {
+			t.Errorf(`(case: "%s") Expected "%s" (%f) to score higher than "%s" (%f)`, target, cands[0].name, better, cands[1].name, worse)
+		}
+	}
+}
+
+func TestDefaultScorerConfidence(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]float64{
+		// The group is certain enough
+		"Spider-Man.2002.720p.BluRay.x264-GRP": 0.9,
+		// Groups in the middle of the name may be something else
+		"Spider-Man.2002.720p-GRP.BluRay.x264": 0.4,
+	}
+
+	scorer := NewDefaultScorer()
+	for name, confidence := range cases {
+		group := scorer.Components(guessit.ParseDetailed(name), fakeCandidate{name: name})[ScoreGroup]
+		if expected := scorer.Weights[ScoreGroup] * confidence; math.Abs(group-expected) > 1e-9 {
+			t.Errorf(`(case: "%s") Expected the group to be worth %f, but got %f`, name, expected, group)
+		}
+	}
+}
+
+func TestDefaultScorerSetConfig(t *testing.T) {
+	t.Parallel()
+
+	scorer := NewDefaultScorer()
+	if err := scorer.SetConfig(ScoreGroup, "100"); err != nil {
+		t.Fatal(err)
+	}
+	if scorer.Weights[ScoreGroup] != 100 {
+		t.Errorf(`Expected weight of "%s" to be 100, but got %f`, ScoreGroup, scorer.Weights[ScoreGroup])
+	}
+	if DefaultWeights[ScoreGroup] == 100 {
+		t.Errorf("Expected DefaultWeights to be left untouched")
+	}
+
+	if err := scorer.SetConfig("nonexistent", "1"); err == nil {
+		t.Errorf("Expected an error for an unknown component")
+	}
+	if err := scorer.SetConfig(ScoreGroup, "abc"); err == nil {
+		t.Errorf("Expected an error for an invalid weight")
+	}
+}
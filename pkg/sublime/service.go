@@ -0,0 +1,140 @@
+package sublime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"golang.org/x/text/language"
+)
+
+// ContextService is the second version of the Service contract. Searches are
+// bound to a context, so they can be cancelled or given a deadline, and
+// failures are reported next to the candidates instead of being logged
+type ContextService interface {
+	// Returns a string identifying this service. Should be all lowercase
+	GetName() string
+	// For each FileTarget, returns candidates of all of the possible languages.
+	// Errors are sent through the same channel, which is closed when the search
+	// ends or the context is done. The channel must be consumed until it is closed
+	GetCandidates(context.Context, []*FileTarget, []language.Tag) <-chan CandidateResult
+	// Configure values. No costly/long operations should be performed
+	SetConfig(name, value string) error
+	// Initialize the service
+	Initialize() error
+}
+
+// ErrNotConfigured is returned by Initialize when a service lacks the
+// settings it needs to run, like an API key
+var ErrNotConfigured = errors.New("service is not configured")
+
+// ContextOpener is implemented by candidates that can bind
+// their download to a context
+type ContextOpener interface {
+	OpenContext(context.Context) (io.ReadCloser, error)
+}
+
+// CandidateResult is a single value sent by a ContextService.
+// Exactly one of Candidate and Err is set
+type CandidateResult struct {
+	Candidate SubtitleCandidate
+	Err       error
+}
+
+// ServiceError describes why a service failed to search for a file
+type ServiceError struct {
+	Service string      // Name of the service that failed
+	File    *FileTarget // File being searched for. nil if the failure is not related to a single file
+	Err     error
+}
+
+func (e *ServiceError) Error() string {
+	if e.File == nil {
+		return fmt.Sprintf("%s: %s", e.Service, e.Err)
+	}
+	return fmt.Sprintf(`%s: "%s": %s`, e.Service, e.File, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// AsContextService returns s as a ContextService. Services that only implement
+// the first version of the contract are wrapped in an adapter
+func AsContextService(s Service) ContextService {
+	if cs, ok := s.(ContextService); ok {
+		return cs
+	}
+	return legacyService{s}
+}
+
+// OpenCandidate opens a candidate, using its context-aware
+// implementation when there is one
+func OpenCandidate(ctx context.Context, c SubtitleCandidate) (io.ReadCloser, error) {
+	if o, ok := c.(ContextOpener); ok {
+		return o.OpenContext(ctx)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Open()
+}
+
+// LegacyCandidates implements Service.GetCandidatesForFiles on top of a
+// ContextService, logging the errors it reports
+func LegacyCandidates(s ContextService, files []*FileTarget, langs []language.Tag) <-chan SubtitleCandidate {
+	channel := make(chan SubtitleCandidate)
+
+	go func() {
+		for res := range s.GetCandidates(context.Background(), files, langs) {
+			if res.Err != nil {
+				log.Println(res.Err)
+				continue
+			}
+			channel <- res.Candidate
+		}
+		close(channel)
+	}()
+
+	return channel
+}
+
+// legacyService adapts a Service to the ContextService interface
+type legacyService struct {
+	Service
+}
+
+func (l legacyService) GetCandidates(ctx context.Context, files []*FileTarget, langs []language.Tag) <-chan CandidateResult {
+	channel := make(chan CandidateResult)
+
+	go func() {
+		defer close(channel)
+
+		candidates := l.GetCandidatesForFiles(files, langs)
+		for {
+			select {
+			case <-ctx.Done():
+				// Let the legacy service finish in the background
+				go drain(candidates)
+				channel <- CandidateResult{Err: &ServiceError{Service: l.GetName(), Err: ctx.Err()}}
+				return
+			case c, ok := <-candidates:
+				if !ok {
+					return
+				}
+				channel <- CandidateResult{Candidate: c}
+			}
+		}
+	}()
+
+	return channel
+}
+
+func drain(c <-chan SubtitleCandidate) {
+	for range c {
+	}
+}
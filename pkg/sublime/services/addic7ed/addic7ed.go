@@ -0,0 +1,527 @@
+package addic7ed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/language"
+)
+
+const name = "addic7ed"
+
+const defaultURL = "https://www.addic7ed.com"
+
+// Ways of dealing with hearing impaired subtitles
+const (
+	hiInclude = "include" // Return both hearing impaired and regular subtitles
+	hiExclude = "exclude" // Ignore hearing impaired subtitles
+	hiOnly    = "only"    // Ignore regular subtitles
+)
+
+// languages maps the names used by Addic7ed to their tags
+var languages = map[string]language.Tag{
+	"Albanian":                language.Albanian,
+	"Arabic":                  language.Arabic,
+	"Armenian":                language.Armenian,
+	"Azerbaijani":             language.Azerbaijani,
+	"Bengali":                 language.Bengali,
+	"Bosnian":                 language.MustParse("bs"),
+	"Bulgarian":               language.Bulgarian,
+	"Catalan":                 language.Catalan,
+	"Chinese (Simplified)":    language.SimplifiedChinese,
+	"Chinese (Traditional)":   language.TraditionalChinese,
+	"Croatian":                language.Croatian,
+	"Czech":                   language.Czech,
+	"Danish":                  language.Danish,
+	"Dutch":                   language.Dutch,
+	"English":                 language.English,
+	"Estonian":                language.Estonian,
+	"Euskera":                 language.MustParse("eu"),
+	"Finnish":                 language.Finnish,
+	"French":                  language.French,
+	"French (Canadian)":       language.CanadianFrench,
+	"Galego":                  language.MustParse("gl"),
+	"Galician":                language.MustParse("gl"),
+	"German":                  language.German,
+	"Greek":                   language.Greek,
+	"Hebrew":                  language.Hebrew,
+	"Hindi":                   language.Hindi,
+	"Hungarian":               language.Hungarian,
+	"Icelandic":               language.Icelandic,
+	"Indonesian":              language.Indonesian,
+	"Italian":                 language.Italian,
+	"Japanese":                language.Japanese,
+	"Korean":                  language.Korean,
+	"Latvian":                 language.Latvian,
+	"Lithuanian":              language.Lithuanian,
+	"Macedonian":              language.Macedonian,
+	"Malay":                   language.Malay,
+	"Norwegian":               language.Norwegian,
+	"Persian":                 language.Persian,
+	"Polish":                  language.Polish,
+	"Portuguese":              language.EuropeanPortuguese,
+	"Portuguese (Brazilian)":  language.BrazilianPortuguese,
+	"Romanian":                language.Romanian,
+	"Russian":                 language.Russian,
+	"Serbian (Cyrillic)":      language.MustParse("sr-Cyrl"),
+	"Serbian (Latin)":         language.MustParse("sr-Latn"),
+	"Slovak":                  language.Slovak,
+	"Slovenian":               language.Slovenian,
+	"Spanish":                 language.Spanish,
+	"Spanish (Latin America)": language.LatinAmericanSpanish,
+	"Spanish (Spain)":         language.EuropeanSpanish,
+	"Swedish":                 language.Swedish,
+	"Tamil":                   language.Tamil,
+	"Thai":                    language.Thai,
+	"Turkish":                 language.Turkish,
+	"Ukrainian":               language.Ukrainian,
+	"Vietnamese":              language.Vietnamese,
+}
+
+type Addic7ed struct {
+	url    string
+	hi     string
+	client *http.Client
+
+	showsLock sync.Mutex
+	shows     map[string]string // Normalized show names to their IDs
+}
+
+type Addic7edSubtitle struct {
+	t        *sublime.FileTarget
+	a        *Addic7ed
+	lang     language.Tag
+	show     string
+	season   int
+	episode  int
+	version  string
+	hi       bool
+	link     string // Download link
+	referer  string // Page of the episode, which must be sent along with the download
+	download int    // Number of downloads
+}
+
+// row is an entry of a season page
+type row struct {
+	season    int
+	episode   int
+	page      string
+	lang      string
+	version   string
+	completed bool
+	hi        bool
+	link      string
+}
+
+func init() {
+	a := New()
+
+	sublime.Services[a.GetName()] = a
+}
+
+// New creates an Addic7ed service with the default settings
+func New() *Addic7ed {
+	return &Addic7ed{
+		url:    defaultURL,
+		hi:     hiInclude,
+		client: http.DefaultClient,
+	}
+}
+
+func (a *Addic7ed) GetName() string {
+	return name
+}
+
+func (a *Addic7ed) GetCandidatesForFiles(files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.SubtitleCandidate {
+	return sublime.LegacyCandidates(a, files, langs)
+}
+
+func (a *Addic7ed) GetCandidates(ctx context.Context, files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.CandidateResult {
+	channel := make(chan sublime.CandidateResult)
+
+	go func() {
+		defer close(channel)
+
+		// Files of the same season share the same page
+		seasons := make(map[string][]row)
+		downloads := make(map[string]map[string]int)
+
+		for _, file := range files {
+			candidates, err := a.searchFile(ctx, file, langs, seasons, downloads)
+			if err != nil {
+				channel <- sublime.CandidateResult{
+					Err: &sublime.ServiceError{Service: name, File: file, Err: err},
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+
+			for _, c := range candidates {
+				channel <- sublime.CandidateResult{Candidate: c}
+			}
+		}
+	}()
+
+	return channel
+}
+
+// searchFile finds the subtitles of an episode. Files that are not episodes have none
+func (a *Addic7ed) searchFile(ctx context.Context, file *sublime.FileTarget, langs []language.Tag, seasons map[string][]row, downloads map[string]map[string]int) ([]Addic7edSubtitle, error) {
+	info := file.GetInfo()
+	if info.Season == 0 || info.Episode == 0 || info.Title == "" {
+		return nil, nil
+	}
+
+	show, err := a.findShow(ctx, info)
+	if err != nil || show == "" {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s/%d", show, info.Season)
+	rows, ok := seasons[key]
+	if !ok {
+		rows, err = a.getSeason(ctx, show, info.Season)
+		if err != nil {
+			return nil, err
+		}
+		seasons[key] = rows
+	}
+
+	var res []Addic7edSubtitle
+	for _, r := range rows {
+		if !info.HasEpisode(r.episode) || !r.completed || !a.acceptsHI(r.hi) {
+			continue
+		}
+
+		tag, ok := languages[r.lang]
+		if !ok {
+			continue
+		}
+		lang, ok := sublime.RequestedLanguage(tag, langs)
+		if !ok {
+			continue
+		}
+
+		// Download counts are only shown in the episode's page
+		if _, ok := downloads[r.page]; !ok {
+			counts, err := a.getDownloads(ctx, r.page)
+			if err != nil && ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// Without counts, the subtitles are still good candidates
+			downloads[r.page] = counts
+		}
+
+		res = append(res, Addic7edSubtitle{
+			t:        file,
+			a:        a,
+			lang:     lang,
+			show:     info.Title,
+			season:   r.season,
+			episode:  r.episode,
+			version:  r.version,
+			hi:       r.hi,
+			link:     r.link,
+			referer:  a.url + r.page,
+			download: downloads[r.page][r.link],
+		})
+	}
+
+	return res, nil
+}
+
+func (a *Addic7ed) acceptsHI(hi bool) bool {
+	switch a.hi {
+	case hiExclude:
+		return !hi
+	case hiOnly:
+		return hi
+	default:
+		return true
+	}
+}
+
+var reNonAlnum = regexp.MustCompile(`[^\pL\pN]+`)
+var reShowYear = regexp.MustCompile(`\s*\(\d{4}\)$`)
+
+// normalize simplifies a show name so that different spellings of it are the same
+func normalize(name string) string {
+	return strings.TrimSpace(reNonAlnum.ReplaceAllString(strings.ToLower(name), " "))
+}
+
+// findShow returns the ID of the show of a file. "" if Addic7ed doesn't have it
+func (a *Addic7ed) findShow(ctx context.Context, info guessit.Information) (string, error) {
+	a.showsLock.Lock()
+	defer a.showsLock.Unlock()
+
+	if a.shows == nil {
+		shows, err := a.getShows(ctx)
+		if err != nil {
+			return "", err
+		}
+		a.shows = shows
+	}
+
+	// Shows sharing a name are told apart by their year, like "Doctor Who (2005)"
+	if info.Year != 0 {
+		if id, ok := a.shows[normalize(fmt.Sprintf("%s %d", info.Title, info.Year))]; ok {
+			return id, nil
+		}
+	}
+	return a.shows[normalize(info.Title)], nil
+}
+
+// getShows reads the list of every show, keyed by their normalized names
+func (a *Addic7ed) getShows(ctx context.Context) (map[string]string, error) {
+	doc, err := a.get(ctx, "/shows.php", "")
+	if err != nil {
+		return nil, err
+	}
+
+	shows := make(map[string]string)
+	doc.Find(`a[href^="/show/"]`).Each(func(_ int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		id := strings.TrimPrefix(href, "/show/")
+		title := strings.TrimSpace(s.Text())
+		if id == "" || title == "" {
+			return
+		}
+
+		shows[normalize(title)] = id
+		// Also find shows by their name without the year, unless it's taken
+		if plain := normalize(reShowYear.ReplaceAllString(title, "")); shows[plain] == "" {
+			shows[plain] = id
+		}
+	})
+
+	return shows, nil
+}
+
+// getSeason reads the subtitles of every episode of a season
+func (a *Addic7ed) getSeason(ctx context.Context, show string, season int) ([]row, error) {
+	query := url.Values{
+		"show":   {show},
+		"season": {strconv.Itoa(season)},
+		"langs":  {""},
+		"hd":     {"undefined"},
+		"hi":     {"undefined"},
+	}
+	doc, err := a.get(ctx, "/ajax_loadShow.php?"+query.Encode(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []row
+	doc.Find("tr.epeven").Each(func(_ int, s *goquery.Selection) {
+		cells := s.Find("td")
+		if cells.Length() < 10 {
+			return
+		}
+		text := func(i int) string {
+			return strings.TrimSpace(cells.Eq(i).Text())
+		}
+
+		r := row{
+			lang:      text(3),
+			version:   text(4),
+			completed: strings.EqualFold(text(5), "Completed"),
+			hi:        text(6) != "",
+		}
+		r.season, _ = strconv.Atoi(text(0))
+		r.episode, _ = strconv.Atoi(text(1))
+		r.page, _ = cells.Eq(2).Find("a").Attr("href")
+		r.link, _ = cells.Eq(9).Find("a").Attr("href")
+
+		if r.link != "" {
+			rows = append(rows, r)
+		}
+	})
+
+	return rows, nil
+}
+
+var reDownloads = regexp.MustCompile(`(\d+)\s+Downloads`)
+
+// getDownloads reads how many times each subtitle of an episode was downloaded, keyed by their links
+func (a *Addic7ed) getDownloads(ctx context.Context, page string) (map[string]int, error) {
+	counts := make(map[string]int)
+	doc, err := a.get(ctx, page, "")
+	if err != nil {
+		return counts, err
+	}
+
+	// Every version lists its download buttons, followed by the number of downloads
+	var links []string
+	doc.Find("a.buttonDownload, td.newsDate").Each(func(_ int, s *goquery.Selection) {
+		if href, ok := s.Attr("href"); ok {
+			links = append(links, href)
+			return
+		}
+
+		match := reDownloads.FindStringSubmatch(s.Text())
+		if match == nil {
+			return
+		}
+		n, _ := strconv.Atoi(match[1])
+		for _, l := range links {
+			counts[l] = n
+		}
+		links = nil
+	})
+
+	return counts, nil
+}
+
+// get fetches a page of the site and parses it
+func (a *Addic7ed) get(ctx context.Context, path, referer string) (*goquery.Document, error) {
+	res, err := a.request(ctx, path, referer)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	return goquery.NewDocumentFromReader(res.Body)
+}
+
+func (a *Addic7ed) request(ctx context.Context, path, referer string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if referer != "" {
+		req.Header.Set("Referer", referer)
+	}
+
+	if err := sublime.WaitRequest(ctx); err != nil {
+		return nil, err
+	}
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, sublime.ResponseError(res, "request failed")
+	}
+
+	return res, nil
+}
+
+func (a *Addic7ed) SetConfig(name, value string) error {
+	switch name {
+	case "hi":
+		switch value {
+		case hiInclude, hiExclude, hiOnly:
+			a.hi = value
+		default:
+			return fmt.Errorf(`invalid value "%s" for option "hi" (use include, exclude or only)`, value)
+		}
+	case "url":
+		a.url = strings.TrimSuffix(value, "/")
+	default:
+		return fmt.Errorf(`option "%s" was not found`, name)
+	}
+
+	return nil
+}
+
+func (a *Addic7ed) Initialize() error {
+	return nil
+}
+
+func (s Addic7edSubtitle) GetFormatExtension() string {
+	// Addic7ed only has srt subtitles
+	return "srt"
+}
+
+func (s Addic7edSubtitle) GetService() string {
+	return name
+}
+
+func (s Addic7edSubtitle) GetRanking() float32 {
+	return float32(s.download)
+}
+
+func (s Addic7edSubtitle) GetFileTarget() *sublime.FileTarget {
+	return s.t
+}
+
+func (s Addic7edSubtitle) GetLang() language.Tag {
+	return s.lang
+}
+
+// HearingImpaired returns wether the subtitle describes sounds for the hearing impaired
+func (s Addic7edSubtitle) HearingImpaired() bool {
+	return s.hi
+}
+
+// GetInfo maps the version of the subtitle, which is usually a release group
+// (like "KILLERS" or "LOL/SYS") or a release type (like "WEB-DL 720p"), into
+// the information of the episode
+func (s Addic7edSubtitle) GetInfo() guessit.Information {
+	return s.GetDetails().Information
+}
+
+func (s Addic7edSubtitle) GetDetails() guessit.Details {
+	target := s.t.GetInfo()
+
+	version := s.version
+	groups := strings.Split(version, "/")
+	if len(groups) > 1 {
+		// Several groups share the same timings. Prefer the one of the file
+		version = groups[0]
+		for _, g := range groups {
+			if strings.EqualFold(strings.TrimSpace(g), target.Group) {
+				version = g
+			}
+		}
+	}
+	version = strings.TrimSpace(version)
+
+	info := guessit.ParseDetailed(fmt.Sprintf("%s S%02dE%02d %s", s.show, s.season, s.episode, version))
+	info.Title = s.show
+	info.Season = s.season
+	info.Episode = s.episode
+
+	// Release types with a dash, like "WEB-DL", are not groups
+	if info.Group != "" && strings.Contains(strings.ToUpper(info.Release), strings.ToUpper(info.Group)) {
+		info.Group = ""
+	}
+	// A single word that isn't anything else is the release group
+	if info.Group == "" && !strings.ContainsAny(version, " .-") && info.Release == "" && info.Resolution == "" {
+		info.Group = version
+	}
+
+	return info
+}
+
+func (s Addic7edSubtitle) Open() (io.ReadCloser, error) {
+	return s.OpenContext(context.Background())
+}
+
+func (s Addic7edSubtitle) OpenContext(ctx context.Context) (io.ReadCloser, error) {
+	res, err := s.a.request(ctx, s.link, s.referer)
+	if err != nil {
+		return nil, err
+	}
+
+	// When the daily limit is exceeded, a page is returned instead of the subtitle
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") || strings.Contains(res.Request.URL.Path, "downloadexceeded") {
+		res.Body.Close()
+		return nil, fmt.Errorf("download limit exceeded")
+	}
+
+	return res.Body, nil
+}
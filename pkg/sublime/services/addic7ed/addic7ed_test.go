@@ -0,0 +1,148 @@
+package addic7ed
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"golang.org/x/text/language"
+)
+
+// newTestServer serves the saved pages of Addic7ed
+func newTestServer(t *testing.T) *httptest.Server {
+	serve := func(w http.ResponseWriter, name, contentType string) {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(data)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/shows.php":
+			serve(w, "shows.html", "text/html")
+		case r.URL.Path == "/ajax_loadShow.php" && r.URL.Query().Get("show") == "5071" && r.URL.Query().Get("season") == "2":
+			serve(w, "season.html", "text/html")
+		case strings.HasPrefix(r.URL.Path, "/serie/Mr._Robot/2/1/"):
+			serve(w, "episode.html", "text/html")
+		case strings.HasPrefix(r.URL.Path, "/original/") || strings.HasPrefix(r.URL.Path, "/updated/"):
+			// Downloads without the episode's page as the referer are refused
+			if !strings.Contains(r.Referer(), "/serie/") {
+				http.Redirect(w, r, "/downloadexceeded.php", http.StatusFound)
+				return
+			}
+			serve(w, "subtitle.srt", "text/srt")
+		default:
+			serve(w, "shows.html", "text/html")
+		}
+	}))
+}
+
+func search(t *testing.T, a *Addic7ed, file string, langs ...language.Tag) []Addic7edSubtitle {
+	var res []Addic7edSubtitle
+	for c := range a.GetCandidates(context.Background(), []*sublime.FileTarget{sublime.NewFileTarget(file)}, langs) {
+		if c.Err != nil {
+			t.Fatal(c.Err)
+		}
+		res = append(res, c.Candidate.(Addic7edSubtitle))
+	}
+	return res
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	defer server.Close()
+
+	a := New()
+	if err := a.SetConfig("url", server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	type expected struct {
+		link      string
+		lang      language.Tag
+		group     string
+		release   string
+		hi        bool
+		downloads float32
+	}
+	targets := []expected{
+		{"/original/114515/0", language.English, "KILLERS", "", false, 15321},
+		{"/original/114515/1", language.English, "KILLERS", "", true, 2210},
+		{"/original/114515/2", language.English, "", "WEB-DL", false, 980},
+		{"/updated/10/114515/0", language.BrazilianPortuguese, "KILLERS", "", false, 4870},
+	}
+
+	res := search(t, a, "/videos/Mr.Robot.S02E01.720p.WEB-DL.x264-KILLERS.mkv", language.English, language.BrazilianPortuguese)
+	if len(res) != len(targets) {
+		t.Fatalf(`Expected %d candidates, but got %d`, len(targets), len(res))
+	}
+	for i, target := range targets {
+		c := res[i]
+		info := c.GetInfo()
+		value := expected{c.link, c.GetLang(), info.Group, info.Release, c.HearingImpaired(), c.GetRanking()}
+		if value != target {
+			t.Errorf(`(case: "%s") Expected %+v, but got %+v`, target.link, target, value)
+		}
+		if info.Title != "Mr Robot" || info.Season != 2 || info.Episode != 1 {
+			t.Errorf(`(case: "%s") Expected episode to be Mr Robot S02E01, but got %s S%02dE%02d`, target.link, info.Title, info.Season, info.Episode)
+		}
+	}
+
+	stream, err := res[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	data, _ := ioutil.ReadAll(stream)
+	if !strings.Contains(string(data), "Hello, friend.") {
+		t.Errorf(`Expected the subtitle to be downloaded, but got "%s"`, data)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	defer server.Close()
+
+	cases := map[string]int{
+		"include": 3,
+		"exclude": 2,
+		"only":    1,
+	}
+
+	for hi, target := range cases {
+		a := New()
+		a.SetConfig("url", server.URL)
+		if err := a.SetConfig("hi", hi); err != nil {
+			t.Fatal(err)
+		}
+
+		value := len(search(t, a, "Mr.Robot.S02E01.mkv", language.English))
+		if value != target {
+			t.Errorf(`(case: "%s") Expected %d candidates, but got %d`, hi, target, value)
+		}
+	}
+
+	a := New()
+	a.SetConfig("url", server.URL)
+	if res := search(t, a, "Mr.Robot.S02E02.mkv", language.English); len(res) != 1 || res[0].GetInfo().Group != "DEFLATE" {
+		t.Errorf(`Expected a single candidate by DEFLATE, but got %+v`, res)
+	}
+	if res := search(t, a, "Unknown.Show.S01E01.mkv", language.English); len(res) != 0 {
+		t.Errorf(`Expected no candidates for an unknown show, but got %d`, len(res))
+	}
+	if err := a.SetConfig("hi", "sometimes"); err == nil {
+		t.Errorf(`Expected an invalid "hi" value to be refused`)
+	}
+}
@@ -0,0 +1,396 @@
+package generic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"golang.org/x/text/language"
+)
+
+const name = "generic"
+
+// Generic offers the subtitles of providers described by definition files, for
+// sources that only need a search request and a download link to be used
+type Generic struct {
+	path      string
+	config    map[string]map[string]string // Options of each provider
+	providers []*provider
+	client    *http.Client
+}
+
+type GenericSubtitle struct {
+	t       *sublime.FileTarget
+	p       *provider
+	lang    language.Tag
+	release string
+	ranking float32
+	format  string
+	link    string
+	headers map[string]string // Headers sent along with the download
+	client  *http.Client
+}
+
+func init() {
+	g := New()
+
+	sublime.Services[g.GetName()] = g
+}
+
+// New creates a generic service. It must be given a definition file before being initialized
+func New() *Generic {
+	return &Generic{
+		config: make(map[string]map[string]string),
+		client: http.DefaultClient,
+	}
+}
+
+func (g *Generic) GetName() string {
+	return name
+}
+
+func (g *Generic) GetCandidatesForFiles(files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.SubtitleCandidate {
+	return sublime.LegacyCandidates(g, files, langs)
+}
+
+func (g *Generic) GetCandidates(ctx context.Context, files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.CandidateResult {
+	channel := make(chan sublime.CandidateResult)
+
+	go func() {
+		defer close(channel)
+
+		for _, file := range files {
+			for _, p := range g.providers {
+				candidates, err := g.searchFile(ctx, p, file, langs)
+				if err != nil {
+					channel <- sublime.CandidateResult{
+						Err: &sublime.ServiceError{Service: p.service(), File: file, Err: err},
+					}
+					if ctx.Err() != nil {
+						return
+					}
+				}
+
+				for _, c := range candidates {
+					channel <- sublime.CandidateResult{Candidate: c}
+				}
+			}
+		}
+	}()
+
+	return channel
+}
+
+// searchFile searches a provider for every language. Languages that render the same
+// request (because the provider ignores them) are searched only once, and results
+// repeated by the searches of different languages are offered only once
+func (g *Generic) searchFile(ctx context.Context, p *provider, file *sublime.FileTarget, langs []language.Tag) ([]GenericSubtitle, error) {
+	var res []GenericSubtitle
+	searched := make(map[string]bool)
+	offered := make(map[string]bool)
+
+	for _, lang := range langs {
+		q := g.query(p, file, lang)
+
+		search, err := execute(p.url, q)
+		if err != nil {
+			return res, err
+		}
+		body, err := execute(p.body, q)
+		if err != nil {
+			return res, err
+		}
+		headers, err := executeHeaders(p.headers, q)
+		if err != nil {
+			return res, err
+		}
+		key := search + "\n" + body
+		if searched[key] {
+			continue
+		}
+		searched[key] = true
+
+		results, err := g.search(ctx, p, search, body, headers)
+		if err != nil {
+			return res, err
+		}
+
+		var downloadHeaders map[string]string
+		if p.downloadHeaders != nil {
+			downloadHeaders, err = executeHeaders(p.downloadHeaders, q)
+			if err != nil {
+				return res, err
+			}
+		}
+		base, _ := url.Parse(search)
+		for _, r := range results {
+			c, ok := p.candidate(r, langs)
+			if !ok {
+				continue
+			}
+			// Links may be relative to the search
+			link, err := url.Parse(c.link)
+			if err == nil && base != nil {
+				link = base.ResolveReference(link)
+				c.link = link.String()
+			}
+			key := c.lang.String() + " " + c.link
+			if offered[key] {
+				continue
+			}
+			offered[key] = true
+			c.t = file
+			c.headers = downloadHeaders
+			// The search headers may have credentials, which are only sent back to the same host
+			if p.downloadHeaders == nil && err == nil && base != nil && sameHost(base, link) {
+				c.headers = headers
+			}
+			c.client = g.client
+			res = append(res, c)
+		}
+	}
+
+	return res, nil
+}
+
+// sameHost returns wether two URLs are served by the same host, through the same scheme
+func sameHost(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host)
+}
+
+// query returns what the templates of a provider are filled with to search for a file
+func (g *Generic) query(p *provider, file *sublime.FileTarget, lang language.Tag) query {
+	q := query{
+		Information: file.GetInfo(),
+		Name:        file.GetName(),
+		Lang:        p.languageCode(lang),
+		Config:      g.config[p.Name],
+	}
+	base, _ := lang.Base()
+	q.LangBase = base.String()
+	q.LangISO3 = base.ISO3()
+	if hash, err := file.GetHash(); err == nil {
+		q.Hash = fmt.Sprintf("%016x", hash)
+	}
+	return q
+}
+
+// search makes a search request and returns the results in its response
+func (g *Generic) search(ctx context.Context, p *provider, search, body string, headers map[string]string) ([]*gabs.Container, error) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, p.Search.Method, search, reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if err := sublime.WaitRequest(ctx); err != nil {
+		return nil, err
+	}
+	res, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, sublime.ResponseError(res, "search failed")
+	}
+
+	doc, err := gabs.ParseJSONBuffer(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if p.Search.Results != "" {
+		doc = doc.Path(p.Search.Results)
+	}
+	if _, ok := doc.Data().([]interface{}); !ok {
+		return nil, fmt.Errorf(`"%s" is not a list of results`, p.Search.Results)
+	}
+	return doc.Children(), nil
+}
+
+// candidate reads a search result. Results in languages that weren't requested are ignored
+func (p *provider) candidate(r *gabs.Container, langs []language.Tag) (GenericSubtitle, bool) {
+	lang, ok := sublime.RequestedLanguage(p.language(field(r, p.Fields.Language)), langs)
+	link := field(r, p.Fields.Link)
+	if !ok || link == "" {
+		return GenericSubtitle{}, false
+	}
+
+	c := GenericSubtitle{
+		p:       p,
+		lang:    lang,
+		release: field(r, p.Fields.Release),
+		format:  strings.ToLower(strings.TrimPrefix(field(r, p.Fields.Format), ".")),
+		link:    link,
+	}
+	if ranking, err := strconv.ParseFloat(field(r, p.Fields.Ranking), 32); err == nil {
+		c.ranking = float32(ranking)
+	}
+	if c.format == "" {
+		c.format = "srt"
+	}
+	return c, true
+}
+
+// field returns the value in a path of a result as text. "" if there is none
+func field(r *gabs.Container, path string) string {
+	if path == "" {
+		return ""
+	}
+
+	switch v := r.Path(path).Data().(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// language returns the tag of a language as named by the provider
+func (p *provider) language(code string) language.Tag {
+	for k, v := range p.Languages {
+		if strings.EqualFold(k, code) {
+			return language.Make(v)
+		}
+	}
+	return language.Make(code)
+}
+
+// languageCode returns the name of a language in the provider
+func (p *provider) languageCode(lang language.Tag) string {
+	for k, v := range p.Languages {
+		if language.Make(v) == lang {
+			return k
+		}
+	}
+	return lang.String()
+}
+
+func (p *provider) service() string {
+	return name + "/" + p.Name
+}
+
+// SetConfig sets the definition file ("providers") or an option of
+// a provider ("<provider>.<option>"), which its templates may use
+func (g *Generic) SetConfig(name, value string) error {
+	if name == "providers" {
+		g.path = value
+		return nil
+	}
+
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf(`option "%s" was not found`, name)
+	}
+	if g.config[parts[0]] == nil {
+		g.config[parts[0]] = make(map[string]string)
+	}
+	g.config[parts[0]][parts[1]] = value
+
+	return nil
+}
+
+// Initialize loads the definitions of the providers
+func (g *Generic) Initialize() error {
+	if g.path == "" {
+		return fmt.Errorf(`%w: a definition file is required (set "%s.providers")`, sublime.ErrNotConfigured, name)
+	}
+
+	providers, err := loadProviders(g.path)
+	if err != nil {
+		return err
+	}
+	for p := range g.config {
+		if !defined(providers, p) {
+			return fmt.Errorf(`provider "%s" was not found`, p)
+		}
+	}
+	g.providers = providers
+
+	return nil
+}
+
+func defined(providers []*provider, name string) bool {
+	for _, p := range providers {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s GenericSubtitle) GetFormatExtension() string {
+	return s.format
+}
+
+// GetService returns the name of the provider, prefixed by the name of this service
+func (s GenericSubtitle) GetService() string {
+	return s.p.service()
+}
+
+func (s GenericSubtitle) GetRanking() float32 {
+	return s.ranking
+}
+
+func (s GenericSubtitle) GetFileTarget() *sublime.FileTarget {
+	return s.t
+}
+
+func (s GenericSubtitle) GetLang() language.Tag {
+	return s.lang
+}
+
+func (s GenericSubtitle) GetInfo() guessit.Information {
+	return s.GetDetails().Information
+}
+
+func (s GenericSubtitle) GetDetails() guessit.Details {
+	return guessit.ParseDetailed(s.release)
+}
+
+func (s GenericSubtitle) Open() (io.ReadCloser, error) {
+	return s.OpenContext(context.Background())
+}
+
+func (s GenericSubtitle) OpenContext(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.link, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, sublime.ResponseError(res, "download failed")
+	}
+	return res.Body, nil
+}
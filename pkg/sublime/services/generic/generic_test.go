@@ -0,0 +1,217 @@
+package generic
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"golang.org/x/text/language"
+)
+
+// newTestServer serves the providers of testdata/providers.yaml, recording the requests they make
+func newTestServer(t *testing.T, requests *[]string) *httptest.Server {
+	search, err := ioutil.ReadFile(filepath.Join("testdata", "search.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lock sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		*requests = append(*requests, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+		lock.Unlock()
+
+		switch r.URL.Path {
+		case "/api/search":
+			if r.Header.Get("X-Api-Key") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write(search)
+		case "/api/find":
+			w.Write([]byte(`[{"language": "en", "url": "/dl/4"}, {"language": "en"}]`))
+		case "/dl/1", "/dl/2", "/dl/4":
+			if r.Header.Get("X-Api-Key") != "secret" && r.URL.Path != "/dl/4" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("subtitle " + filepath.Base(r.URL.Path)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	var requests []string
+	server := newTestServer(t, &requests)
+	defer server.Close()
+
+	g := New()
+	g.SetConfig("providers", filepath.Join("testdata", "providers.yaml"))
+	g.SetConfig("example.url", server.URL)
+	g.SetConfig("example.key", "secret")
+	g.SetConfig("bylanguage.url", server.URL)
+	if err := g.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	var res []GenericSubtitle
+	files := []*sublime.FileTarget{sublime.NewFileTarget("Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.mkv")}
+	for c := range g.GetCandidates(context.Background(), files, []language.Tag{language.BrazilianPortuguese, language.English}) {
+		if c.Err != nil {
+			t.Fatal(c.Err)
+		}
+		res = append(res, c.Candidate.(GenericSubtitle))
+	}
+
+	targetRequests := []string{
+		"GET /api/search?title=Mr+Robot&season=2&episode=1&lang=pob ",
+		"GET /api/search?title=Mr+Robot&season=2&episode=1&lang=en ",
+		`POST /api/find {"name": "Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.mkv"}`,
+	}
+	sort.Strings(requests)
+	sort.Strings(targetRequests)
+	if strings.Join(requests, "\n") != strings.Join(targetRequests, "\n") {
+		t.Errorf(`Expected requests %q, but got %q`, targetRequests, requests)
+	}
+
+	targets := []struct {
+		service string
+		lang    language.Tag
+		group   string
+		ranking float32
+		format  string
+		content string
+	}{
+		{"generic/example", language.BrazilianPortuguese, "KILLERS", 1530, "srt", "subtitle 1"},
+		{"generic/example", language.English, "FLEET", 824, "ass", "subtitle 2"},
+		{"generic/bylanguage", language.English, "", 0, "srt", "subtitle 4"},
+	}
+	if len(res) != len(targets) {
+		t.Fatalf(`Expected %d candidates, but got %+v`, len(targets), res)
+	}
+	for i, target := range targets {
+		c := res[i]
+		if c.GetService() != target.service || c.GetLang() != target.lang || c.GetInfo().Group != target.group || c.GetRanking() != target.ranking || c.GetFormatExtension() != target.format {
+			t.Errorf(`(case: %d) Expected %+v, but got %+v`, i, target, c)
+			continue
+		}
+
+		stream, err := c.Open()
+		if err != nil {
+			t.Errorf(`(case: %d) %s`, i, err)
+			continue
+		}
+		content, _ := ioutil.ReadAll(stream)
+		stream.Close()
+		if string(content) != target.content {
+			t.Errorf(`(case: %d) Expected "%s", but got "%s"`, i, target.content, content)
+		}
+	}
+}
+
+func TestInitialize(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string][]string{
+		"unknown provider":    {"providers", filepath.Join("testdata", "providers.yaml"), "unknown.url", "x"},
+		"no definition file":  {},
+		"missing definitions": {"providers", filepath.Join("testdata", "missing.yaml")},
+	}
+
+	for c, options := range cases {
+		g := New()
+		for i := 0; i < len(options); i += 2 {
+			g.SetConfig(options[i], options[i+1])
+		}
+		if err := g.Initialize(); err == nil {
+			t.Errorf(`(case: "%s") Expected an error, but got none`, c)
+		}
+	}
+
+	if err := New().SetConfig("url", "x"); err == nil {
+		t.Errorf(`Expected options outside of a provider to be refused`)
+	}
+}
+
+func TestDownloadHeaders(t *testing.T) {
+	t.Parallel()
+
+	// The links of the search point to another host, which must not get the key
+	var leaked []string
+	var lock sync.Mutex
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		leaked = append(leaked, r.Header.Get("X-Api-Key"))
+		lock.Unlock()
+		w.Write([]byte("subtitle"))
+	}))
+	defer other.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"language": "en", "url": "` + other.URL + `/dl/1"}, {"language": "pt-BR", "url": "/dl/2"}]`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "sublime-generic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	providers := filepath.Join(dir, "providers.yaml")
+	err = ioutil.WriteFile(providers, []byte(`
+providers:
+  - name: mirror
+    search:
+      url: "{{.Config.url}}/api/search"
+      headers:
+        X-Api-Key: secret
+    fields:
+      language: language
+      link: url
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := New()
+	g.SetConfig("providers", providers)
+	g.SetConfig("mirror.url", server.URL)
+	if err := g.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []*sublime.FileTarget{sublime.NewFileTarget("Movie.2020.mkv")}
+	for c := range g.GetCandidates(context.Background(), files, []language.Tag{language.English, language.BrazilianPortuguese}) {
+		if c.Err != nil {
+			t.Fatal(c.Err)
+		}
+		s := c.Candidate.(GenericSubtitle)
+		if s.GetLang() == language.BrazilianPortuguese && s.headers["X-Api-Key"] != "secret" {
+			t.Errorf(`Expected the search headers to be sent to the search host, but got %v`, s.headers)
+		}
+
+		stream, err := s.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Close()
+	}
+
+	if len(leaked) != 1 || leaked[0] != "" {
+		t.Errorf(`Expected no headers to be sent to another host, but got %q`, leaked)
+	}
+}
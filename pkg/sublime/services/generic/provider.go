@@ -0,0 +1,190 @@
+package generic
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"gopkg.in/yaml.v3"
+)
+
+// definitions is the content of a file with providers. Being
+// a superset of JSON, YAML is used to read files in both formats
+type definitions struct {
+	Providers []*provider `yaml:"providers"`
+}
+
+// provider is a source of subtitles described by a definition
+type provider struct {
+	Name string `yaml:"name"`
+
+	Search struct {
+		Method  string            `yaml:"method"`  // GET by default
+		URL     string            `yaml:"url"`     // Template of the search URL
+		Body    string            `yaml:"body"`    // Template of the request body, if any
+		Headers map[string]string `yaml:"headers"` // Templates of the request headers
+		Results string            `yaml:"results"` // Path of the list of results in the response. "" for the root
+	} `yaml:"search"`
+
+	// Paths of the fields of each result
+	Fields struct {
+		Language string `yaml:"language"`
+		Release  string `yaml:"release"`
+		Ranking  string `yaml:"ranking"`
+		Link     string `yaml:"link"`
+		Format   string `yaml:"format"`
+	} `yaml:"fields"`
+
+	Download struct {
+		// Templates of the download headers. By default, the search headers are
+		// sent to links of the same host as the search, and none to other hosts
+		Headers map[string]string `yaml:"headers"`
+	} `yaml:"download"`
+
+	// Languages maps the codes of the provider to BCP 47 tags, when they differ
+	Languages map[string]string `yaml:"languages"`
+
+	url             *template.Template
+	body            *template.Template
+	headers         map[string]*template.Template
+	downloadHeaders map[string]*template.Template // nil if the download has no headers of its own
+}
+
+// query is what the templates of a provider are filled with
+type query struct {
+	guessit.Information
+	Name     string            // Name of the file
+	Hash     string            // OpenSubtitles movie hash of the file, in hexadecimal. "" if it couldn't be computed
+	Lang     string            // Requested language, as the provider names it (like "pt-BR")
+	LangBase string            // ISO 639-1 code of the requested language (like "pt")
+	LangISO3 string            // ISO 639-2 code of the requested language (like "por")
+	Config   map[string]string // Options set with "generic.<provider>.<option>=value"
+}
+
+var funcs = template.FuncMap{
+	"query": url.QueryEscape,
+	"path":  url.PathEscape,
+	"lower": strings.ToLower,
+}
+
+// loadProviders reads the providers defined in a file, or in
+// every .yaml, .yml and .json file of a directory
+func loadProviders(path string) ([]*provider, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if stat.IsDir() {
+		files = nil
+		for _, pattern := range []string{"*.yaml", "*.yml", "*.json"} {
+			matches, _ := filepath.Glob(filepath.Join(path, pattern))
+			files = append(files, matches...)
+		}
+	}
+
+	var res []*provider
+	names := make(map[string]bool)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var defs definitions
+		if err := yaml.Unmarshal(data, &defs); err != nil {
+			return nil, fmt.Errorf(`"%s": %s`, file, err)
+		}
+		for _, p := range defs.Providers {
+			if err := p.compile(); err != nil {
+				return nil, fmt.Errorf(`"%s": %s`, file, err)
+			}
+			if names[p.Name] {
+				return nil, fmt.Errorf(`"%s": provider "%s" is defined twice`, file, p.Name)
+			}
+			names[p.Name] = true
+			res = append(res, p)
+		}
+	}
+
+	return res, nil
+}
+
+// compile checks a definition and parses its templates
+func (p *provider) compile() error {
+	if p.Name == "" {
+		return fmt.Errorf("provider without a name")
+	}
+	if p.Search.URL == "" || p.Fields.Language == "" || p.Fields.Link == "" {
+		return fmt.Errorf(`provider "%s": "search.url", "fields.language" and "fields.link" are required`, p.Name)
+	}
+	if p.Search.Method == "" {
+		p.Search.Method = "GET"
+	}
+	p.Search.Method = strings.ToUpper(p.Search.Method)
+
+	var err error
+	if p.url, err = p.parse("url", p.Search.URL); err != nil {
+		return err
+	}
+	if p.body, err = p.parse("body", p.Search.Body); err != nil {
+		return err
+	}
+	if p.headers, err = p.parseHeaders(p.Search.Headers); err != nil {
+		return err
+	}
+	if p.Download.Headers != nil {
+		if p.downloadHeaders, err = p.parseHeaders(p.Download.Headers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *provider) parse(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf(`provider "%s": %s`, p.Name, err)
+	}
+	return t, nil
+}
+
+func (p *provider) parseHeaders(headers map[string]string) (map[string]*template.Template, error) {
+	res := make(map[string]*template.Template, len(headers))
+	for name, text := range headers {
+		t, err := p.parse(name, text)
+		if err != nil {
+			return nil, err
+		}
+		res[name] = t
+	}
+	return res, nil
+}
+
+func execute(t *template.Template, q query) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, q); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func executeHeaders(headers map[string]*template.Template, q query) (map[string]string, error) {
+	res := make(map[string]string, len(headers))
+	for name, t := range headers {
+		value, err := execute(t, q)
+		if err != nil {
+			return nil, err
+		}
+		res[name] = value
+	}
+	return res, nil
+}
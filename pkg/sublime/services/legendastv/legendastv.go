@@ -0,0 +1,396 @@
+package legendastv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/language"
+)
+
+const name = "legendastv"
+
+const defaultURL = "http://legendas.tv"
+
+// siteLanguages are the languages of the site and their IDs, by preference
+var siteLanguages = []struct {
+	tag language.Tag
+	id  int
+}{
+	{language.BrazilianPortuguese, 1},
+	{language.English, 2},
+	{language.Spanish, 3},
+	{language.French, 4},
+	{language.German, 5},
+	{language.Japanese, 6},
+	{language.Danish, 7},
+	{language.Norwegian, 8},
+	{language.Swedish, 9},
+	{language.EuropeanPortuguese, 10},
+	{language.Arabic, 11},
+	{language.Czech, 12},
+	{language.Chinese, 13},
+	{language.Korean, 14},
+	{language.Bulgarian, 15},
+	{language.Italian, 16},
+	{language.Polish, 17},
+}
+
+type LegendasTV struct {
+	url            string
+	username       string
+	password       string
+	retriesAllowed int
+	retryDelay     time.Duration
+	client         *http.Client
+
+	retriesLock sync.Mutex
+	retries     int // What is left of the retry budget
+}
+
+// LegendasTVSubtitle is a subtitle inside the archive of a release
+type LegendasTVSubtitle struct {
+	sublime.ArchiveCandidate
+	archive string // Name of the release the subtitle was packed in
+}
+
+// release is a search result: an archive with one or more subtitles
+type release struct {
+	name      string
+	id        string
+	downloads int
+}
+
+func init() {
+	l := New()
+
+	sublime.Services[l.GetName()] = l
+}
+
+// New creates a Legendas.tv service with the default settings
+func New() *LegendasTV {
+	jar, _ := cookiejar.New(nil)
+	return &LegendasTV{
+		url:            defaultURL,
+		retriesAllowed: 3,
+		retryDelay:     time.Second,
+		client:         &http.Client{Jar: jar},
+	}
+}
+
+func (l *LegendasTV) GetName() string {
+	return name
+}
+
+func (l *LegendasTV) GetCandidatesForFiles(files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.SubtitleCandidate {
+	return sublime.LegacyCandidates(l, files, langs)
+}
+
+func (l *LegendasTV) GetCandidates(ctx context.Context, files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.CandidateResult {
+	channel := make(chan sublime.CandidateResult)
+
+	go func() {
+		defer close(channel)
+
+		// Season packs are shared by all the episodes in them
+		archives := make(map[string][]sublime.ArchiveEntry)
+
+		for _, file := range files {
+			for _, lang := range langs {
+				id, ok := languageID(lang)
+				if !ok {
+					continue
+				}
+
+				candidates, err := l.searchFile(ctx, file, lang, id, archives)
+				if err != nil {
+					channel <- sublime.CandidateResult{
+						Err: &sublime.ServiceError{Service: name, File: file, Err: err},
+					}
+					if ctx.Err() != nil {
+						return
+					}
+				}
+
+				for _, c := range candidates {
+					channel <- sublime.CandidateResult{Candidate: c}
+				}
+			}
+		}
+	}()
+
+	return channel
+}
+
+// languageID returns the ID of the site language that serves lang
+func languageID(lang language.Tag) (int, bool) {
+	for _, l := range siteLanguages {
+		if sublime.CoversLanguage(l.tag, lang) {
+			return l.id, true
+		}
+	}
+	return 0, false
+}
+
+// searchFile searches the releases of a file and offers every subtitle inside the matching ones.
+// Candidates found before a failure are returned along with the error
+func (l *LegendasTV) searchFile(ctx context.Context, file *sublime.FileTarget, lang language.Tag, langID int, archives map[string][]sublime.ArchiveEntry) ([]LegendasTVSubtitle, error) {
+	target := file.GetInfo()
+	if target.Title == "" {
+		return nil, nil
+	}
+
+	releases, err := l.search(ctx, target.Title, langID)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []LegendasTVSubtitle
+	var failure error
+	for _, r := range releases {
+		if !sameMedia(target, guessit.Parse(r.name)) {
+			continue
+		}
+
+		files, ok := archives[r.id]
+		if !ok {
+			files, err = l.downloadArchive(ctx, r.id)
+			if ctx.Err() != nil {
+				return res, ctx.Err()
+			}
+			// The other releases may still be downloaded
+			if err != nil {
+				failure = err
+				continue
+			}
+			archives[r.id] = files
+		}
+
+		// Season packs have a subtitle for each episode
+		for _, e := range sublime.MatchArchiveEntries(files, file) {
+			res = append(res, LegendasTVSubtitle{
+				ArchiveCandidate: sublime.ArchiveCandidate{
+					Entry:   e,
+					Target:  file,
+					Lang:    lang,
+					Service: name,
+					Ranking: float32(r.downloads),
+				},
+				archive: r.name,
+			})
+		}
+	}
+
+	return res, failure
+}
+
+var reNonAlnum = regexp.MustCompile(`[^\pL\pN]+`)
+
+// normalize simplifies a title so that different spellings of it are the same
+func normalize(title string) string {
+	return strings.TrimSpace(reNonAlnum.ReplaceAllString(strings.ToLower(title), " "))
+}
+
+// sameMedia returns wether a release may have subtitles for the target.
+// Releases of the same season without an episode are season packs
+func sameMedia(target, release guessit.Information) bool {
+	if normalize(target.Title) != normalize(release.Title) {
+		return false
+	}
+	if target.Season != 0 && release.Season != target.Season {
+		return false
+	}
+	if !target.AirDate.IsZero() && !release.AirDate.IsZero() && !target.AirDate.Equal(release.AirDate) {
+		return false
+	}
+	return target.Episode == 0 || release.Episode == 0 || target.SharesEpisode(release)
+}
+
+var reDownloads = regexp.MustCompile(`(\d+)\s+downloads`)
+var reReleaseID = regexp.MustCompile(`^/download/([^/]+)/`)
+
+// search returns the releases found by a query in a language
+func (l *LegendasTV) search(ctx context.Context, query string, langID int) ([]release, error) {
+	res, err := l.request(ctx, http.MethodGet, fmt.Sprintf("/legenda/busca/%s/%d", url.PathEscape(query), langID), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	doc, err := goquery.NewDocumentFromReader(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []release
+	doc.Find("div.f_left").Each(func(_ int, s *goquery.Selection) {
+		link := s.Find(`a[href^="/download/"]`).First()
+		href, _ := link.Attr("href")
+		match := reReleaseID.FindStringSubmatch(href)
+		if match == nil {
+			return
+		}
+
+		r := release{
+			name: strings.TrimSpace(link.Text()),
+			id:   match[1],
+		}
+		if downloads := reDownloads.FindStringSubmatch(s.Find("p.data").Text()); downloads != nil {
+			r.downloads, _ = strconv.Atoi(downloads[1])
+		}
+		releases = append(releases, r)
+	})
+
+	return releases, nil
+}
+
+// downloadArchive downloads a release and extracts its subtitles. The archive is read
+// as it is downloaded, so that ReadArchive refuses the ones too large before they are
+// in memory. Downloads that failed temporarily are retried while there is retry budget left
+func (l *LegendasTV) downloadArchive(ctx context.Context, id string) ([]sublime.ArchiveEntry, error) {
+	for attempt := 1; ; attempt++ {
+		res, err := l.request(ctx, http.MethodGet, "/downloadarquivo/"+id, nil)
+		if err == nil {
+			var entries []sublime.ArchiveEntry
+			entries, err = sublime.ReadArchive(res.Body)
+			res.Body.Close()
+			if err == nil {
+				return entries, nil
+			}
+		}
+		if ctx.Err() != nil || !retryable(err) || !l.retry() {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * l.retryDelay):
+		}
+	}
+}
+
+// retryable returns wether a failed download may work if made again: network failures,
+// rate limits and server errors. Other responses (like a 404) won't change
+func retryable(err error) bool {
+	var tempErr *sublime.TemporaryError
+	var netErr net.Error
+	return errors.As(err, &tempErr) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retry spends a retry from the budget, returning false if there are none left
+func (l *LegendasTV) retry() bool {
+	l.retriesLock.Lock()
+	defer l.retriesLock.Unlock()
+
+	if l.retries <= 0 {
+		return false
+	}
+	l.retries--
+	return true
+}
+
+func (l *LegendasTV) request(ctx context.Context, method, path string, form url.Values) (*http.Response, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, l.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	if err := sublime.WaitRequest(ctx); err != nil {
+		return nil, err
+	}
+	res, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, sublime.ResponseError(res, "request failed")
+	}
+
+	return res, nil
+}
+
+func (l *LegendasTV) SetConfig(name, value string) error {
+	switch name {
+	case "username":
+		l.username = value
+	case "password":
+		l.password = value
+	case "retriesAllowed":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf(`invalid value "%s" for option "retriesAllowed"`, value)
+		}
+		l.retriesAllowed = n
+	case "url":
+		l.url = strings.TrimSuffix(value, "/")
+	default:
+		return fmt.Errorf(`option "%s" was not found`, name)
+	}
+
+	return nil
+}
+
+// Initialize logs in, if there are credentials, and resets the retry budget
+func (l *LegendasTV) Initialize() error {
+	l.retries = l.retriesAllowed
+
+	if l.username == "" {
+		return nil
+	}
+
+	form := url.Values{
+		"data[User][username]": {l.username},
+		"data[User][password]": {l.password},
+		"data[lembrar]":        {"on"},
+	}
+	res, err := l.request(context.Background(), http.MethodPost, "/login", form)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	page, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(page, []byte("Usuário ou senha inválidos")) {
+		return fmt.Errorf("invalid username or password")
+	}
+
+	return nil
+}
+
+func (s LegendasTVSubtitle) GetInfo() guessit.Information {
+	return s.GetDetails().Information
+}
+
+// GetDetails returns the information in the name of the subtitle,
+// completed by the one in the name of its release
+func (s LegendasTVSubtitle) GetDetails() guessit.Details {
+	return guessit.MergeDetails(s.ArchiveCandidate.GetDetails(), guessit.ParseDetailed(s.archive))
+}
@@ -0,0 +1,180 @@
+package legendastv
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"golang.org/x/text/language"
+)
+
+// makeZip creates a zip archive with the given files, keyed by their paths
+func makeZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newTestServer serves a saved search page and its archives. The first failures
+// downloads fail with a status, and the number of downloads is counted
+func newTestServer(t *testing.T, failures int32, status int, downloads *int32) *httptest.Server {
+	search, err := ioutil.ReadFile(filepath.Join("testdata", "search.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archives := map[string][]byte{
+		"5f2b1c7a": makeZip(t, map[string]string{
+			"Mr.Robot.S02.720p.WEBRip/Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.srt": "1",
+			"Mr.Robot.S02.720p.WEBRip/Mr.Robot.S02E02.720p.WEBRip.x264-KILLERS.srt": "2",
+			"Mr.Robot.S02.720p.WEBRip/Legendas.tv.url":                              "",
+		}),
+		"7a81ce0d": makeZip(t, map[string]string{
+			"Mr.Robot.S02E01.HDTV.x264-FLEET.srt":    "3",
+			"Mr.Robot.S02E01.720p.HDTV.x264-AVS.srt": "4",
+			"Leia-me.txt":                            "",
+		}),
+	}
+
+	var attempts int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/legenda/busca/Mr Robot/1":
+			w.Write(search)
+		case "/downloadarquivo/5f2b1c7a", "/downloadarquivo/7a81ce0d":
+			atomic.AddInt32(downloads, 1)
+			if atomic.AddInt32(&attempts, 1) <= failures {
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			w.Write(archives[filepath.Base(r.URL.Path)])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func search(l *LegendasTV, file string) ([]LegendasTVSubtitle, []error) {
+	var res []LegendasTVSubtitle
+	var errs []error
+	for c := range l.GetCandidates(context.Background(), []*sublime.FileTarget{sublime.NewFileTarget(file)}, []language.Tag{language.BrazilianPortuguese}) {
+		if c.Err != nil {
+			errs = append(errs, c.Err)
+			continue
+		}
+		res = append(res, c.Candidate.(LegendasTVSubtitle))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Entry.Name < res[j].Entry.Name
+	})
+	return res, errs
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	var downloads int32
+	server := newTestServer(t, 0, http.StatusOK, &downloads)
+	defer server.Close()
+
+	l := New()
+	l.SetConfig("url", server.URL)
+	if err := l.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	res, errs := search(l, "Mr.Robot.S02E01.720p.WEB-DL.mkv")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	targets := []struct {
+		name    string
+		content string
+		group   string
+		ranking float32
+	}{
+		{"Mr.Robot.S02.720p.WEBRip/Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.srt", "1", "KILLERS", 1530},
+		{"Mr.Robot.S02E01.720p.HDTV.x264-AVS.srt", "4", "AVS", 824},
+		{"Mr.Robot.S02E01.HDTV.x264-FLEET.srt", "3", "FLEET", 824},
+	}
+	if len(res) != len(targets) {
+		t.Fatalf(`Expected %d candidates, but got %d: %+v`, len(targets), len(res), res)
+	}
+
+	for i, target := range targets {
+		c := res[i]
+		stream, _ := c.Open()
+		content, _ := ioutil.ReadAll(stream)
+
+		if c.Entry.Name != target.name || string(content) != target.content {
+			t.Errorf(`(case: "%s") Expected subtitle "%s" with "%s", but got "%s" with "%s"`, target.name, target.name, target.content, c.Entry.Name, content)
+		}
+		if info := c.GetInfo(); info.Group != target.group || info.Season != 2 || info.Episode != 1 {
+			t.Errorf(`(case: "%s") Expected S02E01 by %s, but got S%02dE%02d by %s`, target.name, target.group, info.Season, info.Episode, info.Group)
+		}
+		if c.GetRanking() != target.ranking || c.GetLang() != language.BrazilianPortuguese || c.GetFormatExtension() != "srt" {
+			t.Errorf(`(case: "%s") Expected ranking %f in pt-BR srt, but got %f in %s %s`, target.name, target.ranking, c.GetRanking(), c.GetLang(), c.GetFormatExtension())
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		failures   int32
+		status     int
+		retries    string
+		candidates int
+		downloads  int32
+	}{
+		// Both archives are downloaded after a single retry
+		{1, http.StatusServiceUnavailable, "1", 3, 3},
+		{1, http.StatusTooManyRequests, "1", 3, 3},
+		// The first archive fails and spends the whole budget
+		{3, http.StatusServiceUnavailable, "2", 2, 4},
+		// Without retries, failures are final
+		{1, http.StatusServiceUnavailable, "0", 2, 2},
+		// Archives that are missing are not retried
+		{1, http.StatusNotFound, "2", 2, 2},
+	}
+
+	for _, c := range cases {
+		var downloads int32
+		server := newTestServer(t, c.failures, c.status, &downloads)
+
+		l := New()
+		l.retryDelay = 0
+		l.SetConfig("url", server.URL)
+		if err := l.SetConfig("retriesAllowed", c.retries); err != nil {
+			t.Fatal(err)
+		}
+		l.Initialize()
+
+		res, errs := search(l, "Mr.Robot.S02E01.mkv")
+		server.Close()
+
+		if len(res) != c.candidates || downloads != c.downloads {
+			t.Errorf(`(case: %d failures with %d, %s retries) Expected %d candidates in %d downloads, but got %d in %d (errors: %v)`, c.failures, c.status, c.retries, c.candidates, c.downloads, len(res), downloads, errs)
+		}
+	}
+}
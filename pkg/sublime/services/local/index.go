@@ -0,0 +1,171 @@
+package local
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PietroCarrara/sublime/pkg/subtitle"
+	"golang.org/x/text/language"
+)
+
+// index lists the subtitles of a library, by directory. It is saved between runs,
+// and only the directories that changed since then are read again
+type index struct {
+	Dirs map[string]*directory // Keyed by their path relative to the library
+}
+
+// directory is what an index knows about a directory of the library
+type directory struct {
+	ModTime   time.Time
+	Subtitles []entry
+	Subdirs   []string // Names of the subdirectories
+}
+
+// entry is a subtitle of the library
+type entry struct {
+	Name            string
+	Lang            string
+	HearingImpaired bool
+}
+
+// loadIndex reads an index saved by save. A missing or broken one is empty
+func loadIndex(file string) *index {
+	idx := &index{Dirs: make(map[string]*directory)}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return idx
+	}
+	if err := json.Unmarshal(data, idx); err != nil || idx.Dirs == nil {
+		return &index{Dirs: make(map[string]*directory)}
+	}
+	return idx
+}
+
+func (idx *index) save(file string) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// refresh returns the index of the library at root, reusing the directories that weren't
+// modified. Adding, removing or renaming a file changes the modification time of its
+// directory, so the others may be trusted. Also returns wether anything changed
+func (idx *index) refresh(root string) (*index, bool, error) {
+	res := &index{Dirs: make(map[string]*directory)}
+
+	stat, err := os.Stat(root)
+	if err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	var walk func(rel string, modTime time.Time)
+	walk = func(rel string, modTime time.Time) {
+		dir, ok := idx.Dirs[rel]
+		if !ok || !dir.ModTime.Equal(modTime) {
+			changed = true
+
+			var err error
+			dir, err = readDirectory(filepath.Join(root, rel), modTime)
+			// Unreadable directories are left out, so they are tried again in the next run
+			if err != nil {
+				return
+			}
+		}
+		res.Dirs[rel] = dir
+
+		for _, sub := range dir.Subdirs {
+			path := filepath.Join(rel, sub)
+			stat, err := os.Stat(filepath.Join(root, path))
+			if err != nil || !stat.IsDir() {
+				changed = true
+				continue
+			}
+			walk(path, stat.ModTime())
+		}
+	}
+	walk(".", stat.ModTime())
+
+	return res, changed || len(res.Dirs) != len(idx.Dirs), nil
+}
+
+// readDirectory lists the subtitles and subdirectories of a directory
+func readDirectory(path string, modTime time.Time) (*directory, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := &directory{ModTime: modTime}
+	for _, f := range files {
+		if f.IsDir() {
+			dir.Subdirs = append(dir.Subdirs, f.Name())
+			continue
+		}
+		if e, ok := parseName(f.Name()); ok {
+			dir.Subtitles = append(dir.Subtitles, e)
+		}
+	}
+	return dir, nil
+}
+
+var reLanguage = regexp.MustCompile(`^[a-z]{2,3}(?:[-_][A-Za-z]{2,4})?$`)
+
+// parseName reads the language of a subtitle named like "<release>.<lang>[.hi|.forced].<format>".
+// Subtitles without a language, or with only forced subtitles, are ignored
+func parseName(name string) (entry, bool) {
+	ext := filepath.Ext(name)
+	if _, err := subtitle.FormatFromExtension(ext); err != nil {
+		return entry{}, false
+	}
+
+	e := entry{Name: name}
+	parts := strings.Split(strings.TrimSuffix(name, ext), ".")
+	for len(parts) > 1 {
+		last := strings.ToLower(parts[len(parts)-1])
+		if last == "forced" {
+			return entry{}, false
+		}
+		if last != "hi" && last != "sdh" && last != "cc" {
+			break
+		}
+		e.HearingImpaired = true
+		parts = parts[:len(parts)-1]
+	}
+
+	code := parts[len(parts)-1]
+	if len(parts) < 2 || !reLanguage.MatchString(code) {
+		return entry{}, false
+	}
+	tag, err := language.Parse(strings.ReplaceAll(code, "_", "-"))
+	if err != nil || tag == language.Und {
+		return entry{}, false
+	}
+	e.Lang = tag.String()
+
+	return e, true
+}
+
+// release returns the name of the subtitle without its language and format
+func (e entry) release() string {
+	name := strings.TrimSuffix(e.Name, filepath.Ext(e.Name))
+	for {
+		ext := filepath.Ext(name)
+		lower := strings.ToLower(ext)
+		name = strings.TrimSuffix(name, ext)
+		if lower != ".hi" && lower != ".sdh" && lower != ".cc" {
+			return name
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"golang.org/x/text/language"
+)
+
+const name = "local"
+
+// Local offers the subtitles of a directory tree, like an archive kept in a NAS
+type Local struct {
+	path      string
+	indexFile string // Where the index is saved between runs. "" for the default
+
+	lock    sync.Mutex
+	index   *index
+	library map[string][]item // Subtitles of the index by their normalized title
+}
+
+type LocalSubtitle struct {
+	t    *sublime.FileTarget
+	lang language.Tag
+	item item
+}
+
+// item is a subtitle of the library, ready to be matched
+type item struct {
+	path string
+	lang language.Tag
+	hi   bool
+	info guessit.Information
+
+	confidence map[string]float64
+}
+
+func init() {
+	l := New()
+
+	sublime.Services[l.GetName()] = l
+}
+
+// New creates a local service. It must be given a path before being initialized
+func New() *Local {
+	return &Local{}
+}
+
+func (l *Local) GetName() string {
+	return name
+}
+
+func (l *Local) GetCandidatesForFiles(files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.SubtitleCandidate {
+	return sublime.LegacyCandidates(l, files, langs)
+}
+
+func (l *Local) GetCandidates(ctx context.Context, files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.CandidateResult {
+	channel := make(chan sublime.CandidateResult)
+
+	go func() {
+		defer close(channel)
+
+		library, err := l.refresh()
+		if err != nil {
+			channel <- sublime.CandidateResult{Err: &sublime.ServiceError{Service: name, Err: err}}
+			return
+		}
+
+		for _, file := range files {
+			if ctx.Err() != nil {
+				channel <- sublime.CandidateResult{Err: &sublime.ServiceError{Service: name, Err: ctx.Err()}}
+				return
+			}
+
+			target := file.GetInfo()
+			for _, it := range library[normalize(target.Title)] {
+				lang, ok := sublime.RequestedLanguage(it.lang, langs)
+				if !ok || !sameMedia(target, it.info) {
+					continue
+				}
+				channel <- sublime.CandidateResult{Candidate: LocalSubtitle{t: file, lang: lang, item: it}}
+			}
+		}
+	}()
+
+	return channel
+}
+
+// refresh brings the index up to date with the library and returns its subtitles
+func (l *Local) refresh() (map[string][]item, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	idx, changed, err := l.index.refresh(l.path)
+	if err != nil {
+		return nil, err
+	}
+	if !changed && l.library != nil {
+		return l.library, nil
+	}
+
+	l.index = idx
+	l.library = idx.items(l.path)
+	// The index is only a cache, so failing to save it doesn't stop the search
+	if changed {
+		idx.save(l.indexFile)
+	}
+	return l.library, nil
+}
+
+// items parses the subtitles of an index, grouping them by title
+func (idx *index) items(root string) map[string][]item {
+	res := make(map[string][]item)
+	for rel, dir := range idx.Dirs {
+		for _, e := range dir.Subtitles {
+			details := guessit.ParseDetailed(e.release())
+			it := item{
+				path:       filepath.Join(root, rel, e.Name),
+				lang:       language.Make(e.Lang),
+				hi:         e.HearingImpaired,
+				info:       withDirectories(details.Information, rel),
+				confidence: details.Confidence,
+			}
+			title := normalize(it.info.Title)
+			res[title] = append(res[title], it)
+		}
+	}
+	return res
+}
+
+var reSeasonDir = regexp.MustCompile(`(?i)^(?:season|series|temporada|saison|s)[ ._-]*(\d{1,2})$`)
+
+// withDirectories completes the information of a subtitle named only after
+// its episode (like "S02E01.en.srt") with the directories it is in
+func withDirectories(info guessit.Information, rel string) guessit.Information {
+	if info.Title != "" || rel == "." {
+		return info
+	}
+
+	dirs := strings.Split(filepath.ToSlash(rel), "/")
+	for i := len(dirs) - 1; i >= 0 && info.Title == ""; i-- {
+		if match := reSeasonDir.FindStringSubmatch(dirs[i]); match != nil {
+			if info.Season == 0 {
+				info.Season, _ = strconv.Atoi(match[1])
+			}
+			continue
+		}
+
+		dir := guessit.Parse(dirs[i])
+		info.Title = dir.Title
+		if info.Year == 0 {
+			info.Year = dir.Year
+		}
+	}
+	return info
+}
+
+var reNonAlnum = regexp.MustCompile(`[^\pL\pN]+`)
+
+// normalize simplifies a title so that different spellings of it are the same
+func normalize(title string) string {
+	return strings.TrimSpace(reNonAlnum.ReplaceAllString(strings.ToLower(title), " "))
+}
+
+// sameMedia returns wether a subtitle of the library was made for the target
+func sameMedia(target, sub guessit.Information) bool {
+	if target.Season != sub.Season || !target.SameEpisodes(sub) || !target.AirDate.Equal(sub.AirDate) {
+		return false
+	}
+	return target.Year == 0 || sub.Year == 0 || target.Year == sub.Year
+}
+
+func (l *Local) SetConfig(name, value string) error {
+	switch name {
+	case "path":
+		l.path = value
+	case "index":
+		l.indexFile = value
+	default:
+		return fmt.Errorf(`option "%s" was not found`, name)
+	}
+
+	return nil
+}
+
+// Initialize checks the library and loads the index saved in the last run
+func (l *Local) Initialize() error {
+	if l.path == "" {
+		return fmt.Errorf(`%w: the library directory is required (set "%s.path")`, sublime.ErrNotConfigured, name)
+	}
+
+	path, err := filepath.Abs(l.path)
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf(`"%s" is not a directory`, path)
+	}
+	l.path = path
+
+	if l.indexFile == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return err
+		}
+		// Each library has its own index
+		h := fnv.New64a()
+		h.Write([]byte(path))
+		l.indexFile = filepath.Join(cache, "sublime", fmt.Sprintf("local-%x.json", h.Sum64()))
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.index = loadIndex(l.indexFile)
+	l.library = nil
+
+	return nil
+}
+
+func (s LocalSubtitle) GetFormatExtension() string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(s.item.path), "."))
+}
+
+func (s LocalSubtitle) GetService() string {
+	return name
+}
+
+// GetRanking is the same for every subtitle, since the library has no ratings
+func (s LocalSubtitle) GetRanking() float32 {
+	return 0
+}
+
+func (s LocalSubtitle) GetFileTarget() *sublime.FileTarget {
+	return s.t
+}
+
+func (s LocalSubtitle) GetLang() language.Tag {
+	return s.lang
+}
+
+// HearingImpaired returns wether the subtitle describes sounds for the hearing impaired
+func (s LocalSubtitle) HearingImpaired() bool {
+	return s.item.hi
+}
+
+func (s LocalSubtitle) GetInfo() guessit.Information {
+	return s.item.info
+}
+
+func (s LocalSubtitle) GetDetails() guessit.Details {
+	return guessit.Details{Information: s.item.info, Confidence: s.item.confidence}
+}
+
+// GetPath returns where the subtitle is in the library
+func (s LocalSubtitle) GetPath() string {
+	return s.item.path
+}
+
+func (s LocalSubtitle) Open() (io.ReadCloser, error) {
+	return os.Open(s.item.path)
+}
@@ -0,0 +1,166 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"golang.org/x/text/language"
+)
+
+// newTestLibrary creates a library with the given files, and a service for it
+func newTestLibrary(t *testing.T, files []string) (*Local, string) {
+	dir, err := ioutil.TempDir("", "sublime-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(dir, "library")
+	for _, f := range files {
+		path := filepath.Join(root, filepath.FromSlash(f))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := New()
+	l.SetConfig("path", root)
+	l.SetConfig("index", filepath.Join(dir, "index.json"))
+	if err := l.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return l, dir
+}
+
+func search(t *testing.T, l *Local, file string, langs ...language.Tag) []LocalSubtitle {
+	var res []LocalSubtitle
+	for c := range l.GetCandidates(context.Background(), []*sublime.FileTarget{sublime.NewFileTarget(file)}, langs) {
+		if c.Err != nil {
+			t.Fatal(c.Err)
+		}
+		res = append(res, c.Candidate.(LocalSubtitle))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].GetPath() < res[j].GetPath()
+	})
+	return res
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	l, dir := newTestLibrary(t, []string{
+		"Mr Robot/Season 2/Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.pt-BR.srt",
+		"Mr Robot/Season 2/Mr.Robot.S02E02.720p.WEBRip.x264-KILLERS.pt-BR.srt",
+		"Mr Robot/Season 2/Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.pt-BR.forced.srt",
+		"Mr Robot/Season 2/S02E01.en.hi.ass",
+		"Mr Robot/Season 2/S02E01.fr.srt",
+		"Movies/Inception.2010.1080p.BluRay.x264-SPARKS.en.srt",
+		"Movies/Inception.2010.1080p.BluRay.x264-SPARKS.srt",
+		"Movies/Inception.1080p.BluRay.x264-SPARKS.nfo",
+	})
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		file  string
+		langs []language.Tag
+		names []string
+	}{
+		{
+			"Mr.Robot.S02E01.1080p.WEBRip.x264-FLEET.mkv",
+			[]language.Tag{language.BrazilianPortuguese, language.English},
+			[]string{"Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.pt-BR.srt", "S02E01.en.hi.ass"},
+		},
+		{
+			"Inception.2010.720p.mkv",
+			[]language.Tag{language.AmericanEnglish},
+			[]string{"Inception.2010.1080p.BluRay.x264-SPARKS.en.srt"},
+		},
+		{
+			"Inception.1998.720p.mkv",
+			[]language.Tag{language.English},
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		res := search(t, l, c.file, c.langs...)
+		if len(res) != len(c.names) {
+			t.Errorf(`(case: "%s") Expected %d candidates, but got %+v`, c.file, len(c.names), res)
+			continue
+		}
+		for i, name := range c.names {
+			if filepath.Base(res[i].GetPath()) != name {
+				t.Errorf(`(case: "%s") Expected candidate "%s", but got "%s"`, c.file, name, res[i].GetPath())
+			}
+		}
+	}
+
+	res := search(t, l, "Mr.Robot.S02E01.mkv", language.English)
+	if len(res) != 1 {
+		t.Fatalf(`Expected 1 candidate, but got %+v`, res)
+	}
+	info := res[0].GetInfo()
+	if info.Title != "Mr Robot" || info.Season != 2 || info.Episode != 1 || !res[0].HearingImpaired() || res[0].GetFormatExtension() != "ass" {
+		t.Errorf(`Expected a hearing impaired ass subtitle for Mr Robot S02E01, but got %+v`, res[0])
+	}
+
+	stream, err := res[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(stream)
+	stream.Close()
+	if string(content) != "Mr Robot/Season 2/S02E01.en.hi.ass" {
+		t.Errorf(`Expected the content of the subtitle, but got "%s"`, content)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	l, dir := newTestLibrary(t, []string{
+		"Movies/Inception.2010.en.srt",
+		"Shows/Mr.Robot.S02E01.en.srt",
+	})
+	defer os.RemoveAll(dir)
+
+	if res := search(t, l, "Inception.2010.mkv", language.English); len(res) != 1 {
+		t.Fatalf(`Expected 1 candidate, but got %+v`, res)
+	}
+
+	// A new instance starts from the saved index. Directories that weren't modified
+	// are not read again, so the subtitle hidden from the index is not found
+	saved := New()
+	saved.SetConfig("path", l.path)
+	saved.SetConfig("index", l.indexFile)
+	if err := saved.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	shows := "Shows"
+	if saved.index.Dirs[shows] == nil {
+		t.Fatalf(`Expected the index to be saved, but got %+v`, saved.index.Dirs)
+	}
+	saved.index.Dirs[shows].Subtitles = nil
+	if res := search(t, saved, "Mr.Robot.S02E01.mkv", language.English); len(res) != 0 {
+		t.Errorf(`Expected the index to be reused, but got %+v`, res)
+	}
+
+	// Adding a file modifies its directory
+	path := filepath.Join(l.path, "Shows", "Mr.Robot.S02E02.en.srt")
+	ioutil.WriteFile(path, nil, 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Dir(path), later, later)
+
+	for _, file := range []string{"Mr.Robot.S02E01.mkv", "Mr.Robot.S02E02.mkv"} {
+		if res := search(t, saved, file, language.English); len(res) != 1 {
+			t.Errorf(`(case: "%s") Expected the directory to be read again, but got %+v`, file, res)
+		}
+	}
+}
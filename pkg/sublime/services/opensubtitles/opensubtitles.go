@@ -1,15 +1,18 @@
 package opensubtitles
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 
 	"github.com/PietroCarrara/sublime/pkg/guessit"
 	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"github.com/kolo/xmlrpc"
 	"github.com/oz/osdb"
 	"golang.org/x/text/language"
 )
@@ -43,6 +46,10 @@ func (o *OpenSubtitles) GetName() string {
 }
 
 func (o *OpenSubtitles) GetCandidatesForFiles(files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.SubtitleCandidate {
+	return sublime.LegacyCandidates(o, files, langs)
+}
+
+func (o *OpenSubtitles) GetCandidates(ctx context.Context, files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.CandidateResult {
 	langList := make([]string, len(langs))
 	for i, lang := range langs {
 		if iso639, ok := langToISO639[lang]; ok {
@@ -54,31 +61,33 @@ func (o *OpenSubtitles) GetCandidatesForFiles(files []*sublime.FileTarget, langs
 	}
 	langsString := strings.Join(langList, ",")
 
-	channel := make(chan sublime.SubtitleCandidate)
+	channel := make(chan sublime.CandidateResult)
 	go func() {
+		defer close(channel)
+
 		// Loop over every file
 		for _, file := range files {
-			args := map[string]string{
-				"query":         file.GetName(),
-				"sublanguageid": langsString,
-			}
-			params := []interface{}{
-				o.c.Token,
-				[]map[string]string{args},
-			}
-			res, err := o.c.SearchSubtitles(&params)
+			res, err := o.searchFile(ctx, file, langsString)
 			if err != nil {
-				log.Printf("opensubtitles: %s\n", err)
+				channel <- sublime.CandidateResult{
+					Err: &sublime.ServiceError{Service: name, File: file, Err: err},
+				}
+				if ctx.Err() != nil {
+					return
+				}
 				// Go to the next file
 				continue
 			}
 
+			info := file.GetInfo()
 			for _, sub := range res {
 				// Check if seasons match
-				if season := file.GetInfo().Season; season != 0 {
-					if sub.SeriesSeason != strconv.Itoa(season) {
-						continue
-					}
+				if info.Season != 0 && sub.SeriesSeason != strconv.Itoa(info.Season) {
+					continue
+				}
+				// Check if the episode is one of the file's
+				if episode, err := strconv.Atoi(sub.SeriesEpisode); err == nil && episode != 0 && info.Episode != 0 && !info.HasEpisode(episode) {
+					continue
 				}
 
 				candidate := OpenSubtitlesSubtitle{
@@ -86,15 +95,134 @@ func (o *OpenSubtitles) GetCandidatesForFiles(files []*sublime.FileTarget, langs
 					t: file,
 					c: o.c,
 				}
-				channel <- candidate
+				channel <- sublime.CandidateResult{Candidate: candidate}
 			}
 		}
-		close(channel)
 	}()
 
 	return channel
 }
 
+// searchFile searches subtitles by the file's movie hash,
+// falling back to a search by its name
+func (o *OpenSubtitles) searchFile(ctx context.Context, file *sublime.FileTarget, langs string) (osdb.Subtitles, error) {
+	if hash, err := file.GetHash(); err == nil {
+		size, _ := file.GetSize()
+		args := map[string]string{
+			"moviehash":     sublime.FormatMovieHash(hash),
+			"moviebytesize": strconv.FormatInt(size, 10),
+			"sublanguageid": langs,
+		}
+
+		res, err := o.search(ctx, args)
+		if err == nil && len(res) > 0 {
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// Searching again right away won't help
+		var tempErr *sublime.TemporaryError
+		if errors.As(err, &tempErr) {
+			return nil, err
+		}
+	}
+
+	args := map[string]string{
+		"query":         nameQuery(file),
+		"sublanguageid": langs,
+	}
+	return o.search(ctx, args)
+}
+
+// nameQuery returns the name a file is searched by. Names that tell nothing
+// without their directories, like "Season 2/E05.mkv", are described by them
+func nameQuery(file *sublime.FileTarget) string {
+	info := file.GetInfo()
+	if info.Title == "" || guessit.Parse(file.GetName()).Title != "" {
+		return file.GetName()
+	}
+
+	switch {
+	case info.Season != 0 && info.Episode != 0:
+		return fmt.Sprintf("%s S%02dE%02d", info.Title, info.Season, info.Episode)
+	case info.Year != 0:
+		return fmt.Sprintf("%s %d", info.Title, info.Year)
+	}
+	return info.Title
+}
+
+// search runs a XML-RPC search, giving up on it when the context is done
+func (o *OpenSubtitles) search(ctx context.Context, args map[string]string) (osdb.Subtitles, error) {
+	type result struct {
+		subs osdb.Subtitles
+		err  error
+	}
+
+	if err := sublime.WaitRequest(ctx); err != nil {
+		return nil, err
+	}
+
+	// The XML-RPC client can't be cancelled, so the call is
+	// abandoned in the background when the context is done
+	done := make(chan result, 1)
+	go func() {
+		params := []interface{}{
+			o.c.Token,
+			[]map[string]string{args},
+		}
+		var response struct {
+			Status string         `xmlrpc:"status"`
+			Data   osdb.Subtitles `xmlrpc:"data"`
+		}
+		err := o.c.Call("SearchSubtitles", params, &response)
+		if err == nil {
+			err = statusError(response.Status)
+		}
+		done <- result{response.Data, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-done:
+		return res.subs, res.err
+	}
+}
+
+// statusError returns the failure reported by the status of a XML-RPC response, like
+// "429 Too many requests". Rate limits and server errors are temporary
+func statusError(status string) error {
+	code, err := strconv.Atoi(strings.SplitN(status, " ", 2)[0])
+	if status == "" || (err == nil && code >= 200 && code < 300) {
+		return nil
+	}
+
+	failure := fmt.Errorf("search failed: %s", status)
+	if code == http.StatusTooManyRequests || code >= 500 {
+		return &sublime.TemporaryError{Err: failure}
+	}
+	return failure
+}
+
+// transport fails the HTTP responses of rate limits and server errors with TemporaryErrors,
+// which the XML-RPC client would only report as text
+type transport struct {
+	http.RoundTripper
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		res.Body.Close()
+		return nil, sublime.ResponseError(res, "request failed")
+	}
+	return res, nil
+}
+
 func (o *OpenSubtitles) SetConfig(name, value string) error {
 	switch name {
 	case "username":
@@ -109,11 +237,15 @@ func (o *OpenSubtitles) SetConfig(name, value string) error {
 }
 
 func (o *OpenSubtitles) Initialize() error {
-	c, err := osdb.NewClient()
+	server := os.Getenv("OSDB_SERVER")
+	if server == "" {
+		server = osdb.DefaultOSDBServer
+	}
+	rpc, err := xmlrpc.NewClient(server, transport{http.DefaultTransport})
 	if err != nil {
 		return err
 	}
-	o.c = c
+	o.c = &osdb.Client{UserAgent: osdb.DefaultUserAgent, Client: rpc}
 
 	return o.c.LogIn(o.username, o.password, "")
 }
@@ -151,16 +283,37 @@ func (s OpenSubtitlesSubtitle) GetLang() language.Tag {
 	return language.Make(s.s.ISO639)
 }
 
+func (s OpenSubtitlesSubtitle) MatchesHash() bool {
+	return s.s.MatchedBy == "moviehash"
+}
+
 func (s OpenSubtitlesSubtitle) GetInfo() guessit.Information {
-	return guessit.Parse(s.s.SubFileName)
+	return s.GetDetails().Information
+}
+
+func (s OpenSubtitlesSubtitle) GetDetails() guessit.Details {
+	return guessit.ParseDetailed(s.s.SubFileName)
 }
 
 func (s OpenSubtitlesSubtitle) Open() (io.ReadCloser, error) {
+	return s.OpenContext(context.Background())
+}
+
+func (s OpenSubtitlesSubtitle) OpenContext(ctx context.Context) (io.ReadCloser, error) {
 	url := "https://subs5.strem.io/en/download/subencoding-stremio-utf8/src-api/file/" + s.s.IDSubtitleFile
-	res, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, sublime.ResponseError(res, "download failed")
+	}
+
 	return res.Body, nil
 }
@@ -0,0 +1,60 @@
+package opensubtitles
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"github.com/kolo/xmlrpc"
+)
+
+func TestStatusError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		status    string
+		failed    bool
+		temporary bool
+	}{
+		{"", false, false},
+		{"200 OK", false, false},
+		{"206 Partial content; message", false, false},
+		{"401 Unauthorized", true, false},
+		{"407 Download limit reached, 500 per day", true, false},
+		{"429 Too many requests", true, true},
+		{"503 Service Unavailable", true, true},
+	}
+
+	for _, c := range cases {
+		err := statusError(c.status)
+		var tempErr *sublime.TemporaryError
+		if (err != nil) != c.failed || errors.As(err, &tempErr) != c.temporary {
+			t.Errorf(`(case: "%s") Expected a failure: %v, temporary: %v, but got %v`, c.status, c.failed, c.temporary, err)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	t.Parallel()
+
+	for status, temporary := range map[int]bool{http.StatusTooManyRequests: true, http.StatusBadGateway: true, http.StatusNotFound: false} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+
+		rpc, err := xmlrpc.NewClient(server.URL, transport{http.DefaultTransport})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rpc.Call("SearchSubtitles", nil, nil)
+		rpc.Close()
+		server.Close()
+
+		var tempErr *sublime.TemporaryError
+		if err == nil || errors.As(err, &tempErr) != temporary {
+			t.Errorf(`(case: %d) Expected a failure, temporary: %v, but got %v`, status, temporary, err)
+		}
+	}
+}
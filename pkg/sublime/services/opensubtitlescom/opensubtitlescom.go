@@ -0,0 +1,492 @@
+package opensubtitlescom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"golang.org/x/text/language"
+)
+
+const name = "opensubtitlescom"
+
+const (
+	defaultURL = "https://api.opensubtitles.com/api/v1"
+	userAgent  = "sublime v1"
+)
+
+// siteLanguages are the codes of the site that are not simply the base language
+var siteLanguages = map[string]language.Tag{
+	"pt-br": language.BrazilianPortuguese,
+	"pt-pt": language.EuropeanPortuguese,
+	"zh-cn": language.SimplifiedChinese,
+	"zh-tw": language.TraditionalChinese,
+	"ea":    language.LatinAmericanSpanish,
+}
+
+type OpenSubtitlesCom struct {
+	url      string
+	apiKey   string
+	username string
+	password string
+	client   *http.Client
+
+	lock      sync.Mutex
+	token     string
+	remaining int    // Downloads left in the quota. -1 if unknown
+	resetTime string // When the quota will be reset. "" if unknown
+}
+
+type OpenSubtitlesComSubtitle struct {
+	t    *sublime.FileTarget
+	o    *OpenSubtitlesCom
+	lang language.Tag
+	attr attributes
+	file file
+}
+
+// searchResponse is the response of /subtitles
+type searchResponse struct {
+	TotalPages int `json:"total_pages"`
+	Page       int `json:"page"`
+	Data       []struct {
+		ID         string     `json:"id"`
+		Attributes attributes `json:"attributes"`
+	} `json:"data"`
+}
+
+type attributes struct {
+	Language        string  `json:"language"`
+	DownloadCount   int     `json:"download_count"`
+	HearingImpaired bool    `json:"hearing_impaired"`
+	FPS             float64 `json:"fps"`
+	Release         string  `json:"release"`
+	MoviehashMatch  bool    `json:"moviehash_match"`
+	FeatureDetails  struct {
+		Title         string `json:"title"`
+		Year          int    `json:"year"`
+		SeasonNumber  int    `json:"season_number"`
+		EpisodeNumber int    `json:"episode_number"`
+	} `json:"feature_details"`
+	Files []file `json:"files"`
+}
+
+type file struct {
+	FileID   int    `json:"file_id"`
+	FileName string `json:"file_name"`
+}
+
+// downloadResponse is the response of /download
+type downloadResponse struct {
+	Link      string `json:"link"`
+	Remaining int    `json:"remaining"`
+	Message   string `json:"message"`
+	ResetTime string `json:"reset_time_utc"`
+}
+
+func init() {
+	o := New()
+
+	sublime.Services[o.GetName()] = o
+}
+
+// New creates an OpenSubtitles.com service with the default settings
+func New() *OpenSubtitlesCom {
+	return &OpenSubtitlesCom{
+		url:       defaultURL,
+		client:    http.DefaultClient,
+		remaining: -1,
+	}
+}
+
+func (o *OpenSubtitlesCom) GetName() string {
+	return name
+}
+
+func (o *OpenSubtitlesCom) GetCandidatesForFiles(files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.SubtitleCandidate {
+	return sublime.LegacyCandidates(o, files, langs)
+}
+
+func (o *OpenSubtitlesCom) GetCandidates(ctx context.Context, files []*sublime.FileTarget, langs []language.Tag) <-chan sublime.CandidateResult {
+	codes := make([]string, len(langs))
+	for i, lang := range langs {
+		codes[i] = languageCode(lang)
+	}
+	langsString := strings.Join(codes, ",")
+
+	channel := make(chan sublime.CandidateResult)
+	go func() {
+		defer close(channel)
+
+		for _, file := range files {
+			res, err := o.searchFile(ctx, file, langsString)
+			if err != nil {
+				channel <- sublime.CandidateResult{
+					Err: &sublime.ServiceError{Service: name, File: file, Err: err},
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+
+			for _, attr := range res {
+				lang, ok := sublime.RequestedLanguage(parseLanguage(attr.Language), langs)
+				if !ok {
+					continue
+				}
+				for _, f := range attr.Files {
+					channel <- sublime.CandidateResult{Candidate: OpenSubtitlesComSubtitle{
+						t:    file,
+						o:    o,
+						lang: lang,
+						attr: attr,
+						file: f,
+					}}
+				}
+			}
+		}
+	}()
+
+	return channel
+}
+
+// languageCode returns the code the site uses for a language
+func languageCode(lang language.Tag) string {
+	for code, tag := range siteLanguages {
+		if tag == lang {
+			return code
+		}
+	}
+	base, _ := lang.Base()
+	return base.String()
+}
+
+func parseLanguage(code string) language.Tag {
+	if tag, ok := siteLanguages[strings.ToLower(code)]; ok {
+		return tag
+	}
+	return language.Make(code)
+}
+
+// searchFile searches subtitles by the file's movie hash along with its IMDb ID
+// or its title, season and episode. Hash matches are flagged by the site
+func (o *OpenSubtitlesCom) searchFile(ctx context.Context, file *sublime.FileTarget, langs string) ([]attributes, error) {
+	info := file.GetInfo()
+
+	params := url.Values{}
+	params.Set("languages", langs)
+	if hash, err := file.GetHash(); err == nil {
+		params.Set("moviehash", sublime.FormatMovieHash(hash))
+	}
+	if id := imdbID(file); id != "" {
+		params.Set("imdb_id", id)
+	} else {
+		params.Set("query", strings.ToLower(info.Title))
+		if info.Year != 0 {
+			params.Set("year", strconv.Itoa(info.Year))
+		}
+	}
+	if info.Season != 0 {
+		params.Set("season_number", strconv.Itoa(info.Season))
+	}
+	if info.Episode != 0 {
+		params.Set("episode_number", strconv.Itoa(info.Episode))
+	}
+
+	var res []attributes
+	for page := 1; ; page++ {
+		params.Set("page", strconv.Itoa(page))
+
+		var body searchResponse
+		// Encode sorts the parameters, as the API asks
+		if err := o.call(ctx, http.MethodGet, "/subtitles?"+params.Encode(), nil, &body); err != nil {
+			return nil, err
+		}
+		for _, d := range body.Data {
+			res = append(res, d.Attributes)
+		}
+
+		// A few pages are enough to find the best subtitles
+		if page >= body.TotalPages || page >= 3 {
+			return res, nil
+		}
+	}
+}
+
+var reIMDb = regexp.MustCompile(`imdb\.com/title/tt(\d+)`)
+
+// imdbID reads the IMDb ID of a file from the .nfo file next to it, as left by
+// media centers. "" if there is none
+func imdbID(file *sublime.FileTarget) string {
+	base := strings.TrimSuffix(file.GetPath(), filepath.Ext(file.GetPath()))
+	for _, nfo := range []string{base + ".nfo", filepath.Join(filepath.Dir(file.GetPath()), "movie.nfo")} {
+		data, err := ioutil.ReadFile(nfo)
+		if err != nil {
+			continue
+		}
+		if match := reIMDb.FindSubmatch(data); match != nil {
+			// The API takes the ID without the "tt" prefix nor leading zeros
+			return strings.TrimLeft(string(match[1]), "0")
+		}
+	}
+	return ""
+}
+
+// call sends a request to the API, decoding its JSON response into res
+func (o *OpenSubtitlesCom) call(ctx context.Context, method, path string, body interface{}, res interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	o.lock.Lock()
+	req, err := http.NewRequestWithContext(ctx, method, o.url+path, reader)
+	token := o.token
+	o.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Api-Key", o.apiKey)
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if err := sublime.WaitRequest(ctx); err != nil {
+		return err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr); apiErr.Message != "" {
+			return sublime.TemporaryStatus(resp, &apiError{resp.StatusCode, apiErr.Message})
+		}
+		return sublime.TemporaryStatus(resp, &apiError{resp.StatusCode, resp.Status})
+	}
+
+	return json.Unmarshal(data, res)
+}
+
+// apiError is an error reported by the API
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("request failed (%d): %s", e.status, e.message)
+}
+
+// download asks the API for the link of a file, keeping track of the download quota
+func (o *OpenSubtitlesCom) download(ctx context.Context, fileID int) (string, error) {
+	var res downloadResponse
+	err := o.call(ctx, http.MethodPost, "/download", map[string]interface{}{"file_id": fileID}, &res)
+
+	// The quota is exhausted
+	if e, ok := err.(*apiError); ok && e.status == http.StatusNotAcceptable {
+		o.lock.Lock()
+		o.remaining = 0
+		reset := o.resetTime
+		o.lock.Unlock()
+
+		if reset != "" {
+			return "", fmt.Errorf("download quota exceeded, it will be reset at %s", reset)
+		}
+		return "", fmt.Errorf("download quota exceeded: %s", e.message)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	o.lock.Lock()
+	o.remaining = res.Remaining
+	o.resetTime = res.ResetTime
+	o.lock.Unlock()
+
+	if res.Link == "" {
+		return "", fmt.Errorf("no download link: %s", res.Message)
+	}
+	return res.Link, nil
+}
+
+// Quota returns how many downloads are left and when the quota will be reset,
+// as reported by the last download. remaining is -1 if there was none
+func (o *OpenSubtitlesCom) Quota() (remaining int, resetTime string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.remaining, o.resetTime
+}
+
+func (o *OpenSubtitlesCom) SetConfig(name, value string) error {
+	switch name {
+	case "apikey":
+		o.apiKey = value
+	case "username":
+		o.username = value
+	case "password":
+		o.password = value
+	case "url":
+		o.url = strings.TrimSuffix(value, "/")
+	default:
+		return fmt.Errorf(`option "%s" was not found`, name)
+	}
+
+	return nil
+}
+
+// Initialize logs in, if there are credentials. Without them, the
+// API key is enough to search and download a few subtitles
+func (o *OpenSubtitlesCom) Initialize() error {
+	if o.apiKey == "" {
+		return fmt.Errorf(`%w: an API key is required (set "%s.apikey")`, sublime.ErrNotConfigured, name)
+	}
+	if o.username == "" {
+		return nil
+	}
+
+	var res struct {
+		Token   string `json:"token"`
+		BaseURL string `json:"base_url"`
+		User    struct {
+			AllowedDownloads int `json:"allowed_downloads"`
+		} `json:"user"`
+	}
+	credentials := map[string]string{"username": o.username, "password": o.password}
+	if err := o.call(context.Background(), http.MethodPost, "/login", credentials, &res); err != nil {
+		return err
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.token = res.Token
+	o.remaining = res.User.AllowedDownloads
+	// Users may be assigned to another server, like the one for VIPs
+	if res.BaseURL != "" {
+		if u, err := url.Parse(o.url); err == nil && u.Host != res.BaseURL {
+			u.Host = res.BaseURL
+			o.url = u.String()
+		}
+	}
+
+	return nil
+}
+
+func (s OpenSubtitlesComSubtitle) GetFormatExtension() string {
+	// The download endpoint returns srt by default
+	return "srt"
+}
+
+func (s OpenSubtitlesComSubtitle) GetService() string {
+	return name
+}
+
+func (s OpenSubtitlesComSubtitle) GetRanking() float32 {
+	return float32(s.attr.DownloadCount)
+}
+
+func (s OpenSubtitlesComSubtitle) GetFileTarget() *sublime.FileTarget {
+	return s.t
+}
+
+func (s OpenSubtitlesComSubtitle) GetLang() language.Tag {
+	return s.lang
+}
+
+func (s OpenSubtitlesComSubtitle) MatchesHash() bool {
+	return s.attr.MoviehashMatch
+}
+
+// HearingImpaired returns wether the subtitle describes sounds for the hearing impaired
+func (s OpenSubtitlesComSubtitle) HearingImpaired() bool {
+	return s.attr.HearingImpaired
+}
+
+func (s OpenSubtitlesComSubtitle) GetInfo() guessit.Information {
+	return s.GetDetails().Information
+}
+
+func (s OpenSubtitlesComSubtitle) GetDetails() guessit.Details {
+	release := s.attr.Release
+	if release == "" {
+		release = strings.TrimSuffix(s.file.FileName, filepath.Ext(s.file.FileName))
+	}
+
+	info := guessit.ParseDetailed(release)
+	details := s.attr.FeatureDetails
+	if info.Season == 0 {
+		info.Season = details.SeasonNumber
+	}
+	if info.Episode == 0 {
+		info.Episode = details.EpisodeNumber
+	}
+	if info.Year == 0 {
+		info.Year = details.Year
+	}
+	return info
+}
+
+func (s OpenSubtitlesComSubtitle) Open() (io.ReadCloser, error) {
+	return s.OpenContext(context.Background())
+}
+
+func (s OpenSubtitlesComSubtitle) OpenContext(ctx context.Context) (io.ReadCloser, error) {
+	link, err := s.o.download(ctx, s.file.FileID)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	if err := sublime.WaitRequest(ctx); err != nil {
+		return nil, err
+	}
+	res, err := s.o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, sublime.ResponseError(res, "download failed")
+	}
+
+	return res.Body, nil
+}
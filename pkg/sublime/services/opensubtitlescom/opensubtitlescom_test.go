@@ -0,0 +1,205 @@
+package opensubtitlescom
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PietroCarrara/sublime/pkg/sublime"
+	"golang.org/x/text/language"
+)
+
+const apiKey = "test-key"
+
+// newTestServer stands in for the API. It allows quota downloads, and
+// records the query of every search
+func newTestServer(t *testing.T, quota int, queries *[]string) *httptest.Server {
+	var lock sync.Mutex
+	var server *httptest.Server
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Download links are served without the key
+		if !strings.HasPrefix(r.URL.Path, "/files/") && r.Header.Get("Api-Key") != apiKey {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message": "You cannot consume this service"}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/v1/login":
+			var credentials map[string]string
+			json.NewDecoder(r.Body).Decode(&credentials)
+			if credentials["username"] != "user" || credentials["password"] != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"message": "Error, invalid username/password", "status": 401}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"user":     map[string]interface{}{"allowed_downloads": quota},
+				"base_url": strings.TrimPrefix(server.URL, "http://"),
+				"token":    "secret-token",
+				"status":   200,
+			})
+
+		case "/api/v1/subtitles":
+			lock.Lock()
+			*queries = append(*queries, r.URL.RawQuery)
+			lock.Unlock()
+
+			data, err := ioutil.ReadFile(filepath.Join("testdata", "search.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(data)
+
+		case "/api/v1/download":
+			if r.Header.Get("Authorization") != "Bearer secret-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			lock.Lock()
+			quota--
+			remaining := quota
+			lock.Unlock()
+
+			if remaining < 0 {
+				w.WriteHeader(http.StatusNotAcceptable)
+				w.Write([]byte(`{"requests": 6, "remaining": -1, "message": "You have downloaded your allowed 5 subtitles for 24h", "reset_time_utc": "2022-01-02T00:00:00.000Z"}`))
+				return
+			}
+
+			var body struct {
+				FileID int `json:"file_id"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"link":           server.URL + "/files/" + strings.Repeat("x", body.FileID%7),
+				"remaining":      remaining,
+				"reset_time_utc": "2022-01-02T00:00:00.000Z",
+			})
+
+		default:
+			if strings.HasPrefix(r.URL.Path, "/files/") {
+				w.Write([]byte("1\n00:00:01,000 --> 00:00:02,000\nHello, friend.\n"))
+				return
+			}
+			http.NotFound(w, r)
+		}
+	}))
+	return server
+}
+
+func newTestService(t *testing.T, server *httptest.Server) *OpenSubtitlesCom {
+	o := New()
+	o.SetConfig("url", server.URL+"/api/v1")
+	o.SetConfig("apikey", apiKey)
+	o.SetConfig("username", "user")
+	o.SetConfig("password", "pass")
+	if err := o.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	var queries []string
+	server := newTestServer(t, 5, &queries)
+	defer server.Close()
+	o := newTestService(t, server)
+
+	// A file large enough to be hashed, with an .nfo pointing to IMDb
+	dir, err := ioutil.TempDir("", "sublime-opensubtitlescom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	video := filepath.Join(dir, "Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.mkv")
+	ioutil.WriteFile(video, make([]byte, 256*1024), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "Mr.Robot.S02E01.720p.WEBRip.x264-KILLERS.nfo"), []byte("https://www.imdb.com/title/tt4158110/"), 0644)
+
+	files := []*sublime.FileTarget{
+		sublime.NewFileTarget(video),
+		sublime.NewFileTarget("Doctor.Who.2005.S01E01.mkv"),
+	}
+	langs := []language.Tag{language.English, language.BrazilianPortuguese}
+
+	var res []OpenSubtitlesComSubtitle
+	for c := range o.GetCandidates(context.Background(), files, langs) {
+		if c.Err != nil {
+			t.Fatal(c.Err)
+		}
+		res = append(res, c.Candidate.(OpenSubtitlesComSubtitle))
+	}
+
+	targetQueries := []string{
+		"episode_number=1&imdb_id=4158110&languages=en%2Cpt-br&moviehash=0000000000040000&page=1&season_number=2",
+		"episode_number=1&languages=en%2Cpt-br&page=1&query=doctor+who&season_number=1&year=2005",
+	}
+	for i, target := range targetQueries {
+		if i >= len(queries) || queries[i] != target {
+			t.Errorf(`Expected query %d to be "%s", but got %v`, i, target, queries)
+		}
+	}
+
+	// For each file, the fixture has 3 subtitles in the requested languages (one of them in 2
+	// parts) and a french one
+	if len(res) != 8 {
+		t.Fatalf(`Expected 8 candidates, but got %d`, len(res))
+	}
+	c := res[0]
+	info := c.GetInfo()
+	if !c.MatchesHash() || c.GetLang() != language.English || c.GetRanking() != 15321 || info.Group != "KILLERS" || info.Episode != 1 {
+		t.Errorf(`Expected a hash match in english by KILLERS, but got %+v`, c)
+	}
+	if c := res[1]; c.MatchesHash() || c.GetLang() != language.BrazilianPortuguese || !c.HearingImpaired() {
+		t.Errorf(`Expected a hearing impaired brazilian subtitle, but got %+v`, c)
+	}
+}
+
+func TestDownloadQuota(t *testing.T) {
+	t.Parallel()
+
+	var queries []string
+	server := newTestServer(t, 1, &queries)
+	defer server.Close()
+	o := newTestService(t, server)
+
+	var candidates []sublime.SubtitleCandidate
+	for c := range o.GetCandidates(context.Background(), []*sublime.FileTarget{sublime.NewFileTarget("Mr.Robot.S02E01.mkv")}, []language.Tag{language.English}) {
+		candidates = append(candidates, c.Candidate)
+	}
+	if len(candidates) < 2 {
+		t.Fatalf(`Expected at least 2 candidates, but got %d`, len(candidates))
+	}
+
+	stream, err := candidates[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(stream)
+	stream.Close()
+	if !strings.Contains(string(data), "Hello, friend.") {
+		t.Errorf(`Expected the subtitle to be downloaded, but got "%s"`, data)
+	}
+	if remaining, reset := o.Quota(); remaining != 0 || reset == "" {
+		t.Errorf(`Expected no downloads to be left, but got %d (reset at "%s")`, remaining, reset)
+	}
+
+	if _, err := candidates[1].Open(); err == nil || !strings.Contains(err.Error(), "quota") {
+		t.Errorf(`Expected a quota error, but got %v`, err)
+	}
+
+	if err := New().Initialize(); err == nil {
+		t.Errorf(`Expected an error without an API key`)
+	}
+}
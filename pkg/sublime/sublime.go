@@ -7,8 +7,12 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/PietroCarrara/sublime/pkg/container"
 	"github.com/PietroCarrara/sublime/pkg/guessit"
+	"github.com/PietroCarrara/sublime/pkg/subtitle"
 	"golang.org/x/text/language"
 )
 
@@ -27,6 +31,57 @@ type SubtitleCandidate interface {
 	Open() (io.ReadCloser, error) // Get a stream to the subtitle used for downloading
 }
 
+// HashMatcher is implemented by candidates that can tell wether
+// they were found by the movie hash of their FileTarget
+type HashMatcher interface {
+	MatchesHash() bool
+}
+
+// HearingImpaired is implemented by candidates that can tell
+// wether they were made for the hearing impaired
+type HearingImpaired interface {
+	HearingImpaired() bool
+}
+
+// PathCandidate is implemented by candidates that are files on disk
+type PathCandidate interface {
+	GetPath() string
+}
+
+// DetailedCandidate is implemented by candidates whose information was parsed
+// from a name, telling how sure the parse is of each field. See guessit.ParseDetailed
+type DetailedCandidate interface {
+	GetDetails() guessit.Details
+}
+
+// Decorators of candidates have every optional method, forwarding them with these.
+// Candidates that don't implement them are not matched by hash, not for the
+// hearing impaired, not on disk and their information is certain
+
+func matchesHash(c SubtitleCandidate) bool {
+	h, ok := c.(HashMatcher)
+	return ok && h.MatchesHash()
+}
+
+func hearingImpaired(c SubtitleCandidate) bool {
+	h, ok := c.(HearingImpaired)
+	return ok && h.HearingImpaired()
+}
+
+func candidatePath(c SubtitleCandidate) string {
+	if p, ok := c.(PathCandidate); ok {
+		return p.GetPath()
+	}
+	return ""
+}
+
+func candidateDetails(c SubtitleCandidate) guessit.Details {
+	if d, ok := c.(DetailedCandidate); ok {
+		return d.GetDetails()
+	}
+	return guessit.Details{Information: c.GetInfo()}
+}
+
 // Service knows how to get candidates for FileTargets and Languages
 type Service interface {
 	// Returns a string identifying this service. Should be all lowercase
@@ -42,13 +97,33 @@ type Service interface {
 // FileTarget represents a video that contains information
 // and can be sutitled
 type FileTarget struct {
-	path string
+	path  string
+	hash  *fileHash
+	media *fileMedia
+}
+
+// fileHash lazily computes the size and hash of a file, only once
+type fileHash struct {
+	once    sync.Once
+	size    int64
+	sizeErr error
+	hash    uint64
+	hashErr error
+}
+
+// fileMedia lazily reads the container metadata of a file, only once
+type fileMedia struct {
+	once sync.Once
+	info *container.Info
+	err  error
 }
 
 // NewFileTarget creates a new FileTarget
 func NewFileTarget(path string) *FileTarget {
 	return &FileTarget{
-		path: path,
+		path:  path,
+		hash:  &fileHash{},
+		media: &fileMedia{},
 	}
 }
 
@@ -58,25 +133,154 @@ func (f FileTarget) GetName() string {
 	return name
 }
 
-// GetInfo tries to extract information from a file
+// GetInfo tries to extract information from a file, by its name and the directories it is in
 func (f FileTarget) GetInfo() guessit.Information {
-	return guessit.Parse(f.GetName())
+	return guessit.ParsePath(f.path)
 }
 
-// SaveSubtitle saves a subtitle next to the video file
-func (f FileTarget) SaveSubtitle(r io.Reader, lang string, format string) error {
+// GetDetails extracts information from a file like GetInfo, telling how sure it is of each field
+func (f FileTarget) GetDetails() guessit.Details {
+	return guessit.ParsePathDetailed(f.path)
+}
+
+// GetPath returns the path of the file
+func (f FileTarget) GetPath() string {
+	return f.path
+}
+
+// GetSize returns the size of the file in bytes
+func (f FileTarget) GetSize() (int64, error) {
+	f.computeHash()
+	return f.hash.size, f.hash.sizeErr
+}
+
+// GetHash returns the OpenSubtitles movie hash of the file. See MovieHash
+func (f FileTarget) GetHash() (uint64, error) {
+	f.computeHash()
+	if f.hash.sizeErr != nil {
+		return 0, f.hash.sizeErr
+	}
+	return f.hash.hash, f.hash.hashErr
+}
+
+func (f FileTarget) computeHash() {
+	f.hash.once.Do(func() {
+		file, err := os.Open(f.path)
+		if err != nil {
+			f.hash.sizeErr = err
+			return
+		}
+		defer file.Close()
+
+		stat, err := file.Stat()
+		if err != nil {
+			f.hash.sizeErr = err
+			return
+		}
+
+		f.hash.size = stat.Size()
+		f.hash.hash, f.hash.hashErr = MovieHash(file, f.hash.size)
+	})
+}
+
+// GetMedia returns the metadata stored in the file's container. Only Matroska
+// and MP4 files are supported; others return container.ErrUnsupported
+func (f FileTarget) GetMedia() (*container.Info, error) {
+	f.media.once.Do(func() {
+		f.media.info, f.media.err = container.ProbeFile(f.path)
+	})
+	return f.media.info, f.media.err
+}
+
+// GetDuration returns the duration of the video. 0 if unknown
+func (f FileTarget) GetDuration() time.Duration {
+	if info, err := f.GetMedia(); err == nil {
+		return info.Duration
+	}
+	return 0
+}
+
+// GetFrameRate returns the frames per second of the video. 0 if unknown
+func (f FileTarget) GetFrameRate() float64 {
+	if info, err := f.GetMedia(); err == nil {
+		return info.FrameRate()
+	}
+	return 0
+}
+
+// GetTitle returns the title stored in the file's container. "" if there's none
+func (f FileTarget) GetTitle() string {
+	if info, err := f.GetMedia(); err == nil {
+		return info.Title
+	}
+	return ""
+}
+
+// GetAudioLanguages returns the languages of the audio tracks of the video
+func (f FileTarget) GetAudioLanguages() []language.Tag {
+	if info, err := f.GetMedia(); err == nil {
+		return info.Languages(container.AudioTrack)
+	}
+	return nil
+}
+
+// GetSubtitleLanguages returns the languages of the subtitle tracks embedded in the video
+func (f FileTarget) GetSubtitleLanguages() []language.Tag {
+	if info, err := f.GetMedia(); err == nil {
+		return info.Languages(container.SubtitleTrack)
+	}
+	return nil
+}
+
+// SubtitlePath returns the path of a subtitle next to the video file,
+// in the form "<video name>.<lang>.<format>"
+func (f FileTarget) SubtitlePath(lang string, format string) string {
 	name := strings.TrimSuffix(f.path, filepath.Ext(f.path))
-	name = fmt.Sprintf("%s.%s.%s", name, lang, format)
+	return fmt.Sprintf("%s.%s.%s", name, lang, format)
+}
 
-	file, err := os.Create(name)
+// Sidecars returns the paths of the subtitle files next to the video
+// whose names start with the video's name
+func (f FileTarget) Sidecars() []string {
+	base := strings.TrimSuffix(f.path, filepath.Ext(f.path))
+	files, err := filepath.Glob(globEscape(base) + ".*")
 	if err != nil {
 		return nil
 	}
 
-	_, err = io.Copy(file, r)
-	return err
+	var res []string
+	for _, file := range files {
+		if _, err := subtitle.FormatFromExtension(filepath.Ext(file)); err == nil {
+			res = append(res, file)
+		}
+	}
+	return res
+}
+
+// SaveSubtitle saves a subtitle next to the video file
+func (f FileTarget) SaveSubtitle(r io.Reader, lang string, format string) error {
+	return saveFile(f.SubtitlePath(lang, format), r)
 }
 
 func (f FileTarget) String() string {
 	return f.path
 }
+
+// saveFile writes the contents of r into a file at path
+func saveFile(path string, r io.Reader) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// globEscape escapes the characters that have a special meaning in filepath.Match
+func globEscape(path string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`).Replace(path)
+}
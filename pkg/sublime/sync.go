@@ -0,0 +1,147 @@
+package sublime
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/PietroCarrara/sublime/pkg/container"
+	"github.com/PietroCarrara/sublime/pkg/subtitle"
+	"golang.org/x/text/language"
+)
+
+// MinSyncConfidence is the minimum fraction of cues that must match the
+// reference for a subtitle to be synced. See subtitle.Alignment.Confidence
+const MinSyncConfidence = 0.3
+
+// ErrSyncConfidence is returned when a subtitle doesn't match its reference well enough to be synced
+var ErrSyncConfidence = errors.New("subtitle doesn't match the reference")
+
+// LoadSubtitle reads and parses a subtitle file in any encoding
+func LoadSubtitle(path string) (*subtitle.Subtitle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = subtitle.ToUTF8(data, language.Und)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := parseSubtitle(data, filepath.Ext(path))
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// SyncSubtitle aligns the timings of UTF-8 subtitle data to reference, keeping its format.
+// ext is the format reported by the candidate, used if the contents don't give it away.
+// If the alignment isn't reliable, ErrSyncConfidence is returned along with it
+func SyncSubtitle(data []byte, ext string, reference *subtitle.Subtitle) ([]byte, subtitle.Alignment, error) {
+	sub, err := parseSubtitle(data, ext)
+	if err != nil {
+		return nil, subtitle.Alignment{}, err
+	}
+
+	alignment := subtitle.Align(reference, sub)
+	if alignment.Confidence() < MinSyncConfidence {
+		return nil, alignment, ErrSyncConfidence
+	}
+	alignment.Apply(sub)
+
+	var buf bytes.Buffer
+	if err := subtitle.Write(&buf, sub, sub.Format); err != nil {
+		return nil, alignment, err
+	}
+	return buf.Bytes(), alignment, nil
+}
+
+// referenceFinder looks for timing references of the files of a run. The sidecars of
+// each file are listed once, before anything is saved, so that subtitles saved in the
+// same run (maybe still half written) are never taken for a reference
+type referenceFinder struct {
+	sidecars map[*FileTarget][]string
+	embedded map[*FileTarget]*subtitle.Subtitle
+}
+
+func newReferenceFinder() *referenceFinder {
+	return &referenceFinder{
+		sidecars: make(map[*FileTarget][]string),
+		embedded: make(map[*FileTarget]*subtitle.Subtitle),
+	}
+}
+
+// find returns a subtitle that can be used as a timing reference for one that will
+// be saved in path: a subtitle next to the video or, if there is none, a text track
+// embedded in it. Returns nil if there is none
+func (r *referenceFinder) find(f *FileTarget, path string) *subtitle.Subtitle {
+	sidecars, ok := r.sidecars[f]
+	if !ok {
+		sidecars = f.Sidecars()
+		r.sidecars[f] = sidecars
+	}
+	for _, sidecar := range sidecars {
+		if sidecar == path {
+			continue
+		}
+		if sub, err := LoadSubtitle(sidecar); err == nil && len(sub.Cues) > 0 {
+			return sub
+		}
+	}
+
+	sub, ok := r.embedded[f]
+	if !ok {
+		sub = embeddedReference(f)
+		r.embedded[f] = sub
+	}
+	return sub
+}
+
+// embeddedReference reads the first text subtitle track of a video that isn't forced.
+// Returns nil if there is none, or if the container isn't supported
+func embeddedReference(f *FileTarget) *subtitle.Subtitle {
+	info, err := f.GetMedia()
+	if err != nil {
+		return nil
+	}
+
+	for _, track := range info.TracksOf(container.SubtitleTrack) {
+		// Forced tracks only translate a few lines
+		if track.Forced || !track.IsText() {
+			continue
+		}
+		cues, err := container.ReadTextTrackFile(f.GetPath(), track)
+		if err != nil || len(cues) == 0 {
+			continue
+		}
+
+		// Only the timings are used, so the markup is kept in the text
+		sub := &subtitle.Subtitle{Format: subtitle.SRT}
+		for _, cue := range cues {
+			sub.Cues = append(sub.Cues, &subtitle.Cue{
+				Start: cue.Start,
+				End:   cue.End,
+				Text:  []subtitle.Span{{Text: cue.Text}},
+			})
+		}
+		return sub
+	}
+	return nil
+}
+
+// parseSubtitle parses UTF-8 subtitle data, detecting its format
+// from the contents or, if that fails, from ext
+func parseSubtitle(data []byte, ext string) (*subtitle.Subtitle, error) {
+	format, err := subtitle.Detect(data)
+	if err != nil {
+		format, err = subtitle.FormatFromExtension(ext)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return subtitle.ParseFormat(bytes.NewReader(data), format)
+}
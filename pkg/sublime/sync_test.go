@@ -0,0 +1,45 @@
+package sublime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReferenceFinder(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "sublime-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	f := NewFileTarget(write("Movie.mkv", "not a video"))
+	write("Movie.en.srt", "1\n00:00:01,000 --> 00:00:02,000\nHello\n")
+
+	finder := newReferenceFinder()
+	if ref := finder.find(f, filepath.Join(dir, "Movie.en.srt")); ref != nil {
+		t.Errorf(`Expected the subtitle being replaced not to be a reference, but got %+v`, ref)
+	}
+
+	// Subtitles saved after the sidecars were listed are not references
+	saved := write("Movie.pt-BR.srt", "1\n00:00:05,000 --> 00:00:06,000\nOlá\n")
+	if ref := finder.find(f, filepath.Join(dir, "Movie.en.srt")); ref != nil {
+		t.Errorf(`Expected "%s" not to be a reference, but got %+v`, saved, ref)
+	}
+
+	ref := finder.find(f, saved)
+	if ref == nil || len(ref.Cues) != 1 || ref.Cues[0].PlainText() != "Hello" {
+		t.Errorf(`Expected "Movie.en.srt" to be the reference, but got %+v`, ref)
+	}
+}
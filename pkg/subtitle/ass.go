@@ -0,0 +1,401 @@
+package subtitle
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default field lists, used when a section has no "Format:" line and when writing
+var (
+	assStyleFields = []string{"Name", "Fontname", "Fontsize", "PrimaryColour", "SecondaryColour", "OutlineColour", "BackColour", "Bold", "Italic", "Underline", "StrikeOut", "ScaleX", "ScaleY", "Spacing", "Angle", "BorderStyle", "Outline", "Shadow", "Alignment", "MarginL", "MarginR", "MarginV", "Encoding"}
+	ssaStyleFields = []string{"Name", "Fontname", "Fontsize", "PrimaryColour", "SecondaryColour", "TertiaryColour", "BackColour", "Bold", "Italic", "BorderStyle", "Outline", "Shadow", "Alignment", "MarginL", "MarginR", "MarginV", "AlphaLevel", "Encoding"}
+	assEventFields = []string{"Layer", "Start", "End", "Style", "Name", "MarginL", "MarginR", "MarginV", "Effect", "Text"}
+	ssaEventFields = []string{"Marked", "Start", "End", "Style", "Name", "MarginL", "MarginR", "MarginV", "Effect", "Text"}
+)
+
+// Values of the default style, keyed by the lowercase field name
+var (
+	assStyleDefaults = map[string]string{
+		"name": "Default", "fontname": "Arial", "fontsize": "20",
+		"primarycolour": "&H00FFFFFF", "secondarycolour": "&H000000FF", "outlinecolour": "&H00000000", "backcolour": "&H00000000",
+		"bold": "0", "italic": "0", "underline": "0", "strikeout": "0",
+		"scalex": "100", "scaley": "100", "spacing": "0", "angle": "0",
+		"borderstyle": "1", "outline": "2", "shadow": "2", "alignment": "2",
+		"marginl": "10", "marginr": "10", "marginv": "10", "encoding": "1",
+	}
+	ssaStyleDefaults = map[string]string{
+		"name": "Default", "fontname": "Arial", "fontsize": "20",
+		"primarycolour": "16777215", "secondarycolour": "65535", "tertiarycolour": "65535", "backcolour": "0",
+		"bold": "0", "italic": "0",
+		"borderstyle": "1", "outline": "2", "shadow": "2", "alignment": "2",
+		"marginl": "10", "marginr": "10", "marginv": "10", "alphalevel": "0", "encoding": "1",
+	}
+)
+
+// parseASS parses an ASS or SSA subtitle
+func parseASS(text string) (*Subtitle, error) {
+	sub := &Subtitle{}
+
+	section := ""
+	legacy := false
+	var extra *Section
+	styleFields := lower(assStyleFields)
+	eventFields := lower(assEventFields)
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(line[1 : len(line)-1])
+			extra = nil
+			switch section {
+			case "v4 styles":
+				legacy = true
+				styleFields = lower(ssaStyleFields)
+				eventFields = lower(ssaEventFields)
+			case "script info", "v4+ styles", "events":
+			default:
+				sub.Extra = append(sub.Extra, Section{Name: line[1 : len(line)-1]})
+				extra = &sub.Extra[len(sub.Extra)-1]
+			}
+			continue
+		}
+
+		if extra != nil {
+			extra.Lines = append(extra.Lines, line)
+			continue
+		}
+
+		key, value := splitKeyValue(line)
+		switch section {
+		case "script info":
+			if strings.HasPrefix(line, ";") || key == "" {
+				continue
+			}
+			sub.Info = append(sub.Info, Property{Key: key, Value: value})
+
+		case "v4 styles", "v4+ styles":
+			switch strings.ToLower(key) {
+			case "format":
+				styleFields = splitFormat(value)
+			case "style":
+				sub.Styles = append(sub.Styles, parseASSStyle(styleFields, value, legacy))
+			}
+
+		case "events":
+			switch strings.ToLower(key) {
+			case "format":
+				eventFields = splitFormat(value)
+			case "dialogue", "comment":
+				cue, err := parseASSEvent(eventFields, value)
+				if err != nil {
+					return nil, err
+				}
+				cue.Comment = strings.EqualFold(key, "comment")
+				sub.Cues = append(sub.Cues, cue)
+			}
+		}
+	}
+
+	if len(sub.Cues) == 0 && len(sub.Styles) == 0 {
+		return nil, errors.New("no styles or events found")
+	}
+
+	return sub, nil
+}
+
+func parseASSStyle(fields []string, value string, legacy bool) *Style {
+	values := splitFields(value, len(fields))
+	style := &Style{
+		Fields: make(map[string]string, len(fields)),
+	}
+	for i, f := range fields {
+		if i < len(values) {
+			style.Fields[f] = values[i]
+		}
+	}
+
+	flag := func(name string) bool {
+		v := style.Fields[name]
+		return v != "" && v != "0"
+	}
+
+	style.Name = style.Fields["name"]
+	style.FontName = style.Fields["fontname"]
+	style.FontSize, _ = strconv.ParseFloat(style.Fields["fontsize"], 64)
+	style.PrimaryColor = parseASSColor(style.Fields["primarycolour"])
+	style.Bold = flag("bold")
+	style.Italic = flag("italic")
+	style.Underline = flag("underline")
+	style.StrikeOut = flag("strikeout")
+	style.Alignment, _ = strconv.Atoi(style.Fields["alignment"])
+	if legacy {
+		style.Alignment = fromSSAAlignment(style.Alignment)
+	}
+
+	return style
+}
+
+func parseASSEvent(fields []string, value string) (*Cue, error) {
+	values := splitFields(value, len(fields))
+	if len(values) != len(fields) {
+		return nil, fmt.Errorf(`invalid event "%s"`, value)
+	}
+
+	cue := &Cue{}
+	for i, f := range fields {
+		v := values[i]
+		var err error
+		switch f {
+		case "layer":
+			cue.Layer, _ = strconv.Atoi(v)
+		case "start":
+			cue.Start, err = assDuration(v)
+		case "end":
+			cue.End, err = assDuration(v)
+		case "style":
+			cue.Style = strings.TrimPrefix(v, "*")
+		case "name":
+			cue.Name = v
+		case "marginl":
+			cue.MarginL, _ = strconv.Atoi(v)
+		case "marginr":
+			cue.MarginR, _ = strconv.Atoi(v)
+		case "marginv":
+			cue.MarginV, _ = strconv.Atoi(v)
+		case "effect":
+			cue.Effect = v
+		case "text":
+			cue.Raw = v
+			cue.Text = parseASSText(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cue, nil
+}
+
+// assDuration parses a timestamp in the form "h:mm:ss.cc"
+func assDuration(str string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(str), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf(`invalid timestamp "%s"`, str)
+	}
+
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf(`invalid timestamp "%s"`, str)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf(`invalid timestamp "%s"`, str)
+	}
+	s, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid timestamp "%s"`, str)
+	}
+
+	return time.Duration(h)*time.Hour +
+		time.Duration(m)*time.Minute +
+		time.Duration(s*1000+0.5)*time.Millisecond, nil
+}
+
+func writeASS(w io.Writer, s *Subtitle, format Format) error {
+	b := bufio.NewWriter(w)
+
+	styleFields, eventFields, defaults := assStyleFields, assEventFields, assStyleDefaults
+	scriptType, stylesSection := "v4.00+", "V4+ Styles"
+	if format == SSA {
+		styleFields, eventFields, defaults = ssaStyleFields, ssaEventFields, ssaStyleDefaults
+		scriptType, stylesSection = "v4.00", "V4 Styles"
+	}
+	fromASS := s.Format == ASS || s.Format == SSA
+
+	b.WriteString("[Script Info]\n")
+	fmt.Fprintf(b, "ScriptType: %s\n", scriptType)
+	if fromASS {
+		for _, p := range s.Info {
+			if !strings.EqualFold(p.Key, "ScriptType") {
+				fmt.Fprintf(b, "%s: %s\n", p.Key, p.Value)
+			}
+		}
+	}
+
+	fmt.Fprintf(b, "\n[%s]\n", stylesSection)
+	fmt.Fprintf(b, "Format: %s\n", strings.Join(styleFields, ", "))
+	styles := s.Styles
+	if len(styles) == 0 {
+		styles = []*Style{{Name: "Default"}}
+	}
+	for _, style := range styles {
+		values := make([]string, len(styleFields))
+		for i, f := range styleFields {
+			values[i] = assStyleValue(style, strings.ToLower(f), format, defaults)
+		}
+		fmt.Fprintf(b, "Style: %s\n", strings.Join(values, ","))
+	}
+
+	b.WriteString("\n[Events]\n")
+	fmt.Fprintf(b, "Format: %s\n", strings.Join(eventFields, ", "))
+	for _, c := range s.Cues {
+		style := c.Style
+		if style == "" {
+			style = styles[0].Name
+		}
+		first := strconv.Itoa(c.Layer)
+		if format == SSA {
+			first = "Marked=0"
+		}
+		text := rawText(s, c, format, renderASSText)
+
+		kind := "Dialogue"
+		if c.Comment {
+			kind = "Comment"
+		}
+		fmt.Fprintf(b, "%s: %s,%s,%s,%s,%s,%d,%d,%d,%s,%s\n", kind, first,
+			formatASSTime(c.Start), formatASSTime(c.End), style, c.Name,
+			c.MarginL, c.MarginR, c.MarginV, c.Effect, strings.ReplaceAll(text, "\n", `\N`))
+	}
+
+	if fromASS {
+		for _, section := range s.Extra {
+			fmt.Fprintf(b, "\n[%s]\n%s\n", section.Name, strings.Join(section.Lines, "\n"))
+		}
+	}
+
+	return b.Flush()
+}
+
+// assStyleValue returns the value of a style field in the given format
+func assStyleValue(style *Style, field string, format Format, defaults map[string]string) string {
+	boolean := func(b bool) string {
+		if b {
+			return "-1"
+		}
+		return "0"
+	}
+
+	switch field {
+	case "name":
+		return style.Name
+	case "fontname":
+		if style.FontName != "" {
+			return style.FontName
+		}
+	case "fontsize":
+		if style.FontSize > 0 {
+			return strconv.FormatFloat(style.FontSize, 'f', -1, 64)
+		}
+	case "primarycolour":
+		if style.PrimaryColor != "" {
+			// Keep the original value if it still holds the same color, as it may have transparency
+			if raw, ok := style.Fields[field]; ok && parseASSColor(raw) == style.PrimaryColor && isNumber(raw) == (format == SSA) {
+				return raw
+			}
+			color := formatASSColor(style.PrimaryColor)
+			if format == SSA {
+				n, _ := strconv.ParseUint(strings.Trim(color, "&H"), 16, 32)
+				return strconv.FormatUint(n, 10)
+			}
+			return strings.TrimSuffix(strings.Replace(color, "&H", "&H00", 1), "&")
+		}
+	case "bold":
+		return boolean(style.Bold)
+	case "italic":
+		return boolean(style.Italic)
+	case "underline":
+		return boolean(style.Underline)
+	case "strikeout":
+		return boolean(style.StrikeOut)
+	case "alignment":
+		if style.Alignment > 0 {
+			if format == SSA {
+				return strconv.Itoa(toSSAAlignment(style.Alignment))
+			}
+			return strconv.Itoa(style.Alignment)
+		}
+	default:
+		if raw, ok := style.Fields[field]; ok {
+			// Colors are written in a different notation by each format
+			if !strings.HasSuffix(field, "colour") || isNumber(raw) == (format == SSA) {
+				return raw
+			}
+		}
+	}
+
+	return defaults[field]
+}
+
+func formatASSTime(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", h, m, s, ms/10)
+}
+
+// fromSSAAlignment converts the SSA alignment (1-3 bottom, 5-7 top, 9-11 middle) to the numpad layout
+func fromSSAAlignment(a int) int {
+	switch {
+	case a >= 5 && a <= 7:
+		return a + 2
+	case a >= 9 && a <= 11:
+		return a - 5
+	default:
+		return a
+	}
+}
+
+// toSSAAlignment converts a numpad alignment to the SSA layout
+func toSSAAlignment(a int) int {
+	switch {
+	case a >= 7 && a <= 9:
+		return a - 2
+	case a >= 4 && a <= 6:
+		return a + 5
+	default:
+		return a
+	}
+}
+
+// splitKeyValue splits a line in the form "Key: Value"
+func splitKeyValue(line string) (key, value string) {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// splitFormat splits a "Format:" line into lowercase field names
+func splitFormat(value string) []string {
+	fields := strings.Split(value, ",")
+	for i, f := range fields {
+		fields[i] = strings.ToLower(strings.TrimSpace(f))
+	}
+	return fields
+}
+
+// splitFields splits n comma-separated values. The last value may contain commas
+func splitFields(value string, n int) []string {
+	values := strings.SplitN(value, ",", n)
+	for i := 0; i < len(values)-1; i++ {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+func lower(fields []string) []string {
+	res := make([]string, len(fields))
+	for i, f := range fields {
+		res[i] = strings.ToLower(f)
+	}
+	return res
+}
@@ -0,0 +1,206 @@
+package subtitle
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	xunicode "golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/language"
+	"golang.org/x/text/transform"
+)
+
+// Byte order marks
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// legacyEncodings are the single-byte encodings subtitles are commonly found in,
+// tried in this order when the language doesn't suggest a better one
+var legacyEncodings = []encoding.Encoding{
+	charmap.Windows1252,
+	charmap.Windows1250,
+	charmap.ISO8859_2,
+	charmap.Windows1251,
+	charmap.KOI8R,
+	charmap.Windows1253,
+	charmap.Windows1254,
+	charmap.Windows1255,
+	charmap.Windows1256,
+}
+
+// languageLetters are the non-ASCII letters expected in texts of a language
+var languageLetters = map[string]string{
+	"pt": "áàâãçéêíóôõúü",
+	"es": "áéíñóúü",
+	"fr": "àâæçéèêëîïôœùûüÿ",
+	"it": "àèéìíîòóùú",
+	"de": "äöüß",
+	"nl": "ëïéèöü",
+	"ca": "àçèéíïòóúü",
+	"sv": "åäöé",
+	"no": "åæøé",
+	"nb": "åæøé",
+	"da": "åæøé",
+	"fi": "åäö",
+	"is": "áðéíóúýþæö",
+	"pl": "ąćęłńóśźż",
+	"cs": "áčďéěíňóřšťúůýž",
+	"sk": "áäčďéíĺľňóôŕšťúýž",
+	"sl": "čšž",
+	"hr": "čćđšž",
+	"bs": "čćđšž",
+	"sr": "čćđšžабвгдђежзијклљмнњопрстћуфхцчџш",
+	"hu": "áéíóöőúüű",
+	"ro": "ăâîșțşţ",
+	"tr": "çğıöşü",
+	"ru": "абвгдеёжзийклмнопрстуфхцчшщъыьэюя",
+	"uk": "абвгґдеєжзиіїйклмнопрстуфхцчшщьюя",
+	"bg": "абвгдежзийклмнопрстуфхцчшщъьюя",
+	"mk": "абвгдѓежзѕијклљмнњопрстќуфхцчџш",
+	"be": "абвгдеёжзійклмнопрстуўфхцчшыьэюя",
+	"el": "αβγδεζηθικλμνξοπρστυφχψωάέήίόύώςϊϋΐΰ",
+	"he": "אבגדהוזחטיכךלמםנןסעפףצץקרשת",
+	"ar": "ابتثجحخدذرزسشصضطظعغفقكلمنهويءآأؤإئةى",
+	"fa": "ابپتثجچحخدذرزژسشصضطظعغفقکگلمنوهیآ",
+}
+
+// DetectEncoding guesses the character encoding of a subtitle. Byte order marks and valid
+// UTF-8 are recognized first; otherwise, the single-byte encodings are compared by how
+// plausible the text they produce is for lang (the language of the subtitle)
+func DetectEncoding(data []byte, lang language.Tag) encoding.Encoding {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return xunicode.UTF8
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return xunicode.UTF16(xunicode.LittleEndian, xunicode.ExpectBOM)
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return xunicode.UTF16(xunicode.BigEndian, xunicode.ExpectBOM)
+	case utf8.Valid(data):
+		return xunicode.UTF8
+	}
+
+	if enc := detectUTF16(data); enc != nil {
+		return enc
+	}
+
+	base, _ := lang.Base()
+	letters := languageLetters[base.String()]
+
+	candidates := legacyEncodings
+	if enc, err := htmlindex.Get(htmlindex.LanguageDefault(lang)); err == nil {
+		candidates = append([]encoding.Encoding{enc}, candidates...)
+	}
+
+	var best encoding.Encoding
+	bestScore := 0
+	for _, enc := range candidates {
+		decoded, err := enc.NewDecoder().Bytes(data)
+		if err != nil {
+			continue
+		}
+
+		// Candidates are sorted by preference, so ties keep the first one
+		score := plausibility(string(decoded), letters)
+		if best == nil || score > bestScore {
+			best = enc
+			bestScore = score
+		}
+	}
+
+	if best == nil {
+		return charmap.Windows1252
+	}
+	return best
+}
+
+// detectUTF16 recognizes UTF-16 without a byte order mark by the zero bytes
+// ASCII characters leave at every other position. nil if it isn't UTF-16
+func detectUTF16(data []byte) encoding.Encoding {
+	if len(data) < 16 || len(data)%2 != 0 {
+		return nil
+	}
+
+	even, odd := 0, 0
+	for i := 0; i < len(data); i += 2 {
+		if data[i] == 0 {
+			even++
+		}
+		if data[i+1] == 0 {
+			odd++
+		}
+	}
+
+	half := len(data) / 2
+	switch {
+	case odd > half*3/10 && even < half/20:
+		return xunicode.UTF16(xunicode.LittleEndian, xunicode.IgnoreBOM)
+	case even > half*3/10 && odd < half/20:
+		return xunicode.UTF16(xunicode.BigEndian, xunicode.IgnoreBOM)
+	default:
+		return nil
+	}
+}
+
+// plausibility scores how likely text is to be correctly decoded, given
+// the letters expected in its language. Only non-ASCII runes are considered
+func plausibility(text string, letters string) int {
+	score := 0
+	for _, r := range text {
+		switch {
+		case r < utf8.RuneSelf:
+			continue
+		case strings.ContainsRune(letters, unicode.ToLower(r)):
+			score += 3
+		case unicode.IsLetter(r):
+			score++
+		case strings.ContainsRune("…‘’“”–—«»¿¡°ºª€ ", r):
+			score++
+		default:
+			score -= 3
+		}
+	}
+	return score
+}
+
+// Decode converts data in the given encoding to UTF-8, removing any byte order mark
+func Decode(data []byte, enc encoding.Encoding) ([]byte, error) {
+	decoded, _, err := transform.Bytes(xunicode.BOMOverride(enc.NewDecoder()), data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimPrefix(decoded, bomUTF8), nil
+}
+
+// Encode converts UTF-8 data to the given encoding. Characters
+// the encoding can't represent are replaced
+func Encode(data []byte, enc encoding.Encoding) ([]byte, error) {
+	if enc == xunicode.UTF8 {
+		return data, nil
+	}
+	return encoding.ReplaceUnsupported(enc.NewEncoder()).Bytes(data)
+}
+
+// ToUTF8 detects the encoding of data and converts it to UTF-8. See DetectEncoding
+func ToUTF8(data []byte, lang language.Tag) ([]byte, error) {
+	return Decode(data, DetectEncoding(data, lang))
+}
+
+// EncodingName returns the name of an encoding, as used by HTML
+func EncodingName(enc encoding.Encoding) string {
+	if name, err := htmlindex.Name(enc); err == nil {
+		return name
+	}
+	return "unknown"
+}
+
+// GetEncoding returns the encoding with the given name (like "utf-8" or "windows-1252")
+func GetEncoding(name string) (encoding.Encoding, error) {
+	return htmlindex.Get(name)
+}
@@ -0,0 +1,61 @@
+package subtitle
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	xunicode "golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/language"
+)
+
+var charsetTestCases = []struct {
+	text string
+	lang language.Tag
+	enc  encoding.Encoding
+}{
+	{"Não, você não vai sair daqui até amanhã.\nAção!", language.BrazilianPortuguese, charmap.Windows1252},
+	{"¿Qué pasó? ¡Mañana será otro día, señor!", language.Spanish, charmap.Windows1252},
+	{"Příliš žluťoučký kůň úpěl ďábelské ódy.", language.Czech, charmap.ISO8859_2},
+	{"Zażółć gęślą jaźń, proszę pana.", language.Polish, charmap.ISO8859_2},
+	{"Привет, как дела? Всё хорошо, спасибо.", language.Russian, charmap.Windows1251},
+	{"Привет, как дела? Всё хорошо, спасибо.", language.Russian, charmap.KOI8R},
+	{"Γειά σου κόσμε, τι κάνεις;", language.Greek, charmap.Windows1253},
+	{"Çok güzel bir gün, değil mi? Işık!", language.Turkish, charmap.Windows1254},
+	{"Já estou em UTF-8, obrigado.", language.BrazilianPortuguese, xunicode.UTF8},
+	{"Hello, plain ASCII", language.English, xunicode.UTF8},
+	{"Com BOM em UTF-16: ação", language.BrazilianPortuguese, xunicode.UTF16(xunicode.LittleEndian, xunicode.UseBOM)},
+	{"Sem BOM em UTF-16: ação", language.BrazilianPortuguese, xunicode.UTF16(xunicode.LittleEndian, xunicode.IgnoreBOM)},
+}
+
+func TestToUTF8(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range charsetTestCases {
+		data, err := c.enc.NewEncoder().Bytes([]byte(c.text))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value, err := ToUTF8(data, c.lang)
+		if err != nil {
+			t.Errorf(`(case: "%s") %s`, c.text, err)
+			continue
+		}
+		if string(value) != c.text {
+			t.Errorf(`(case: "%s" in %s) Expected %#v, but got %#v (detected %s)`, c.text, EncodingName(c.enc), c.text, string(value), EncodingName(DetectEncoding(data, c.lang)))
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	value, err := Encode([]byte("ação ☃"), charmap.Windows1252)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "a\xe7\xe3o \x1a" {
+		t.Errorf(`Expected %#v, but got %#v`, "a\xe7\xe3o \x1a", string(value))
+	}
+}
@@ -0,0 +1,175 @@
+package subtitle
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Convert returns a copy of s in the given format. What the format can't represent is
+// simplified: ASS styles are applied to the text as inline tags, alignments are mapped
+// between ASS override tags and WebVTT cue settings, and everything else is dropped
+func Convert(s *Subtitle, format Format) *Subtitle {
+	if sameFamily(s.Format, format) {
+		res := *s
+		res.Format = format
+		return &res
+	}
+
+	res := &Subtitle{
+		Format: format,
+		Cues:   make([]*Cue, 0, len(s.Cues)),
+	}
+
+	for _, c := range s.Cues {
+		if c.Comment {
+			continue
+		}
+
+		cue := &Cue{
+			Start: c.Start,
+			End:   c.End,
+			Name:  c.Name,
+			Text:  c.Text,
+		}
+
+		var style *Style
+		if s.Format == ASS || s.Format == SSA {
+			style = s.GetStyle(c.Style)
+			if style == nil && len(s.Styles) > 0 {
+				style = s.Styles[0]
+			}
+			cue.Text = applyStyle(c.Text, style)
+		}
+		alignment := cueAlignment(s, c, style)
+
+		switch format {
+		case SRT:
+			cue.Raw = alignmentTag(alignment) + renderHTMLText(cue.Text, false)
+		case VTT:
+			cue.ID = c.ID
+			cue.Settings = vttSettings(alignment)
+		case ASS, SSA:
+			cue.Raw = alignmentTag(alignment) + renderASSText(cue.Text)
+		}
+
+		res.Cues = append(res.Cues, cue)
+	}
+
+	return res
+}
+
+// applyStyle applies the attributes of an ASS style to spans
+func applyStyle(spans []Span, style *Style) []Span {
+	if style == nil {
+		return spans
+	}
+
+	res := make([]Span, 0, len(spans))
+	for _, s := range spans {
+		s.Bold = s.Bold || style.Bold
+		s.Italic = s.Italic || style.Italic
+		s.Underline = s.Underline || style.Underline
+		s.StrikeOut = s.StrikeOut || style.StrikeOut
+		// White is the default color of every player, so it's left out
+		if s.Color == "" && style.PrimaryColor != "#ffffff" {
+			s.Color = style.PrimaryColor
+		}
+		res = appendSpan(res, s)
+	}
+	return res
+}
+
+var reAlignmentTag = regexp.MustCompile(`\{[^}]*\\an([1-9])[^}]*\}`)
+
+// cueAlignment returns the alignment of a cue, laid out as a numeric keypad. 0 if unknown
+func cueAlignment(s *Subtitle, c *Cue, style *Style) int {
+	if m := reAlignmentTag.FindStringSubmatch(c.Raw); m != nil && s.Format != VTT {
+		a, _ := strconv.Atoi(m[1])
+		return a
+	}
+
+	switch s.Format {
+	case ASS, SSA:
+		if style != nil {
+			return style.Alignment
+		}
+	case VTT:
+		return vttAlignment(c.Settings)
+	}
+
+	return 0
+}
+
+// vttAlignment maps the "line" and "align" WebVTT cue settings to a numpad alignment
+func vttAlignment(settings string) int {
+	if settings == "" {
+		return 0
+	}
+
+	row, column := 1, 1
+	for _, setting := range strings.Fields(settings) {
+		parts := strings.SplitN(setting, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.SplitN(parts[1], ",", 2)[0]
+
+		switch parts[0] {
+		case "line":
+			if strings.HasSuffix(value, "%") {
+				percent, _ := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+				if percent < 33 {
+					row = 7
+				} else if percent < 66 {
+					row = 4
+				}
+			} else if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+				// Positive line numbers count from the top
+				row = 7
+			}
+		case "align":
+			switch value {
+			case "start", "left":
+				column = 0
+			case "end", "right":
+				column = 2
+			}
+		}
+	}
+
+	return row + column
+}
+
+// vttSettings maps a numpad alignment to WebVTT cue settings
+func vttSettings(alignment int) string {
+	if alignment <= 0 || alignment == 2 {
+		return ""
+	}
+
+	var settings []string
+	switch (alignment - 1) / 3 {
+	case 1:
+		settings = append(settings, "line:50%")
+	case 2:
+		settings = append(settings, "line:0")
+	}
+	switch (alignment - 1) % 3 {
+	case 0:
+		settings = append(settings, "align:start")
+	case 2:
+		settings = append(settings, "align:end")
+	}
+
+	return strings.Join(settings, " ")
+}
+
+// alignmentTag returns the override tag for an alignment, used by both ASS and SRT.
+// Bottom center is the default, so it has no tag
+func alignmentTag(alignment int) string {
+	if alignment <= 0 || alignment == 2 {
+		return ""
+	}
+	return fmt.Sprintf(`{\an%d}`, alignment)
+}
@@ -0,0 +1,129 @@
+package subtitle
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var reSRTTiming = regexp.MustCompile(`^\s*(\d+):(\d{2}):(\d{2})[,.](\d{1,3})\s*-->\s*(\d+):(\d{2}):(\d{2})[,.](\d{1,3})`)
+
+// parseSRT parses a SubRip subtitle. Cues are found by their timing lines,
+// so blank lines inside the text of a cue are accepted
+func parseSRT(text string) (*Subtitle, error) {
+	sub := &Subtitle{}
+	lines := strings.Split(text, "\n")
+
+	var cue *Cue
+	var cueLines []string
+	finish := func() {
+		if cue == nil {
+			return
+		}
+		cue.Raw = strings.Join(trimBlankLines(cueLines), "\n")
+		cue.Text, _ = parseHTMLText(cue.Raw, false)
+		sub.Cues = append(sub.Cues, cue)
+	}
+
+	for i, line := range lines {
+		m := reSRTTiming.FindStringSubmatch(line)
+		if m == nil {
+			if cue != nil {
+				cueLines = append(cueLines, line)
+			}
+			continue
+		}
+
+		// The counter before the timing line belongs to the new cue
+		if cue != nil && i > 0 && isNumber(strings.TrimSpace(lines[i-1])) {
+			cueLines = cueLines[:len(cueLines)-1]
+		}
+		finish()
+
+		cue = &Cue{
+			Start: srtDuration(m[1:5]),
+			End:   srtDuration(m[5:9]),
+		}
+		cueLines = nil
+	}
+	finish()
+
+	if len(sub.Cues) == 0 {
+		return nil, errors.New("no cues found")
+	}
+
+	return sub, nil
+}
+
+// srtDuration converts the hours, minutes, seconds and milliseconds of a timing
+func srtDuration(parts []string) time.Duration {
+	h, _ := strconv.Atoi(parts[0])
+	m, _ := strconv.Atoi(parts[1])
+	s, _ := strconv.Atoi(parts[2])
+
+	// Some files have less than 3 digits for the milliseconds
+	ms, _ := strconv.Atoi((parts[3] + "00")[:3])
+
+	return time.Duration(h)*time.Hour +
+		time.Duration(m)*time.Minute +
+		time.Duration(s)*time.Second +
+		time.Duration(ms)*time.Millisecond
+}
+
+func writeSRT(w io.Writer, s *Subtitle) error {
+	b := bufio.NewWriter(w)
+
+	n := 0
+	for _, c := range s.Cues {
+		if c.Comment {
+			continue
+		}
+		n++
+
+		text := rawText(s, c, SRT, func(spans []Span) string {
+			return renderHTMLText(spans, false)
+		})
+		fmt.Fprintf(b, "%d\n%s --> %s\n%s\n\n", n, formatSRTTime(c.Start), formatSRTTime(c.End), text)
+	}
+
+	return b.Flush()
+}
+
+func formatSRTTime(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, ms)
+}
+
+// splitDuration splits a duration in hours, minutes, seconds and milliseconds.
+// Negative durations are treated as zero
+func splitDuration(d time.Duration) (h, m, s, ms int) {
+	if d < 0 {
+		d = 0
+	}
+
+	ms = int(d / time.Millisecond)
+	h = ms / int(time.Hour/time.Millisecond)
+	ms -= h * int(time.Hour/time.Millisecond)
+	m = ms / int(time.Minute/time.Millisecond)
+	ms -= m * int(time.Minute/time.Millisecond)
+	s = ms / int(time.Second/time.Millisecond)
+	ms -= s * int(time.Second/time.Millisecond)
+
+	return h, m, s, ms
+}
+
+// trimBlankLines removes blank lines from the start and end of lines
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
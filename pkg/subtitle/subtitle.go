@@ -0,0 +1,255 @@
+package subtitle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Format is a subtitle file format, named after its usual extension
+type Format string
+
+// Supported formats
+const (
+	SRT Format = "srt" // SubRip
+	ASS Format = "ass" // Advanced SubStation Alpha
+	SSA Format = "ssa" // SubStation Alpha (v4.00)
+	VTT Format = "vtt" // WebVTT
+)
+
+// ErrUnknownFormat is returned when a subtitle format can't be recognized
+var ErrUnknownFormat = errors.New("unknown subtitle format")
+
+// Subtitle is a subtitle file, in a model shared between all formats
+type Subtitle struct {
+	Format Format     // Format the subtitle was parsed from
+	Info   []Property // Script info for ASS/SSA, header values for WebVTT
+	Styles []*Style   // Styles declared in the file. Only ASS/SSA have styles
+	Cues   []*Cue     // Cues, in the order found in the file
+
+	// Sections that are not interpreted, but kept so they can be written back
+	// (ASS [Fonts] and [Graphics], WebVTT STYLE and REGION blocks)
+	Extra []Section
+}
+
+// Property is a key-value pair
+type Property struct {
+	Key   string
+	Value string
+}
+
+// Section is a block of lines that are not interpreted
+type Section struct {
+	Name  string
+	Lines []string
+}
+
+// Style is a named set of attributes cues can refer to
+type Style struct {
+	Name         string
+	FontName     string
+	FontSize     float64
+	PrimaryColor string // Main text color in the form "#rrggbb"
+	Bold         bool
+	Italic       bool
+	Underline    bool
+	StrikeOut    bool
+	Alignment    int // Position on the screen, laid out as a numeric keypad (1 is bottom left, 5 is center)
+
+	// Every field found in the file, keyed by its lowercase name
+	Fields map[string]string
+}
+
+// Cue is a piece of text shown on the screen for a period of time
+type Cue struct {
+	Start    time.Duration
+	End      time.Duration
+	ID       string // Cue identifier (WebVTT). "" if none
+	Style    string // Name of the style used by this cue (ASS/SSA). "" if none
+	Name     string // Name of the speaker (ASS/SSA Name field, WebVTT voice tag). "" if none
+	Layer    int    // Layer of the cue (ASS)
+	MarginL  int    // Left margin override (ASS/SSA). 0 if none
+	MarginR  int    // Right margin override (ASS/SSA). 0 if none
+	MarginV  int    // Vertical margin override (ASS/SSA). 0 if none
+	Effect   string // Transition effect (ASS/SSA). "" if none
+	Settings string // Cue settings, like position and alignment (WebVTT). "" if none
+	Comment  bool   // Is this cue commented out? (ASS/SSA)
+	Text     []Span // Text of the cue. Line breaks are represented by "\n"
+
+	// Text as found in the source, with the markup of Subtitle.Format. Writers
+	// use it when writing to the same format, to keep tags Span can't represent.
+	// Clear it after changing Text
+	Raw string
+}
+
+// Span is a piece of text sharing the same inline style
+type Span struct {
+	Text      string
+	Italic    bool
+	Bold      bool
+	Underline bool
+	StrikeOut bool
+	Color     string // Text color in the form "#rrggbb". "" for the default
+}
+
+// PlainText returns the text of the cue without any styling
+func (c *Cue) PlainText() string {
+	return PlainText(c.Text)
+}
+
+// PlainText returns the text of the spans without any styling
+func PlainText(spans []Span) string {
+	var b strings.Builder
+	for _, s := range spans {
+		b.WriteString(s.Text)
+	}
+	return b.String()
+}
+
+// GetStyle returns the style with the given name. nil if there is none
+func (s *Subtitle) GetStyle(name string) *Style {
+	for _, style := range s.Styles {
+		if strings.EqualFold(style.Name, name) {
+			return style
+		}
+	}
+	return nil
+}
+
+// FormatFromExtension returns the format of a file extension (with or without the dot)
+func FormatFromExtension(ext string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "srt":
+		return SRT, nil
+	case "ass":
+		return ASS, nil
+	case "ssa":
+		return SSA, nil
+	case "vtt", "webvtt":
+		return VTT, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+var reDetectSRT = regexp.MustCompile(`(?m)^\s*\d+:\d{2}:\d{2}[,.]\d{1,3}\s*-->`)
+
+// Detect guesses the format of a subtitle from its contents
+func Detect(data []byte) (Format, error) {
+	text := string(normalize(data))
+	trimmed := strings.TrimSpace(text)
+
+	switch {
+	case strings.HasPrefix(trimmed, "WEBVTT"):
+		return VTT, nil
+	case strings.Contains(text, "[V4+ Styles]"):
+		return ASS, nil
+	case strings.Contains(text, "[V4 Styles]"):
+		return SSA, nil
+	case strings.Contains(text, "[Script Info]") || strings.Contains(text, "[Events]"):
+		if strings.Contains(strings.ToLower(text), "scripttype: v4.00+") {
+			return ASS, nil
+		}
+		return SSA, nil
+	case reDetectSRT.MatchString(text):
+		return SRT, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+// Parse reads a subtitle, detecting its format
+func Parse(r io.Reader) (*Subtitle, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := Detect(data)
+	if err != nil {
+		return nil, err
+	}
+	return parse(data, format)
+}
+
+// ParseFormat reads a subtitle in the given format
+func ParseFormat(r io.Reader, format Format) (*Subtitle, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parse(data, format)
+}
+
+func parse(data []byte, format Format) (*Subtitle, error) {
+	text := string(normalize(data))
+
+	var (
+		sub *Subtitle
+		err error
+	)
+	switch format {
+	case SRT:
+		sub, err = parseSRT(text)
+	case ASS, SSA:
+		sub, err = parseASS(text)
+	case VTT:
+		sub, err = parseVTT(text)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", format, err)
+	}
+
+	sub.Format = format
+	return sub, nil
+}
+
+// Write writes a subtitle in the given format, converting it if needed. See Convert
+func Write(w io.Writer, s *Subtitle, format Format) error {
+	s = Convert(s, format)
+
+	switch format {
+	case SRT:
+		return writeSRT(w, s)
+	case ASS, SSA:
+		return writeASS(w, s, format)
+	case VTT:
+		return writeVTT(w, s)
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+// normalize removes the UTF-8 byte order mark and converts line endings to "\n"
+func normalize(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+}
+
+// rawText returns the raw text of a cue if it is written in format, or
+// renders its spans with render otherwise
+func rawText(s *Subtitle, c *Cue, format Format, render func([]Span) string) string {
+	if c.Raw != "" && sameFamily(s.Format, format) {
+		return c.Raw
+	}
+	return render(c.Text)
+}
+
+// sameFamily returns wether both formats share the same text markup
+func sameFamily(a, b Format) bool {
+	if a == SSA {
+		a = ASS
+	}
+	if b == SSA {
+		b = ASS
+	}
+	return a == b
+}
@@ -0,0 +1,274 @@
+package subtitle
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSRT = "\xef\xbb\xbf1\r\n" +
+	"00:00:01,000 --> 00:00:02,500\r\n" +
+	"Hello, <i>world</i>!\r\n" +
+	"\r\n" +
+	"2\r\n" +
+	"00:00:03,000 --> 00:00:04,000\r\n" +
+	"<font color=\"#ff0000\">Red</font> line\r\n" +
+	"second line\r\n" +
+	"\r\n"
+
+const testVTT = `WEBVTT - Test
+Kind: captions
+
+STYLE
+::cue { color: white }
+
+NOTE this is ignored
+
+intro
+00:01.000 --> 00:02.500 align:start position:10%
+<v Bob>Hello, <b>world</b> &amp; friends
+
+00:00:03.000 --> 00:00:04.000
+Plain
+`
+
+const testASS = `[Script Info]
+Title: Test
+ScriptType: v4.00+
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,20,&H00FFFFFF,&H000000FF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,2,2,10,10,10,1
+Style: Sign,Verdana,24,&H000000FF,&H000000FF,&H00000000,&H00000000,-1,0,0,0,100,100,0,0,1,2,2,8,10,10,10,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:01.00,0:00:02.50,Default,Bob,0,0,0,,Hello, {\i1}world{\i0}!\NNew line
+Comment: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,A comment
+Dialogue: 1,0:00:05.00,0:00:06.00,Sign,,0,0,0,,{\pos(10,10)\c&H00FF00&}Green
+`
+
+const testSSA = `[Script Info]
+ScriptType: v4.00
+
+[V4 Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, TertiaryColour, BackColour, Bold, Italic, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, AlphaLevel, Encoding
+Style: Default,Arial,20,255,65535,65535,0,0,-1,1,2,2,6,10,10,10,0,1
+
+[Events]
+Format: Marked, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: Marked=0,0:00:01.00,0:00:02.00,Default,,0000,0000,0000,,Top
+`
+
+func TestDetect(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]Format{
+		testSRT: SRT,
+		testVTT: VTT,
+		testASS: ASS,
+		testSSA: SSA,
+	}
+
+	for data, target := range cases {
+		value, err := Detect([]byte(data))
+		if err != nil {
+			t.Errorf(`(case: %s) %s`, target, err)
+		} else if value != target {
+			t.Errorf(`Expected format to be "%s", but got "%s"`, target, value)
+		}
+	}
+
+	if _, err := Detect([]byte("not a subtitle")); err != ErrUnknownFormat {
+		t.Errorf(`Expected ErrUnknownFormat, but got %v`, err)
+	}
+}
+
+func TestParseSRT(t *testing.T) {
+	t.Parallel()
+
+	sub := mustParse(t, testSRT)
+	assertCues(t, sub, []Cue{
+		{Start: time.Second, End: 2500 * time.Millisecond, Text: []Span{{Text: "Hello, "}, {Text: "world", Italic: true}, {Text: "!"}}},
+		{Start: 3 * time.Second, End: 4 * time.Second, Text: []Span{{Text: "Red", Color: "#ff0000"}, {Text: " line\nsecond line"}}},
+	})
+}
+
+func TestParseVTT(t *testing.T) {
+	t.Parallel()
+
+	sub := mustParse(t, testVTT)
+	assertCues(t, sub, []Cue{
+		{ID: "intro", Name: "Bob", Settings: "align:start position:10%", Start: time.Second, End: 2500 * time.Millisecond, Text: []Span{{Text: "Hello, "}, {Text: "world", Bold: true}, {Text: " & friends"}}},
+		{Start: 3 * time.Second, End: 4 * time.Second, Text: []Span{{Text: "Plain"}}},
+	})
+
+	if len(sub.Info) != 2 || sub.Info[0].Value != "- Test" || sub.Info[1].Key != "Kind" {
+		t.Errorf(`Unexpected header %#v`, sub.Info)
+	}
+	if len(sub.Extra) != 1 || sub.Extra[0].Name != "STYLE" {
+		t.Errorf(`Expected a STYLE block, but got %#v`, sub.Extra)
+	}
+}
+
+func TestParseASS(t *testing.T) {
+	t.Parallel()
+
+	sub := mustParse(t, testASS)
+	assertCues(t, sub, []Cue{
+		{Style: "Default", Name: "Bob", Start: time.Second, End: 2500 * time.Millisecond, Text: []Span{{Text: "Hello, "}, {Text: "world", Italic: true}, {Text: "!\nNew line"}}},
+		{Style: "Default", Comment: true, Start: 3 * time.Second, End: 4 * time.Second, Text: []Span{{Text: "A comment"}}},
+		{Style: "Sign", Layer: 1, Start: 5 * time.Second, End: 6 * time.Second, Text: []Span{{Text: "Green", Color: "#00ff00"}}},
+	})
+
+	sign := sub.GetStyle("sign")
+	if sign == nil {
+		t.Fatalf(`Expected style "Sign" to exist`)
+	}
+	if sign.FontName != "Verdana" || sign.FontSize != 24 || !sign.Bold || sign.Alignment != 8 || sign.PrimaryColor != "#ff0000" {
+		t.Errorf(`Unexpected style %#v`, sign)
+	}
+}
+
+func TestParseSSA(t *testing.T) {
+	t.Parallel()
+
+	sub := mustParse(t, testSSA)
+	style := sub.GetStyle("Default")
+	if style == nil || !style.Italic || style.PrimaryColor != "#ff0000" || style.Alignment != 8 {
+		t.Errorf(`Unexpected style %#v`, style)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, sub, SSA); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Style: Default,Arial,20,255,65535,65535,0,0,-1,1,2,2,6,") {
+		t.Errorf(`Expected SSA style to be kept, but got:\n%s`, buf.String())
+	}
+}
+
+// Writing a subtitle and parsing it back must give the same cues, in every format
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{testSRT, testVTT, testASS} {
+		original := mustParse(t, data)
+
+		for _, format := range []Format{SRT, VTT, ASS, SSA} {
+			sub := Convert(original, format)
+
+			var buf bytes.Buffer
+			if err := Write(&buf, sub, format); err != nil {
+				t.Fatal(err)
+			}
+
+			value, err := ParseFormat(&buf, format)
+			if err != nil {
+				t.Fatalf(`(case: %s -> %s) %s`, original.Format, format, err)
+			}
+
+			j := 0
+			for _, cue := range sub.Cues {
+				if cue.Comment && (format == SRT || format == VTT) {
+					continue
+				}
+				got := value.Cues[j]
+				j++
+				if got.Start != cue.Start || got.End != cue.End {
+					t.Errorf(`(case: %s -> %s) Expected timing %s --> %s, but got %s --> %s`, original.Format, format, cue.Start, cue.End, got.Start, got.End)
+				}
+				if !spansEqual(got.Text, cue.Text) {
+					t.Errorf(`(case: %s -> %s) Expected text %#v, but got %#v`, original.Format, format, cue.Text, got.Text)
+				}
+			}
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		data   string
+		format Format
+		target []string
+	}{
+		{testASS, SRT, []string{
+			"Hello, <i>world</i>!\nNew line",
+			"{\\an8}<font color=\"#00ff00\"><b>Green</b></font>",
+		}},
+		{testASS, VTT, []string{
+			"<v Bob>Hello, <i>world</i>!\nNew line",
+			"00:00:05.000 --> 00:00:06.000 line:0\n<c.lime><b>Green</b></c>",
+		}},
+		{testVTT, ASS, []string{
+			"Dialogue: 0,0:00:01.00,0:00:02.50,Default,Bob,0,0,0,,{\\an1}Hello, {\\b1}world{\\b0} & friends",
+		}},
+		{testVTT, SRT, []string{
+			"{\\an1}Hello, <b>world</b> & friends",
+		}},
+	}
+
+	for _, c := range cases {
+		sub := mustParse(t, c.data)
+
+		var buf bytes.Buffer
+		if err := Write(&buf, sub, c.format); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, target := range c.target {
+			if !strings.Contains(buf.String(), target) {
+				t.Errorf(`(case: %s -> %s) Expected output to contain %#v, but got:\n%s`, sub.Format, c.format, target, buf.String())
+			}
+		}
+		if c.format != ASS && strings.Contains(buf.String(), "A comment") {
+			t.Errorf(`(case: %s -> %s) Expected comments to be dropped`, sub.Format, c.format)
+		}
+	}
+}
+
+func mustParse(t *testing.T, data string) *Subtitle {
+	t.Helper()
+
+	sub, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func assertCues(t *testing.T, sub *Subtitle, target []Cue) {
+	t.Helper()
+
+	if len(sub.Cues) != len(target) {
+		t.Fatalf(`Expected %d cues, but got %d`, len(target), len(sub.Cues))
+	}
+
+	for i, value := range sub.Cues {
+		value := *value
+		value.Raw = ""
+		if !spansEqual(value.Text, target[i].Text) {
+			t.Errorf(`(cue %d) Expected text %#v, but got %#v`, i, target[i].Text, value.Text)
+		}
+		value.Text, target[i].Text = nil, nil
+		if !reflect.DeepEqual(value, target[i]) {
+			t.Errorf(`(cue %d) Expected %#v, but got %#v`, i, target[i], value)
+		}
+	}
+}
+
+func spansEqual(a, b []Span) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
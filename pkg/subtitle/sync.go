@@ -0,0 +1,206 @@
+package subtitle
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Alignment is a linear correction of timings: t' = t*Scale + Offset
+type Alignment struct {
+	Offset  time.Duration // Constant delay added to every timing
+	Scale   float64       // Drift between both subtitles, as a speed ratio. 1 if there's none
+	Matched int           // Number of cues that start together with a reference cue after the correction
+	Total   int           // Number of cues considered
+}
+
+// Confidence returns the fraction of cues that match the reference after the correction
+func (a Alignment) Confidence() float64 {
+	if a.Total == 0 {
+		return 0
+	}
+	return float64(a.Matched) / float64(a.Total)
+}
+
+// Apply corrects the timings of every cue in s
+func (a Alignment) Apply(s *Subtitle) {
+	for _, c := range s.Cues {
+		c.Start = a.apply(c.Start)
+		c.End = a.apply(c.End)
+	}
+}
+
+func (a Alignment) apply(d time.Duration) time.Duration {
+	res := time.Duration(float64(d)*a.Scale) + a.Offset
+	if res < 0 {
+		return 0
+	}
+	return res
+}
+
+const (
+	// Offsets are voted in buckets of this size
+	syncBucket = 100 * time.Millisecond
+	// Largest offset that is considered
+	syncMaxOffset = 10 * time.Minute
+	// Maximum distance between two cue starts for them to be considered the same line
+	syncTolerance = 500 * time.Millisecond
+)
+
+// syncScales are the drifts tried: no drift, conversions between
+// the usual frame rates and small drifts around 1
+var syncScales = func() []float64 {
+	scales := []float64{1}
+	rates := []float64{23.976, 24, 25, 29.97, 30}
+	for _, a := range rates {
+		for _, b := range rates {
+			if a != b {
+				scales = append(scales, a/b)
+			}
+		}
+	}
+	for i := 1; i <= 4; i++ {
+		scales = append(scales, 1+float64(i)*0.0005, 1-float64(i)*0.0005)
+	}
+	return scales
+}()
+
+// Align finds the constant offset and linear drift that make the cue starts of s match
+// the ones of reference the most. Both subtitles may be in different languages, as long
+// as they are mostly split in the same lines
+func Align(reference, s *Subtitle) Alignment {
+	ref := cueStarts(reference)
+	target := cueStarts(s)
+
+	best := Alignment{Scale: 1, Total: len(target)}
+	if len(ref) == 0 || len(target) == 0 {
+		return best
+	}
+
+	// Every pair of close enough cues votes on an offset, for every scale
+	buckets := int(syncMaxOffset / syncBucket)
+	votes := make([]int, 2*buckets+1)
+	bestVotes := 0
+	for _, scale := range syncScales {
+		for i := range votes {
+			votes[i] = 0
+		}
+
+		for _, t := range target {
+			scaled := time.Duration(float64(t) * scale)
+			first := sort.Search(len(ref), func(i int) bool {
+				return ref[i] >= scaled-syncMaxOffset
+			})
+			for _, r := range ref[first:] {
+				if r > scaled+syncMaxOffset {
+					break
+				}
+				bucket := int(math.Round(float64(r-scaled) / float64(syncBucket)))
+				votes[bucket+buckets]++
+			}
+		}
+
+		for i := range votes {
+			// Votes are smoothed with the neighboring buckets
+			n := votes[i]
+			if i > 0 {
+				n += votes[i-1]
+			}
+			if i < len(votes)-1 {
+				n += votes[i+1]
+			}
+
+			if n > bestVotes || (n == bestVotes && math.Abs(scale-1) < math.Abs(best.Scale-1)) {
+				bestVotes = n
+				best.Scale = scale
+				best.Offset = time.Duration(i-buckets) * syncBucket
+			}
+		}
+	}
+
+	// Refine the result with a linear regression over the matching cues
+	for i := 0; i < 3; i++ {
+		refined, ok := regression(ref, target, best)
+		if !ok {
+			break
+		}
+		if count := countMatches(ref, target, refined); count >= countMatches(ref, target, best) {
+			best = refined
+		}
+	}
+	best.Matched = countMatches(ref, target, best)
+
+	return best
+}
+
+// cueStarts returns the sorted start times of the cues that are not comments
+func cueStarts(s *Subtitle) []time.Duration {
+	res := make([]time.Duration, 0, len(s.Cues))
+	for _, c := range s.Cues {
+		if !c.Comment {
+			res = append(res, c.Start)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// nearest returns the element of sorted closest to d
+func nearest(sorted []time.Duration, d time.Duration) time.Duration {
+	i := sort.Search(len(sorted), func(i int) bool {
+		return sorted[i] >= d
+	})
+	if i == len(sorted) {
+		return sorted[i-1]
+	}
+	if i > 0 && d-sorted[i-1] < sorted[i]-d {
+		return sorted[i-1]
+	}
+	return sorted[i]
+}
+
+func countMatches(ref, target []time.Duration, a Alignment) int {
+	n := 0
+	for _, t := range target {
+		moved := time.Duration(float64(t)*a.Scale) + a.Offset
+		if diff := nearest(ref, moved) - moved; diff <= syncTolerance && diff >= -syncTolerance {
+			n++
+		}
+	}
+	return n
+}
+
+// regression fits a line through the cues that match under a, by least squares
+func regression(ref, target []time.Duration, a Alignment) (Alignment, bool) {
+	var n, sx, sy, sxx, sxy float64
+	for _, t := range target {
+		moved := time.Duration(float64(t)*a.Scale) + a.Offset
+		r := nearest(ref, moved)
+		if diff := r - moved; diff > syncTolerance || diff < -syncTolerance {
+			continue
+		}
+
+		x, y := float64(t), float64(r)
+		n++
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+
+	denominator := n*sxx - sx*sx
+	if n < 2 || denominator == 0 {
+		return a, false
+	}
+
+	scale := (n*sxy - sx*sy) / denominator
+	offset := (sy - scale*sx) / n
+
+	return Alignment{
+		Scale:  scale,
+		Offset: time.Duration(offset),
+		Total:  a.Total,
+	}, true
+}
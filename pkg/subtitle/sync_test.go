@@ -0,0 +1,66 @@
+package subtitle
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var syncTestCases = []struct {
+	offset time.Duration
+	scale  float64
+}{
+	{0, 1},
+	{2500 * time.Millisecond, 1},
+	{-7 * time.Second, 1},
+	{1200 * time.Millisecond, 25 / 23.976},
+	{-3 * time.Second, 23.976 / 25},
+	{90 * time.Second, 1.0007},
+}
+
+func TestAlign(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range syncTestCases {
+		rng := rand.New(rand.NewSource(int64(c.offset)))
+
+		// The reference has cues every 2 to 6 seconds for about an hour
+		reference := &Subtitle{}
+		at := 10 * time.Second
+		for i := 0; i < 900; i++ {
+			at += time.Duration(2000+rng.Intn(4000)) * time.Millisecond
+			reference.Cues = append(reference.Cues, &Cue{Start: at, End: at + time.Second})
+		}
+
+		// The target is shifted, with slightly different timings and some lines split or missing
+		s := &Subtitle{}
+		for _, cue := range reference.Cues {
+			if rng.Intn(10) == 0 {
+				continue
+			}
+			jitter := time.Duration(rng.Intn(160)-80) * time.Millisecond
+			start := time.Duration(float64(cue.Start-c.offset)/c.scale) + jitter
+			s.Cues = append(s.Cues, &Cue{Start: start, End: start + time.Second})
+			if rng.Intn(10) == 0 {
+				s.Cues = append(s.Cues, &Cue{Start: start + 1500*time.Millisecond, End: start + 2*time.Second})
+			}
+		}
+
+		a := Align(reference, s)
+		if diff := a.Offset - c.offset; diff > 200*time.Millisecond || diff < -200*time.Millisecond {
+			t.Errorf(`(case: %s, %f) Expected offset to be %s, but got %s`, c.offset, c.scale, c.offset, a.Offset)
+		}
+		if math.Abs(a.Scale-c.scale) > 0.0001 {
+			t.Errorf(`(case: %s, %f) Expected scale to be %f, but got %f`, c.offset, c.scale, c.scale, a.Scale)
+		}
+		if a.Confidence() < 0.8 {
+			t.Errorf(`(case: %s, %f) Expected confidence to be at least 0.8, but got %f`, c.offset, c.scale, a.Confidence())
+		}
+
+		a.Apply(s)
+		if diff := s.Cues[len(s.Cues)-1].Start - reference.Cues[len(reference.Cues)-1].Start; diff > time.Second || diff < -time.Second {
+			t.Errorf(`(case: %s, %f) Expected the last cue to be aligned, but it's %s away`, c.offset, c.scale, diff)
+		}
+	}
+}
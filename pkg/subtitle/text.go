@@ -0,0 +1,420 @@
+package subtitle
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// textState is the inline style in effect while parsing a cue's text
+type textState struct {
+	italic    int
+	bold      int
+	underline int
+	strikeOut int
+	colors    []string
+}
+
+func (s *textState) span(text string) Span {
+	color := ""
+	if len(s.colors) > 0 {
+		color = s.colors[len(s.colors)-1]
+	}
+
+	return Span{
+		Text:      text,
+		Italic:    s.italic > 0,
+		Bold:      s.bold > 0,
+		Underline: s.underline > 0,
+		StrikeOut: s.strikeOut > 0,
+		Color:     color,
+	}
+}
+
+// toggle increments or decrements a tag counter, never going below zero
+func toggle(counter *int, open bool) {
+	if open {
+		*counter++
+	} else if *counter > 0 {
+		*counter--
+	}
+}
+
+// appendSpan appends a span, merging it with the last one if they share the same style
+func appendSpan(spans []Span, s Span) []Span {
+	if s.Text == "" {
+		return spans
+	}
+
+	if len(spans) > 0 {
+		last := spans[len(spans)-1]
+		last.Text = s.Text
+		if last == s {
+			spans[len(spans)-1].Text += s.Text
+			return spans
+		}
+	}
+	return append(spans, s)
+}
+
+var reFontColor = regexp.MustCompile(`(?i)color\s*=\s*["']?([^"'\s>]+)`)
+
+// parseHTMLText parses the HTML-like markup used by SRT and WebVTT.
+// Unknown tags are dropped. The voice of WebVTT <v> tags is returned
+func parseHTMLText(text string, vtt bool) (spans []Span, voice string) {
+	state := &textState{}
+	var buf strings.Builder
+
+	flush := func() {
+		str := buf.String()
+		buf.Reset()
+		if vtt {
+			str = html.UnescapeString(str)
+		}
+		spans = appendSpan(spans, state.span(str))
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '<':
+			end := strings.IndexByte(text[i:], '>')
+			if end < 0 {
+				buf.WriteByte(text[i])
+				continue
+			}
+			flush()
+
+			tag := text[i+1 : i+end]
+			i += end
+
+			closing := strings.HasPrefix(tag, "/")
+			tag = strings.TrimPrefix(tag, "/")
+			name := strings.ToLower(strings.FieldsFunc(tag+" ", func(r rune) bool {
+				return r == ' ' || r == '.' || r == '\t'
+			})[0])
+
+			switch name {
+			case "i":
+				toggle(&state.italic, !closing)
+			case "b":
+				toggle(&state.bold, !closing)
+			case "u":
+				toggle(&state.underline, !closing)
+			case "s":
+				toggle(&state.strikeOut, !closing)
+			case "c":
+				// WebVTT color classes
+				if closing {
+					if len(state.colors) > 0 {
+						state.colors = state.colors[:len(state.colors)-1]
+					}
+				} else {
+					color := ""
+					for _, class := range strings.Split(strings.Fields(tag)[0], ".")[1:] {
+						if c, ok := vttColors[class]; ok {
+							color = c
+						}
+					}
+					state.colors = append(state.colors, color)
+				}
+			case "font":
+				if closing {
+					if len(state.colors) > 0 {
+						state.colors = state.colors[:len(state.colors)-1]
+					}
+				} else {
+					color := ""
+					if m := reFontColor.FindStringSubmatch(tag); m != nil {
+						color = normalizeColor(m[1])
+					}
+					state.colors = append(state.colors, color)
+				}
+			case "v":
+				if parts := strings.SplitN(tag, " ", 2); !closing && vtt && len(parts) == 2 {
+					voice = strings.TrimSpace(parts[1])
+				}
+			}
+
+		case text[i] == '{' && i+1 < len(text) && text[i+1] == '\\':
+			// ASS override tags are commonly found inside SRT files
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				buf.WriteByte(text[i])
+				continue
+			}
+			flush()
+			applyASSOverrides(state, text[i+1:i+end])
+			i += end
+
+		default:
+			buf.WriteByte(text[i])
+		}
+	}
+	flush()
+
+	return spans, voice
+}
+
+// renderHTMLText renders spans with the markup used by SRT and WebVTT
+func renderHTMLText(spans []Span, vtt bool) string {
+	var b strings.Builder
+
+	for _, s := range spans {
+		var open, close []string
+		if s.Color != "" && !vtt {
+			open = append(open, fmt.Sprintf(`<font color="%s">`, s.Color))
+			close = append([]string{"</font>"}, close...)
+		} else if class := vttColorClass(s.Color); class != "" && vtt {
+			open = append(open, "<c."+class+">")
+			close = append([]string{"</c>"}, close...)
+		}
+		for _, tag := range []struct {
+			set  bool
+			name string
+		}{{s.Bold, "b"}, {s.Italic, "i"}, {s.Underline, "u"}, {s.StrikeOut, "s"}} {
+			if tag.set {
+				open = append(open, "<"+tag.name+">")
+				close = append([]string{"</" + tag.name + ">"}, close...)
+			}
+		}
+
+		text := s.Text
+		if vtt {
+			text = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+		}
+
+		b.WriteString(strings.Join(open, ""))
+		b.WriteString(text)
+		b.WriteString(strings.Join(close, ""))
+	}
+
+	return b.String()
+}
+
+// parseASSText parses the markup used by ASS/SSA. Unknown override tags are dropped
+func parseASSText(text string) []Span {
+	state := &textState{}
+	var spans []Span
+	var buf strings.Builder
+
+	flush := func() {
+		spans = appendSpan(spans, state.span(buf.String()))
+		buf.Reset()
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				buf.WriteByte(text[i])
+				continue
+			}
+			flush()
+			// Blocks without tags are comments, which are also dropped
+			applyASSOverrides(state, text[i+1:i+end])
+			i += end
+
+		case '\\':
+			if i+1 >= len(text) {
+				buf.WriteByte(text[i])
+				continue
+			}
+			switch text[i+1] {
+			case 'N', 'n':
+				buf.WriteByte('\n')
+				i++
+			case 'h':
+				buf.WriteString(" ")
+				i++
+			default:
+				buf.WriteByte(text[i])
+			}
+
+		default:
+			buf.WriteByte(text[i])
+		}
+	}
+	flush()
+
+	return spans
+}
+
+// applyASSOverrides applies the styling tags of an ASS override block (without braces)
+func applyASSOverrides(state *textState, block string) {
+	set := func(counter *int, value string) {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return
+		}
+		if n != 0 {
+			*counter = 1
+		} else {
+			*counter = 0
+		}
+	}
+
+	for _, tag := range strings.Split(block, `\`) {
+		switch {
+		case tag == "":
+			continue
+		case tag[0] == 'r':
+			*state = textState{}
+		case tag[0] == 'i' && isNumber(tag[1:]):
+			set(&state.italic, tag[1:])
+		case tag[0] == 'b' && isNumber(tag[1:]):
+			// Bold may also be given as a font weight
+			if n, _ := strconv.Atoi(tag[1:]); n > 1 && n < 600 {
+				state.bold = 0
+			} else {
+				set(&state.bold, tag[1:])
+			}
+		case tag[0] == 'u' && isNumber(tag[1:]):
+			set(&state.underline, tag[1:])
+		case tag[0] == 's' && isNumber(tag[1:]):
+			set(&state.strikeOut, tag[1:])
+		case strings.HasPrefix(tag, "1c") || (tag[0] == 'c' && (len(tag) == 1 || tag[1] == '&')):
+			value := strings.TrimPrefix(strings.TrimPrefix(tag, "1"), "c")
+			state.colors = nil
+			if color := parseASSColor(value); color != "" {
+				state.colors = []string{color}
+			}
+		}
+	}
+}
+
+// renderASSText renders spans with the markup used by ASS/SSA
+func renderASSText(spans []Span) string {
+	var b strings.Builder
+	var current Span
+
+	for _, s := range spans {
+		var tags []string
+		for _, tag := range []struct {
+			value, current bool
+			name           string
+		}{
+			{s.Italic, current.Italic, "i"},
+			{s.Bold, current.Bold, "b"},
+			{s.Underline, current.Underline, "u"},
+			{s.StrikeOut, current.StrikeOut, "s"},
+		} {
+			if tag.value != tag.current {
+				tags = append(tags, fmt.Sprintf(`\%s%d`, tag.name, boolToInt(tag.value)))
+			}
+		}
+		if s.Color != current.Color {
+			if s.Color == "" {
+				tags = append(tags, `\c`)
+			} else {
+				tags = append(tags, `\c`+formatASSColor(s.Color))
+			}
+		}
+
+		if len(tags) > 0 {
+			b.WriteString("{" + strings.Join(tags, "") + "}")
+		}
+		b.WriteString(strings.ReplaceAll(s.Text, "\n", `\N`))
+		current = s
+	}
+
+	return b.String()
+}
+
+// parseASSColor parses colors in the form "&HAABBGGRR&", "&HBBGGRR"
+// or as a decimal number, returning it in the form "#rrggbb"
+func parseASSColor(value string) string {
+	value = strings.TrimSuffix(strings.TrimSpace(value), "&")
+
+	var n uint64
+	var err error
+	if upper := strings.ToUpper(value); strings.HasPrefix(upper, "&H") {
+		n, err = strconv.ParseUint(value[2:], 16, 32)
+	} else {
+		var signed int64
+		signed, err = strconv.ParseInt(value, 10, 64)
+		n = uint64(signed)
+	}
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x", n&0xff, (n>>8)&0xff, (n>>16)&0xff)
+}
+
+// formatASSColor formats a "#rrggbb" color in the form "&HBBGGRR&"
+func formatASSColor(color string) string {
+	color = strings.TrimPrefix(color, "#")
+	if len(color) != 6 {
+		return "&HFFFFFF&"
+	}
+	return strings.ToUpper("&H" + color[4:6] + color[2:4] + color[0:2] + "&")
+}
+
+var colorNames = map[string]string{
+	"white":   "#ffffff",
+	"black":   "#000000",
+	"red":     "#ff0000",
+	"lime":    "#00ff00",
+	"green":   "#008000",
+	"blue":    "#0000ff",
+	"yellow":  "#ffff00",
+	"cyan":    "#00ffff",
+	"magenta": "#ff00ff",
+	"gray":    "#808080",
+	"grey":    "#808080",
+	"orange":  "#ffa500",
+}
+
+// vttColors are the color classes every WebVTT player knows
+var vttColors = map[string]string{
+	"white":   "#ffffff",
+	"lime":    "#00ff00",
+	"cyan":    "#00ffff",
+	"red":     "#ff0000",
+	"yellow":  "#ffff00",
+	"magenta": "#ff00ff",
+	"blue":    "#0000ff",
+	"black":   "#000000",
+}
+
+// vttColorClass returns the WebVTT class of a color. "" if there is none
+func vttColorClass(color string) string {
+	for class, c := range vttColors {
+		if c == color {
+			return class
+		}
+	}
+	return ""
+}
+
+// normalizeColor converts HTML colors to the form "#rrggbb". "" if it's not a known color
+func normalizeColor(color string) string {
+	color = strings.ToLower(strings.TrimSpace(color))
+	if named, ok := colorNames[color]; ok {
+		return named
+	}
+
+	color = strings.TrimPrefix(color, "#")
+	if len(color) == 3 {
+		color = string([]byte{color[0], color[0], color[1], color[1], color[2], color[2]})
+	}
+	if _, err := strconv.ParseUint(color, 16, 32); err != nil || len(color) != 6 {
+		return ""
+	}
+	return "#" + color
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package subtitle
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var reVTTTiming = regexp.MustCompile(`^\s*((?:\d+:)?\d{2}:\d{2}\.\d{3})\s+-->\s+((?:\d+:)?\d{2}:\d{2}\.\d{3})(.*)$`)
+
+// parseVTT parses a WebVTT subtitle. Blocks are separated by blank lines
+func parseVTT(text string) (*Subtitle, error) {
+	if !strings.HasPrefix(text, "WEBVTT") {
+		return nil, errors.New(`missing "WEBVTT" header`)
+	}
+
+	sub := &Subtitle{}
+	blocks := splitBlocks(text)
+
+	// Header: "WEBVTT [title]", optionally followed by "Key: Value" lines
+	header := blocks[0]
+	if title := strings.TrimSpace(strings.TrimPrefix(header[0], "WEBVTT")); title != "" {
+		sub.Info = append(sub.Info, Property{Key: "Title", Value: title})
+	}
+	for _, line := range header[1:] {
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
+			sub.Info = append(sub.Info, Property{
+				Key:   strings.TrimSpace(parts[0]),
+				Value: strings.TrimSpace(parts[1]),
+			})
+		}
+	}
+
+	for _, block := range blocks[1:] {
+		first := strings.TrimSpace(block[0])
+		switch {
+		case first == "NOTE" || strings.HasPrefix(first, "NOTE ") || strings.HasPrefix(first, "NOTE\t"):
+			continue
+		case first == "STYLE" || first == "REGION":
+			sub.Extra = append(sub.Extra, Section{Name: first, Lines: block[1:]})
+			continue
+		}
+
+		cue := &Cue{}
+		timing := 0
+		if !reVTTTiming.MatchString(block[0]) {
+			cue.ID = block[0]
+			timing = 1
+		}
+		if timing >= len(block) {
+			continue
+		}
+
+		m := reVTTTiming.FindStringSubmatch(block[timing])
+		if m == nil {
+			return nil, fmt.Errorf(`invalid timing "%s"`, block[timing])
+		}
+		cue.Start = vttDuration(m[1])
+		cue.End = vttDuration(m[2])
+		cue.Settings = strings.TrimSpace(m[3])
+
+		cue.Raw = strings.Join(block[timing+1:], "\n")
+		cue.Text, cue.Name = parseHTMLText(cue.Raw, true)
+		sub.Cues = append(sub.Cues, cue)
+	}
+
+	return sub, nil
+}
+
+// splitBlocks splits text into groups of lines separated by blank lines
+func splitBlocks(text string) [][]string {
+	var blocks [][]string
+	var current []string
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, current)
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		blocks = append(blocks, current)
+	}
+
+	return blocks
+}
+
+// vttDuration parses a timestamp in the form "[hh:]mm:ss.ttt"
+func vttDuration(str string) time.Duration {
+	parts := strings.Split(str, ":")
+	if len(parts) == 2 {
+		parts = append([]string{"0"}, parts...)
+	}
+
+	h, _ := strconv.Atoi(parts[0])
+	m, _ := strconv.Atoi(parts[1])
+	secs := strings.SplitN(parts[2], ".", 2)
+	s, _ := strconv.Atoi(secs[0])
+	ms, _ := strconv.Atoi(secs[1])
+
+	return time.Duration(h)*time.Hour +
+		time.Duration(m)*time.Minute +
+		time.Duration(s)*time.Second +
+		time.Duration(ms)*time.Millisecond
+}
+
+func writeVTT(w io.Writer, s *Subtitle) error {
+	b := bufio.NewWriter(w)
+
+	b.WriteString("WEBVTT")
+	var header []Property
+	for _, p := range s.Info {
+		if p.Key == "Title" && s.Format == VTT {
+			b.WriteString(" " + p.Value)
+		} else if s.Format == VTT {
+			header = append(header, p)
+		}
+	}
+	b.WriteString("\n")
+	for _, p := range header {
+		fmt.Fprintf(b, "%s: %s\n", p.Key, p.Value)
+	}
+	b.WriteString("\n")
+
+	for _, section := range s.Extra {
+		if section.Name != "STYLE" && section.Name != "REGION" {
+			continue
+		}
+		fmt.Fprintf(b, "%s\n%s\n\n", section.Name, strings.Join(section.Lines, "\n"))
+	}
+
+	for _, c := range s.Cues {
+		if c.Comment {
+			continue
+		}
+
+		if c.ID != "" {
+			b.WriteString(c.ID + "\n")
+		}
+		b.WriteString(formatVTTTime(c.Start) + " --> " + formatVTTTime(c.End))
+		if c.Settings != "" {
+			b.WriteString(" " + c.Settings)
+		}
+		b.WriteString("\n")
+
+		text := rawText(s, c, VTT, func(spans []Span) string {
+			text := renderHTMLText(spans, true)
+			if c.Name != "" {
+				text = "<v " + c.Name + ">" + text
+			}
+			return text
+		})
+		// Blank lines would end the cue
+		text = strings.Join(removeBlankLines(text), "\n")
+		b.WriteString(text + "\n\n")
+	}
+
+	return b.Flush()
+}
+
+func formatVTTTime(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+}
+
+// removeBlankLines splits text in lines, leaving out the blank ones
+func removeBlankLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}